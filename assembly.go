@@ -0,0 +1,43 @@
+package fraudproofs
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/lazyledger/smt"
+)
+
+// AddTransaction appends a transaction to the block, for blocks assembled interactively: the transactions of the block
+// are checked and applied again from its pre-state root along with the new one, and its intermediate state roots, state
+// root and data root are derived again, so the block is the same as if NewBlock had created it with every transaction.
+// The state tree must hold the state following the block, and holds the state following the new transaction on success;
+// on error, the block and the state tree are left unchanged. The block loses its signature, if any.
+func (b *Block) AddTransaction(t Transaction, stateTree *smt.SparseMerkleTree) error {
+	if stateTree == nil {
+		return ErrNilStateTree
+	}
+	if b.transactionHashes != nil {
+		return errors.New("block does not hold the bodies of its transactions")
+	}
+	postStateRoot := b.stateRoot
+	if postStateRoot == nil {
+		postStateRoot = b.preStateRoot // the block does not write to the state
+	}
+	if !bytes.Equal(stateTree.Root(), postStateRoot) {
+		return errors.New("state tree does not match the state root of the block")
+	}
+
+	stateTree.SetRoot(b.preStateRoot)
+	opts := append(b.options(), WithMaxBlockBytes(b.maxBlockBytes), WithStrictMode(b.strictMode))
+	rebuilt, err := NewBlock(append(append([]Transaction{}, b.transactions...), t), stateTree, opts...)
+	if err != nil {
+		stateTree.SetRoot(postStateRoot)
+		return err
+	}
+	b.transactions, b.interStateRoots, b.numTransactions = rebuilt.transactions, rebuilt.interStateRoots,
+		rebuilt.numTransactions
+	b.stateRoot, b.dataRoot, b.writeKeysRoot = rebuilt.stateRoot, rebuilt.dataRoot, rebuilt.writeKeysRoot
+	b.dataTree = nil
+	b.proposer, b.signature = nil, nil
+	return nil
+}
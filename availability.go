@@ -0,0 +1,77 @@
+package fraudproofs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ErrChunkUnavailable is returned when a chunk sampled by an availability challenge is missing from the response, or
+// is not in the data tree of the block.
+var ErrChunkUnavailable = errors.New("sampled chunk is not available")
+
+// AvailabilityChallenge samples chunks of the data tree of a block; a block whose producer cannot return every sampled
+// chunk along with its Merkle proof is provably unavailable.
+type AvailabilityChallenge struct {
+	indexes     []uint64 // indexes of the sampled chunks
+	numOfLeaves uint64   // number of leaves of the data tree claimed by the block producer
+}
+
+// AvailabilityResponse holds the chunks sampled by an availability challenge, along with their Merkle proofs.
+type AvailabilityResponse struct {
+	chunks      [][]byte
+	proofChunks [][][]byte
+}
+
+// NewAvailabilityChallenge creates a challenge sampling distinct chunks among the leaves of the data tree, reading
+// the randomness from r. At most numOfLeaves chunks are sampled.
+func NewAvailabilityChallenge(numOfLeaves uint64, samples int, r io.Reader) (AvailabilityChallenge, error) {
+	if uint64(samples) > numOfLeaves {
+		samples = int(numOfLeaves)
+	}
+	c := AvailabilityChallenge{numOfLeaves: numOfLeaves}
+	sampled := make(map[uint64]bool)
+	random := make([]byte, 8)
+	for len(c.indexes) < samples {
+		_, err := io.ReadFull(r, random)
+		if err != nil {
+			return AvailabilityChallenge{}, err
+		}
+		index := binary.LittleEndian.Uint64(random) % numOfLeaves
+		if !sampled[index] {
+			sampled[index] = true
+			c.indexes = append(c.indexes, index)
+		}
+	}
+	return c, nil
+}
+
+// RespondChallenge returns the chunks sampled by the challenge, along with their Merkle proofs.
+func (b *Block) RespondChallenge(c AvailabilityChallenge) (AvailabilityResponse, error) {
+	chunks, proofChunks, _, err := b.proveChunks(c.indexes)
+	if err != nil {
+		return AvailabilityResponse{}, err
+	}
+	return AvailabilityResponse{chunks, proofChunks}, nil
+}
+
+// VerifyChallengeResponse checks that the response holds every chunk sampled by the challenge, and that they are in
+// the data tree of the block; ErrChunkUnavailable proves that the block is unavailable.
+func (b *Block) VerifyChallengeResponse(c AvailabilityChallenge, r AvailabilityResponse) error {
+	if len(r.chunks) != len(c.indexes) || len(r.proofChunks) != len(c.indexes) {
+		return ErrChunkUnavailable
+	}
+	scheme := b.dataTreeScheme.orDefault()
+	hasher := b.newHash() // reset and reused for every proof
+	for i := 0; i < len(c.indexes); i++ {
+		if len(r.chunks[i]) == 0 || len(r.proofChunks[i]) == 0 || !bytes.Equal(r.proofChunks[i][0], r.chunks[i]) {
+			return ErrChunkUnavailable
+		}
+		hasher.Reset()
+		if !scheme.VerifyProof(hasher, b.dataRoot, r.proofChunks[i], c.indexes[i], c.numOfLeaves) {
+			return ErrChunkUnavailable
+		}
+	}
+	return nil
+}
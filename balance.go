@@ -0,0 +1,99 @@
+package fraudproofs
+
+import (
+	"bytes"
+	"math/big"
+)
+
+// BalanceRule is an example of application-level rule, interpreting the data written by the transactions as balances
+// encoded as big-endian two's complement integers. A write is invalid if it leaves a negative balance, or if it
+// decreases the balance (from the transaction's oldData) by more than MaxWithdrawal.
+type BalanceRule struct {
+	MaxWithdrawal *big.Int // nil if withdrawals are not limited
+}
+
+// balance decodes a big-endian two's complement integer.
+func balance(data []byte) *big.Int {
+	n := new(big.Int).SetBytes(data)
+	if len(data) > 0 && data[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(8*len(data))))
+	}
+	return n
+}
+
+// violated returns whether a write from oldData to newData breaks the rule.
+func (r BalanceRule) violated(oldData, newData []byte) bool {
+	newBalance := balance(newData)
+	if newBalance.Sign() < 0 {
+		return true
+	}
+	if r.MaxWithdrawal == nil {
+		return false
+	}
+	withdrawal := new(big.Int).Sub(balance(oldData), newBalance)
+	return withdrawal.Cmp(r.MaxWithdrawal) > 0
+}
+
+// CheckBalances checks the writes of the block against the balance rule, and returns a fraud proof for the first write
+// breaking it.
+func (b *Block) CheckBalances(rule BalanceRule) (*FraudProof, error) {
+	for i := 0; i < len(b.transactions); i++ {
+		t := b.transactions[i]
+		for j := 0; j < len(t.writeKeys); j++ {
+			if !rule.violated(t.oldData[j], t.newData[j]) {
+				continue
+			}
+			chunksIndexes, chunks, proofChunks, numOfLeaves, offset, err := b.proveTransactions(i)
+			if err != nil {
+				return nil, err
+			}
+			return &FraudProof{
+				kind:          KindInvalidBalance,
+				writeKeys:     [][]byte{t.writeKeys[j]},
+				oldData:       [][]byte{t.oldData[j]},
+				chunks:        chunks,
+				proofChunks:   proofChunks,
+				txIndex:       uint64(i),
+				chunkSize:     uint64(b.chunkSize),
+				chunksIndexes: chunksIndexes,
+				numOfLeaves:   numOfLeaves,
+				offset:        offset,
+				newHash:       b.newHash,
+				hashAlgorithm: b.hashAlgorithm}, nil
+		}
+	}
+	return nil, nil
+}
+
+// VerifyBalanceFraudProof verifies a fraud proof claiming that a write of the block breaks the balance rule.
+func (b *Block) VerifyBalanceFraudProof(fp FraudProof, rule BalanceRule) bool {
+	if fp.kind != KindInvalidBalance || fp.Validate() != nil || len(fp.writeKeys) != 1 {
+		return false
+	}
+	t, _, ok := b.extractTransactions(fp)
+	if !ok {
+		return false
+	}
+	invalid := t[len(t)-1]
+	for j := 0; j < len(invalid.writeKeys); j++ {
+		if bytes.Equal(invalid.writeKeys[j], fp.writeKeys[0]) {
+			return rule.violated(invalid.oldData[j], invalid.newData[j])
+		}
+	}
+	return false
+}
+
+// BalanceDelta returns the change of the balance of every key written by the transaction, interpreting its oldData and
+// newData as balances the way BalanceRule does, eg. for wallets to display it. Writes whose balances or delta do not
+// fit in an int64 are skipped.
+func (t *Transaction) BalanceDelta() map[string]int64 {
+	deltas := make(map[string]int64)
+	for j := 0; j < len(t.writeKeys); j++ {
+		delta := new(big.Int).Sub(balance(t.newData[j]), balance(t.oldData[j]))
+		if len(t.oldData[j]) > 8 || len(t.newData[j]) > 8 || !delta.IsInt64() {
+			continue
+		}
+		deltas[string(t.writeKeys[j])] = delta.Int64()
+	}
+	return deltas
+}
@@ -0,0 +1,83 @@
+package fraudproofs
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/lazyledger/smt"
+)
+
+// ErrBlindWrite is returned when a transaction writes to an existing key without declaring a read of it.
+var ErrBlindWrite = errors.New("transaction writes to an existing key without declaring a read of it")
+
+// readsKey returns whether the transaction declares a read of the key.
+func (t *Transaction) readsKey(key []byte) bool {
+	for j := 0; j < len(t.readKeys); j++ {
+		if bytes.Equal(t.readKeys[j], key) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstBlindWrite returns the indexes of the first transaction (and of its write) writing to a key existing in the
+// state preceding the transaction without declaring a read of it, or -1 if there is none. The state tree must hold the
+// state preceding the transactions.
+func firstBlindWrite(t []Transaction, stateTree *smt.SparseMerkleTree) (int, int, error) {
+	written := make(map[string]bool)
+	for i := 0; i < len(t); i++ {
+		for j := 0; j < len(t[i].writeKeys); j++ {
+			key := t[i].writeKeys[j]
+			if t[i].readsKey(key) {
+				continue
+			}
+			if written[string(key)] {
+				return i, j, nil
+			}
+			value, err := stateTree.Get(key)
+			if err != nil {
+				return 0, 0, err
+			}
+			if len(value) > 0 {
+				return i, j, nil
+			}
+		}
+		for j := 0; j < len(t[i].writeKeys); j++ {
+			written[string(t[i].writeKeys[j])] = true
+		}
+	}
+	return -1, -1, nil
+}
+
+// blindWriteFraudProof generates a fraud proof for the j-th write of the i-th transaction of the block, which writes to
+// an existing key without declaring a read of it. The state tree must hold the state preceding the block.
+func (b *Block) blindWriteFraudProof(i, j int, stateTree *smt.SparseMerkleTree) (*FraudProof, error) {
+	return b.keyFraudProof(KindBlindWrite, i, b.transactions[i].writeKeys[j], stateTree, false)
+}
+
+// verifyBlindWriteFraudProof verifies a fraud proof claiming that a transaction of the block writes to an existing key
+// without declaring a read of it.
+func (b *Block) verifyBlindWriteFraudProof(fp FraudProof) bool {
+	if !b.strictWrites {
+		return false
+	}
+	t, value, ok := b.verifyKeyFraudProof(fp)
+	if !ok {
+		return false
+	}
+
+	// the key exists if it is in the intermediate state, or has been written by a previous transaction of the group
+	key := fp.readKeys[0]
+	_, written := lastWrite(t[:len(t)-1], key)
+	if !written && len(value) == 0 {
+		return false
+	}
+
+	invalid := t[len(t)-1]
+	for j := 0; j < len(invalid.writeKeys); j++ {
+		if bytes.Equal(invalid.writeKeys[j], key) && !invalid.readsKey(key) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,16 +2,78 @@ package fraudproofs
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"encoding/binary"
 	"errors"
-	"github.com/NebulousLabs/merkletree"
 	"crypto/sha512"
 	"github.com/lazyledger/smt"
+	"hash"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNilStateTree is returned when a nil state tree is given.
+var ErrNilStateTree = errors.New("state tree is nil")
+
+// ErrDependencyOrder is returned when a transaction appears before a transaction of the same block it depends on.
+var ErrDependencyOrder = errors.New("transaction appears before a transaction it depends on")
+
+// ErrArbitraryData is returned when a block created in strict mode holds a transaction carrying arbitrary data.
+var ErrArbitraryData = errors.New("transaction carries arbitrary data")
+
+// ErrExpiredTransaction is returned when a block holds a transaction that expired before the timestamp of the block.
+var ErrExpiredTransaction = errors.New("transaction expired before the block timestamp")
+
+// ErrPreStateRootMismatch is returned when the state tree does not hold the expected pre-state.
+var ErrPreStateRootMismatch = errors.New("state tree does not match the pre-state root")
+
+// ErrInvalidChunkSize is returned when the size of the chunks of the data tree is not between 2 and 256 bytes.
+var ErrInvalidChunkSize = errors.New("chunk size should be between 2 and 256 bytes")
+
+// ErrTruncatedBlock is returned when a serialized block ends before all of its fields and transactions.
+var ErrTruncatedBlock = errors.New("serialized block is truncated")
+
+// ErrPhantomWrite is returned when a transaction declares a write that does not change the value of its key.
+var ErrPhantomWrite = errors.New("transaction declares a write that does not change the value of its key")
+
+// ErrInvalidDataRoot is returned when the data root of a block does not commit to its transactions and intermediate
+// state roots.
+var ErrInvalidDataRoot = errors.New("data root does not commit to the transactions and intermediate state roots")
+
+// ErrInvalidWriteKeysRoot is returned when the root of the written keys claimed by a block does not commit to the keys
+// written by its transactions.
+var ErrInvalidWriteKeysRoot = errors.New(
+	"root of the written keys does not commit to the keys written by the transactions")
+
+// ErrBlockTooLarge is returned when the serialized block would exceed the maximum size set with WithMaxBlockBytes.
+var ErrBlockTooLarge = errors.New("serialized block exceeds the maximum block size")
+
+// ErrInterStateRootCount is returned when a block does not hold an intermediate state root every Step transactions.
+var ErrInterStateRootCount = errors.New("number of intermediate state roots does not match the number of transactions")
+
+// ErrTransactionCount is returned when the number of transactions claimed by the header of a block does not match its
+// transactions.
+var ErrTransactionCount = errors.New("number of transactions does not match the count claimed by the header")
+
+// ErrInvalidLeafKind is returned when an entry of the data tree is not tagged with the expected leaf kind.
+var ErrInvalidLeafKind = errors.New("data tree entry has an unexpected leaf kind")
+
+// LeafKind tags each entry (transaction or intermediate state root) of the data committed by the data tree, so that a
+// verifier cannot take a state root for a transaction, or the other way around.
+type LeafKind byte
+
+const (
+	// LeafTransaction tags a serialized transaction.
+	LeafTransaction LeafKind = 0x01
+	// LeafStateRoot tags an intermediate state root.
+	LeafStateRoot LeafKind = 0x02
 )
 
 // Step defines the interval on which to compute intermediate state roots (must be a positive integer)
 const Step int = 2
-// ChunksSize defines the size of each chunk
+// ChunksSize defines the default size of each chunk
 const chunksSize int = 256
 
 // Block is a block of the blockchain
@@ -20,71 +82,389 @@ type Block struct {
     dataRoot     []byte
     stateRoot    []byte
     transactions []Transaction
+    height       uint64 // set when the block is appended to a blockchain
+    parentHash   []byte // set when the block is appended to a blockchain
+    timestamp    int64
+    preStateRoot []byte // state root before applying the transactions
+    proposer     ed25519.PublicKey // set when the block is signed
+    signature    []byte // set when the block is signed
+    writeKeysRoot []byte // root of the Merkle tree of the sorted keys written by the transactions
+    numTransactions uint64 // number of transactions claimed by the block, committed by its header
 
     // implementation specific
     prev            *Block // link to the previous block
-    dataTree        *merkletree.Tree // Merkle tree storing chunks
     interStateRoots [][]byte // intermediate state roots (saved every 'step' transactions)
+    chunkSize       int // size of each chunk of the data tree
+    rejectPhantomWrites bool // reject transactions declaring writes with newData equal to oldData
+    checkOldData    bool // require the oldData of every write to match the state preceding the transaction
+    checkReads      bool // require the readData of every transaction to match the state it reads from
+    strictWrites    bool // require a transaction writing to an existing key to declare a read of it
+    stateEncoding   StateEncoding // encoding of the values of the state tree
+    maxBlockBytes   int // maximum size of the serialized block, checked when the block is created (0 for no limit)
+    strictMode      bool // reject transactions carrying arbitrary data, checked when the block is created
+    transactionHashes [][]byte // hashes of the transactions, when their bodies are stored out-of-band
+    dataTree        *dataTree // chunks of the data tree, built on first use by ensureDataTree (nil until then)
+    dataTreeMu      *sync.Mutex // guards dataTree, so that goroutines can share the block (shared by copies)
+    newHash         func() hash.Hash // hash function of the data tree and of the state tree
+    hashAlgorithm   HashAlgorithm // identifier of the hash function, committed by the header
+    dataTreeScheme  DataTreeScheme // Merkle tree of the data tree (NebulousDataTree if not set)
+    verifyPolicy    VerifyPolicy // limits and consensus caps applied by VerifyFraudProof
+    shards          int // number of shards applying the writes of the block in parallel (see WithShards)
+}
+
+// TransactionResolver fetches the body of a transaction stored out-of-band from its hash.
+type TransactionResolver func(hash []byte) (Transaction, error)
+
+// BlockOption configures optional parameters of a block.
+type BlockOption func(*Block)
+
+// WithChunkSize sets the size of the chunks of the data tree (between 2 and 256 bytes, including the position byte).
+func WithChunkSize(size int) BlockOption {
+	return func(b *Block) {
+		b.chunkSize = size
+	}
+}
+
+// WithHash sets the hash function of the data tree and of the state tree (defaults to SHA-512/256). The state trees
+// given to the block must be built with the same hash function. The identifier committed by the header is left as is
+// (see WithHashAlgorithm), so deserialized blocks and fraud proofs only use a custom hash function in memory.
+func WithHash(newHash func() hash.Hash) BlockOption {
+	return func(b *Block) {
+		b.newHash = newHash
+	}
+}
+
+// KeccakHash uses Keccak-256 for the data tree and the state tree, for compatibility with Ethereum.
+var KeccakHash = WithHashAlgorithm(HashKeccak256)
+
+// WithTimestamp sets the timestamp of the block (defaults to the current Unix time).
+func WithTimestamp(timestamp int64) BlockOption {
+	return func(b *Block) {
+		b.timestamp = timestamp
+	}
+}
+
+// WithRejectPhantomWrites sets whether transactions declaring writes that do not change the value of their key (ie.
+// newData equal to oldData) are rejected.
+func WithRejectPhantomWrites(reject bool) BlockOption {
+	return func(b *Block) {
+		b.rejectPhantomWrites = reject
+	}
+}
+
+// WithCheckOldData sets whether the oldData declared by every write must match the value of its key in the state
+// preceding the transaction, including the writes of the previous transactions of the block. The header commits to the
+// setting, as it decides whether fraud proofs of stale oldData verify.
+func WithCheckOldData(check bool) BlockOption {
+	return func(b *Block) {
+		b.checkOldData = check
+	}
+}
+
+// WithCheckReads sets whether the readData declared by every transaction must match the value of its key in the state
+// preceding the transaction, or in the state preceding the block for transactions reading from it (see
+// WithReadFromPreBlock); a transaction reading a key it also writes must then declare the oldData of its write as
+// readData. The header commits to the setting, as it decides whether fraud proofs of invalid and inconsistent reads
+// verify.
+func WithCheckReads(check bool) BlockOption {
+	return func(b *Block) {
+		b.checkReads = check
+	}
+}
+
+// WithStrictWrites sets whether a transaction writing to a key existing in the state preceding it must declare a read
+// of the key, as writes of an existing value are expected to depend on it. The header commits to the setting, as it
+// decides whether fraud proofs of blind writes verify.
+func WithStrictWrites(strict bool) BlockOption {
+	return func(b *Block) {
+		b.strictWrites = strict
+	}
+}
+
+// WithStateEncoding sets the encoding of the data written in the state tree (defaults to RawEncoding). The state trees
+// given to the block must be built with the same encoding.
+func WithStateEncoding(e StateEncoding) BlockOption {
+	return func(b *Block) {
+		b.stateEncoding = e
+	}
+}
+
+// WithMaxBlockBytes sets the maximum size in bytes of the serialized block (see Serialize), as real chains limit the
+// size of their blocks; zero (the default) sets no limit. Only the creation of the block is limited.
+func WithMaxBlockBytes(n int) BlockOption {
+	return func(b *Block) {
+		b.maxBlockBytes = n
+	}
+}
+
+// WithStrictMode sets whether transactions carrying arbitrary data (eg. a memo) are rejected, to keep blocks small.
+// Like WithMaxBlockBytes, only the creation of the block is checked.
+func WithStrictMode(strict bool) BlockOption {
+	return func(b *Block) {
+		b.strictMode = strict
+	}
+}
+
+// options returns the options the block has been created with.
+func (b *Block) options() []BlockOption {
+	return []BlockOption{
+		WithChunkSize(b.chunkSize),
+		WithTimestamp(b.timestamp),
+		WithRejectPhantomWrites(b.rejectPhantomWrites),
+		WithCheckOldData(b.checkOldData),
+		WithCheckReads(b.checkReads),
+		WithStrictWrites(b.strictWrites),
+		WithStateEncoding(b.stateEncoding),
+		WithHashAlgorithm(b.hashAlgorithm),
+		WithHash(b.newHash),
+		WithDataTree(b.dataTreeScheme),
+		WithVerifyPolicy(b.verifyPolicy),
+		WithShards(b.shards)}
+}
+
+// newBlock returns an empty block with the default parameters, configured with the options.
+func newBlock(opts ...BlockOption) *Block {
+	b := &Block{chunkSize: chunksSize, timestamp: time.Now().Unix(), newHash: sha512.New512_256,
+		dataTreeMu: new(sync.Mutex)}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// checkRules checks that the transaction is well-formed and follows the rules of the block that do not depend on the
+// state: phantom writes, reads of its own writes, arbitrary data and expiry.
+func (b *Block) checkRules(t *Transaction) error {
+	err := t.CheckTransaction()
+	if err != nil {
+		return err
+	}
+	if b.rejectPhantomWrites {
+		for j := 0; j < len(t.writeKeys); j++ {
+			if bytes.Equal(t.newData[j], t.oldData[j]) {
+				return ErrPhantomWrite
+			}
+		}
+	}
+	if b.checkReads && t.inconsistentRead() >= 0 {
+		return ErrInconsistentRead
+	}
+	if b.strictMode && len(t.arbitrary) > 0 {
+		return ErrArbitraryData
+	}
+	if t.expired(b.timestamp) {
+		return ErrExpiredTransaction
+	}
+	return nil
 }
 
 // NewBlock creates a new block with the given transactions.
-func NewBlock(t []Transaction, stateTree *smt.SparseMerkleTree) (*Block, error) {
+func NewBlock(t []Transaction, stateTree *smt.SparseMerkleTree, opts ...BlockOption) (*Block, error) {
+	if stateTree == nil {
+		return nil, ErrNilStateTree
+	}
+
+	b := newBlock(opts...)
+	if b.chunkSize < 2 || b.chunkSize > 256 {
+		return nil, ErrInvalidChunkSize
+	}
+	if b.newHash == nil {
+		return nil, ErrUnsupportedHashAlgorithm
+	}
+
 	for i := 0; i < len(t); i++ {
-		err := t[i].CheckTransaction()
+		err := b.checkRules(&t[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !dependenciesOrdered(t) {
+		return nil, ErrDependencyOrder
+	}
+	if b.shards > 1 {
+		if i, _ := Conflicts(t); i >= 0 {
+			return nil, ErrConflictingTransactions
+		}
+	}
+	if b.maxBlockBytes > 0 {
+		size := 0
+		for i := 0; i < len(t); i++ {
+			size += t[i].Size()
+		}
+		if size > b.maxBlockBytes {
+			return nil, ErrBlockTooLarge // the transactions alone exceed the limit
+		}
+	}
+
+	i, err := firstInvalidNonce(t, stateTree)
+	if err != nil {
+		return nil, err
+	}
+	if i >= 0 {
+		return nil, ErrInvalidNonce
+	}
+	if b.checkOldData {
+		i, _, err := firstStaleOldData(t, stateTree, b.stateEncoding)
 		if err != nil {
 			return nil, err
 		}
+		if i >= 0 {
+			return nil, ErrStaleOldData
+		}
+	}
+	if b.checkReads {
+		i, _, err := firstInvalidRead(t, stateTree, b.stateEncoding)
+		if err != nil {
+			return nil, err
+		}
+		if i >= 0 {
+			return nil, ErrInvalidRead
+		}
+	}
+	if b.strictWrites {
+		i, _, err := firstBlindWrite(t, stateTree)
+		if err != nil {
+			return nil, err
+		}
+		if i >= 0 {
+			return nil, ErrBlindWrite
+		}
 	}
 
-	interStateRoots, stateRoot, err := fillStateTree(t, stateTree)
+	b.preStateRoot = append([]byte{}, stateTree.Root()...)
+	var shardedRoot []byte
+	if b.shards > 1 {
+		shardedRoot, err = shardedStateRoot(t, stateTree, b.shards, b.newHash, b.stateEncoding)
+		if err != nil {
+			return nil, err
+		}
+	}
+	interStateRoots, stateRoot, err := fillStateTree(t, stateTree, b.stateEncoding)
 	if err != nil {
+		stateTree.SetRoot(b.preStateRoot) // the transactions preceding the error have been applied
 		return nil, err
 	}
+	if shardedRoot != nil {
+		stateRoot = shardedRoot
+	}
 
-	dataTree := merkletree.New(sha512.New512_256())
-	dataRoot, err := fillDataTree(t, interStateRoots, dataTree)
+	chunks, _, err := makeChunks(b.chunkSize, t, interStateRoots)
 	if err != nil {
+		stateTree.SetRoot(b.preStateRoot)
 		return nil, err
 	}
+	dataRoot := b.computeDataRoot(chunks)
 
-    return &Block{
-        dataRoot,
-        stateRoot,
-		t,
-        nil,
-		dataTree,
-		interStateRoots}, nil
+	b.dataRoot = dataRoot
+	b.writeKeysRoot = writeKeysRoot(writtenKeys(t), b.newHash)
+	b.stateRoot = stateRoot
+	b.transactions = t
+	b.numTransactions = uint64(len(t))
+	b.interStateRoots = interStateRoots
+	if b.maxBlockBytes > 0 && len(b.Serialize()) > b.maxBlockBytes {
+		stateTree.SetRoot(b.preStateRoot)
+		return nil, ErrBlockTooLarge
+	}
+	return b, nil
+}
+
+// dependenciesOrdered returns whether every transaction appears after the transactions of the block it depends on;
+// dependencies outside of the block are assumed to be in previous blocks.
+func dependenciesOrdered(t []Transaction) bool {
+	dependencies := false
+	for i := 0; i < len(t) && !dependencies; i++ {
+		dependencies = len(t[i].dependsOn) > 0
+	}
+	if !dependencies {
+		return true
+	}
+
+	positions := make(map[string]int)
+	for i := 0; i < len(t); i++ {
+		if _, ok := positions[string(t[i].Hash())]; !ok {
+			positions[string(t[i].Hash())] = i
+		}
+	}
+	for i := 0; i < len(t); i++ {
+		for _, hash := range t[i].dependsOn {
+			if j, ok := positions[string(hash)]; ok && j >= i {
+				return false
+			}
+		}
+	}
+	return true
 }
 
 // fillStateTree fills the input state tree with key-values from the input transactions, and returns the state root and
-// the intermediate state roots.
-func fillStateTree(t []Transaction, stateTree *smt.SparseMerkleTree) ([][]byte, []byte, error){
-	var stateRoot []byte
+// the intermediate state roots; the data written by the transactions is stored with the given encoding.
+func fillStateTree(t []Transaction, stateTree *smt.SparseMerkleTree, e StateEncoding) ([][]byte, []byte, error){
+	// start from the root of the state tree, so that groups writing nothing still commit to a full state root
+	stateRoot := append([]byte{}, stateTree.Root()...)
 	var interStateRoots [][]byte
 	for i := 0; i < len(t); i++ {
 		for j := 0; j < len(t[i].writeKeys); j++ {
-			root, err := stateTree.Update(t[i].writeKeys[j], t[i].newData[j])
+			root, err := stateTree.Update(t[i].writeKeys[j], e.encode(t[i].newData[j]))
+			if err != nil {
+				return nil, nil, err
+			}
+			stateRoot = make([]byte, len(root))
+			copy(stateRoot, root)
+		}
+		if len(t[i].sender) > 0 {
+			root, err := stateTree.Update(nonceKey(t[i].sender), nonceValue(t[i].nonce+1))
 			if err != nil {
 				return nil, nil, err
 			}
 			stateRoot = make([]byte, len(root))
 			copy(stateRoot, root)
 		}
+		if len(t[i].expectedRoot) > 0 && !bytes.Equal(stateTree.Root(), t[i].expectedRoot) {
+			return nil, nil, ErrInvalidExpectedRoot
+		}
 
-		if i != 0 && i % Step == 0 {
+		if (i+1)%Step == 0 {
 			interStateRoots = append(interStateRoots, stateRoot)
 		}
 	}
-	if len(t)%Step == 0 {
-		interStateRoots = append(interStateRoots, stateRoot)
-	}
 
 	return interStateRoots, stateRoot, nil
 }
 
+// replayTransactions applies the transactions to the state tree, regardless of the state roots they expect.
+func replayTransactions(t []Transaction, stateTree *smt.SparseMerkleTree, e StateEncoding) error {
+	for i := 0; i < len(t); i++ {
+		_, _, err := fillStateTree(t[i:i+1], stateTree, e)
+		if err != nil && err != ErrInvalidExpectedRoot {
+			return err
+		}
+	}
+	return nil
+}
+
+// stateValue encodes data as stored in the state tree; the data is prefixed with its length so that values split
+// differently never collide, even when a part is empty (the state tree stores an empty value as no value at all).
+func stateValue(data []byte) []byte {
+	value := make([]byte, MaxSize, MaxSize+len(data))
+	binary.LittleEndian.PutUint16(value, uint16(len(data)))
+	return append(value, data...)
+}
+
+// stateData decodes a value stored in the state tree; it returns nil if no data is stored.
+func stateData(value []byte) []byte {
+	if len(value) < MaxSize {
+		return nil
+	}
+	size := int(binary.LittleEndian.Uint16(value[:MaxSize]))
+	if len(value) < MaxSize+size {
+		return nil
+	}
+	return value[MaxSize : MaxSize+size]
+}
+
 // fillDataTree fills the data tree and returns its root.
-func fillDataTree(t []Transaction, interStateRoots [][]byte, dataTree *merkletree.Tree) ([]byte, error) {
-	chunks, _, err := makeChunks(chunksSize, t, interStateRoots)
+func fillDataTree(t []Transaction, interStateRoots [][]byte, dataTree DataTree, chunkSize int) ([]byte, error) {
+	chunks, _, err := makeChunks(chunkSize, t, interStateRoots)
 	if err != nil {
 		return nil, err
 	}
@@ -94,54 +474,250 @@ func fillDataTree(t []Transaction, interStateRoots [][]byte, dataTree *merkletre
 	return dataTree.Root(), nil
 }
 
-// makeChunks splits a set of transactions and state roots into multiple chunks.
-func makeChunks(chunkSize int, t []Transaction, s [][]byte) ([][]byte, map[[256]byte]int, error) {
-	if len(s) != int(len(t)/Step) {
-		return nil, nil, errors.New("wrong number of intermediate state roots")
+// parallelDataRoot returns the root of the data tree of the chunks, hashing the leaves in parallel; the root is the
+// same as the one of a merkletree.Tree filled with the chunks.
+func parallelDataRoot(chunks [][]byte, newHash func() hash.Hash) []byte {
+	if len(chunks) == 0 {
+		return nil
 	}
-	interStateRoots := make([][]byte, len(s))
-	copy(interStateRoots, s)
 
-	var buff []byte
-	buffMap := make(map[[256]byte]int)
-	for i := 0; i < len(t); i++ {
-		buffMap[t[i].HashKey()] = len(buff)
-		buff = append(buff, t[i].Serialize()...)
-		if i != 0 && i%Step == 0 {
-			buff = append(buff, interStateRoots[0]...)
-			interStateRoots = interStateRoots[1:]
+	// hash contiguous ranges of leaves on every CPU, keeping their order
+	leaves := make([][]byte, len(chunks))
+	workers := runtime.NumCPU()
+	size := (len(chunks) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(chunks); start += size {
+		end := start + size
+		if end > len(chunks) {
+			end = len(chunks)
 		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			h := newHash()
+			for i := start; i < end; i++ {
+				h.Reset()
+				h.Write([]byte{0x0}) // leaf prefix of merkletree
+				h.Write(chunks[i])
+				leaves[i] = h.Sum(nil)
+			}
+		}(start, end)
+	}
+	wg.Wait()
+
+	return joinSubTrees(newHash(), leaves)
+}
+
+// joinSubTrees combines the roots of adjacent subtrees the way merkletree does: the left subtree always holds the
+// largest power of two of nodes strictly smaller than their number.
+func joinSubTrees(h hash.Hash, nodes [][]byte) []byte {
+	if len(nodes) == 1 {
+		return nodes[0]
 	}
-	if len(t)%Step == 0 {
-		buff = append(buff, interStateRoots[0]...)
+	split := 1
+	for split*2 < len(nodes) {
+		split *= 2
 	}
+	left := joinSubTrees(h, nodes[:split])
+	right := joinSubTrees(h, nodes[split:])
+	h.Reset()
+	h.Write([]byte{0x1}) // node prefix of merkletree
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
 
-	var chunk []byte
-	size := chunkSize - 1
-	chunks := make([][]byte, 0, len(buff)/size+1)
-	for len(buff) >= size {
-		chunk, buff = buff[:size], buff[size:]
-		chunk = append([]byte{0x0}, chunk...)
-		chunks = append(chunks, chunk)
+// chunker splits the entries of a block into chunks as they are appended, and pushes every full chunk as soon as it is
+// filled. Each entry is prefixed with its leaf kind; the position byte of a chunk points at the tag of the first
+// transaction starting in it.
+type chunker struct {
+	push    func(chunk []byte)
+	size    int    // size of the chunks, excluding the position byte
+	chunk   []byte // chunk being filled, starting with its position byte
+	started bool   // whether a transaction starts in the chunk being filled
+	written int    // number of bytes of entries appended so far
+}
+
+// newChunker returns a chunker pushing the chunks of the given size (including the position byte).
+func newChunker(chunkSize int, push func(chunk []byte)) *chunker {
+	return &chunker{push: push, size: chunkSize - 1, chunk: []byte{0x0}}
+}
+
+// write appends an entry prefixed with its leaf kind, and returns its position in the data of the chunks.
+func (c *chunker) write(kind LeafKind, data []byte) int {
+	position := c.written
+	if kind == LeafTransaction && !c.started {
+		c.chunk[0] = byte(len(c.chunk) - 1)
+		c.started = true
 	}
-	if len(buff) > 0 {
-		chunk = buff[:]
-		chunk = append([]byte{0x0}, chunk...)
-		chunks = append(chunks, chunk)
+	entry := append([]byte{byte(kind)}, data...)
+	c.written += len(entry)
+	for len(entry) > 0 {
+		n := c.size - (len(c.chunk) - 1)
+		if n > len(entry) {
+			n = len(entry)
+		}
+		c.chunk, entry = append(c.chunk, entry[:n]...), entry[n:]
+		if len(c.chunk)-1 == c.size {
+			c.push(c.chunk)
+			c.chunk, c.started = []byte{0x0}, false
+		}
 	}
+	return position
+}
 
-	for i := len(t)-1; i >= 0; i-- {
-		chunkIndex := buffMap[t[i].HashKey()] / chunksSize
-		chunkPosition := byte(buffMap[t[i].HashKey()] % chunksSize)
-		chunks[chunkIndex][0] = chunkPosition
+// flush pushes the last partial chunk, if any.
+func (c *chunker) flush() {
+	if len(c.chunk) > 1 {
+		c.push(c.chunk)
+		c.chunk, c.started = []byte{0x0}, false
 	}
+}
 
-	return chunks, buffMap, nil
+// makeChunks splits a set of transactions and state roots into multiple chunks (see chunker), and returns the position
+// of each transaction in their data (by index, as a block may hold the same transaction twice).
+func makeChunks(chunkSize int, t []Transaction, s [][]byte) ([][]byte, []int, error) {
+	if len(s) != int(len(t)/Step) {
+		return nil, nil, errors.New("wrong number of intermediate state roots")
+	}
+
+	var chunks [][]byte
+	c := newChunker(chunkSize, func(chunk []byte) {
+		chunks = append(chunks, chunk)
+	})
+	positions := make([]int, len(t))
+	for i := 0; i < len(t); i++ {
+		positions[i] = c.write(LeafTransaction, t[i].Serialize())
+		if (i+1)%Step == 0 {
+			c.write(LeafStateRoot, s[(i+1)/Step-1])
+		}
+	}
+	c.flush()
+
+	return chunks, positions, nil
+}
+
+// Validate checks the internal consistency of the block without a state tree, as a cheap pre-check before CheckBlock:
+// the chunk size, the number of intermediate state roots, the structure of every transaction, and the data root. Blocks
+// storing their transactions out-of-band (see WithoutTransactions) only have their number of intermediate state roots
+// checked.
+func (b *Block) Validate() error {
+	if b.chunkSize < 2 || b.chunkSize > 256 {
+		return ErrInvalidChunkSize
+	}
+	n := len(b.transactions)
+	if b.transactionHashes != nil {
+		n = len(b.transactionHashes)
+	}
+	if len(b.interStateRoots) != n/Step {
+		return ErrInterStateRootCount
+	}
+	if b.numTransactions != uint64(n) {
+		return ErrTransactionCount
+	}
+	if b.transactionHashes != nil {
+		return nil
+	}
+	for i := 0; i < len(b.transactions); i++ {
+		err := b.transactions[i].CheckTransaction()
+		if err != nil {
+			return err
+		}
+	}
+	return b.checkDataRoot()
 }
 
 // CheckBlock checks that the block is constructed correctly, and returns a fraud proof if it is not.
 func (b *Block) CheckBlock(stateTree *smt.SparseMerkleTree) (*FraudProof, error) {
-	rebuiltBlock, err := NewBlock(b.transactions, stateTree)
+	if stateTree == nil {
+		return nil, ErrNilStateTree
+	}
+
+	err := b.checkDataRoot()
+	if err != nil {
+		return nil, err
+	}
+	return b.checkState(stateTree)
+}
+
+// checkDataRoot verifies that the data root commits to the transactions and intermediate state roots, tagged with their
+// leaf kind, that the header claims their number, and that the root of the written keys commits to the keys written by
+// the transactions; it does not depend on the state, so blocks can be checked concurrently.
+func (b *Block) checkDataRoot() error {
+	if b.numTransactions != uint64(len(b.transactions)) {
+		return ErrTransactionCount
+	}
+	chunks, _, err := makeChunks(b.chunkSize, b.transactions, b.interStateRoots)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(b.computeDataRoot(chunks), b.dataRoot) {
+		return ErrInvalidDataRoot
+	}
+	if !bytes.Equal(writeKeysRoot(writtenKeys(b.transactions), b.newHash), b.writeKeysRoot) {
+		return ErrInvalidWriteKeysRoot
+	}
+	return nil
+}
+
+// checkState checks the transitions of the state made by the block, once its data root has been checked.
+func (b *Block) checkState(stateTree *smt.SparseMerkleTree) (*FraudProof, error) {
+	// verify that the reads of every transaction are consistent with its own writes, if the block checks reads
+	if b.checkReads {
+		if i, j := firstInconsistentRead(b.transactions); i >= 0 {
+			return b.inconsistentReadFraudProof(i, j)
+		}
+	}
+
+	// verify that every transaction uses the next nonce of its sender
+	i, err := firstInvalidNonce(b.transactions, stateTree)
+	if err != nil {
+		return nil, err
+	}
+	if i >= 0 {
+		return b.nonceFraudProof(i, stateTree)
+	}
+
+	// verify that the oldData of every write matches the state, if the block requires it
+	if b.checkOldData {
+		i, j, err := firstStaleOldData(b.transactions, stateTree, b.stateEncoding)
+		if err != nil {
+			return nil, err
+		}
+		if i >= 0 {
+			return b.staleOldDataFraudProof(i, j, stateTree)
+		}
+	}
+
+	// verify that the readData of every transaction match the state it reads from, if the block requires it
+	if b.checkReads {
+		i, j, err := firstInvalidRead(b.transactions, stateTree, b.stateEncoding)
+		if err != nil {
+			return nil, err
+		}
+		if i >= 0 {
+			return b.invalidReadFraudProof(i, j, stateTree)
+		}
+	}
+
+	// verify that every write of an existing key declares a read of it, if the block requires it
+	if b.strictWrites {
+		i, j, err := firstBlindWrite(b.transactions, stateTree)
+		if err != nil {
+			return nil, err
+		}
+		if i >= 0 {
+			return b.blindWriteFraudProof(i, j, stateTree)
+		}
+	}
+
+	// transactions expecting a state root are checked one at a time
+	if b.declaresExpectedRoots(0, len(b.transactions)) {
+		return b.CheckBlockRange(stateTree, 0, len(b.transactions))
+	}
+
+	preStateRoot := append([]byte{}, stateTree.Root()...)
+	rebuiltBlock, err := NewBlock(b.transactions, stateTree, b.options()...)
 	if err != nil {
 		return nil, err
 	}
@@ -149,165 +725,551 @@ func (b *Block) CheckBlock(stateTree *smt.SparseMerkleTree) (*FraudProof, error)
 	// verify that every intermediate state roots are constructed correctly
 	for i := 0; i < len(rebuiltBlock.interStateRoots); i++ {
 		if len(b.interStateRoots) <= i || !bytes.Equal(rebuiltBlock.interStateRoots[i], b.interStateRoots[i]) {
-			// 1. get the transactions causing the (first) invalid intermediate state
-			t := rebuiltBlock.transactions[i*Step:(i+1)*Step]
-
-			// 2. generate Merkle proofs of the keys-values contained in the transaction
-			var writeKeys, oldData, readKeys, readData [][]byte
-			for j := 0; j < len(t); j++ {
-				for k := 0; k < len(t[j].writeKeys); k++ {
-					writeKeys = append(writeKeys, t[j].writeKeys[k])
-					oldData = append(oldData, t[j].oldData[k])
-				}
-				for k := 0; k < len(t[j].readKeys); k++ {
-					readKeys = append(readKeys, t[j].readKeys[k])
-					readData = append(readData, t[j].readData[k])
-				}
-			}
-
-			proofstate := make([]smt.SparseCompactMerkleProof, len(writeKeys))
-			for j := 0; j < len(writeKeys); j++ {
-				proof, err := stateTree.ProveCompact(writeKeys[j])
-				if err != nil {
-					return nil, err
-				}
-				proofstate[j] = proof
-			}
-
-			// 3. get chunks concerned by the proof
-			// TODO compact 'makeChunks' and 'getChunksIndexes'
-			chunksIndexes, _, err := b.getChunksIndexes(t)
+			final, err := b.finalWrites(i, stateTree)
 			if err != nil {
 				return nil, err
 			}
-			chunks, _, err := makeChunks(chunksSize, b.transactions, b.interStateRoots)
+			first, err := b.startsChunk(i)
 			if err != nil {
 				return nil, err
 			}
-			var concernedChunks [][]byte
-			for j := 0; j < len(chunksIndexes); j++ {
-				concernedChunks = append(concernedChunks, chunks[chunksIndexes[j]])
-			}
-
-			// 4. generate Merkle proofs of the transactions, previous state root, and next state root
-			proofChunks := make([][][]byte, len(chunksIndexes))
-			var numOfLeaves uint64
-			for j := 0; j < len(chunksIndexes); j++ {
-				// merkletree.Tree cannot call SetIndex on Tree if Tree has not been reset
-				// a dirty workaround is to copy the data tree
-				tmpDataTree := merkletree.New(sha512.New512_256())
-				err = tmpDataTree.SetIndex(chunksIndexes[j])
-				if err != nil {
-					return nil, err
-				}
-				_, err := fillDataTree(b.transactions, b.interStateRoots, tmpDataTree)
-				if err != nil {
-					return nil, err
-				}
-				_, proof, _, leaves := tmpDataTree.Prove()
-				numOfLeaves = leaves
-				proofChunks[j] = proof
+			if (final && first) || len(b.interStateRoots) <= i {
+				return b.fraudProof(i, stateTree)
 			}
 
-			return &FraudProof{
-				writeKeys,
-				oldData,
-				readKeys,
-				readData,
-				proofstate,
-				concernedChunks,
-				proofChunks,
-				chunksIndexes,
-				numOfLeaves}, nil
+			// the writes of the group cannot be proven against the state root of the block: replay the group instead
+			stateTree.SetRoot(preStateRoot)
+			return b.replayFraudProof((i+1)*Step-1, stateTree)
 		}
 	}
 
+	// verify the state root following the last transactions, when they do not complete a group
+	if len(b.transactions)%Step != 0 && !bytes.Equal(rebuiltBlock.stateRoot, b.stateRoot) {
+		stateTree.SetRoot(preStateRoot)
+		return b.replayFraudProof(len(b.transactions)-1, stateTree)
+	}
 
 	return nil, nil
 }
 
-// getChunksIndexes returns the indexes and number of chunks in which the given transactions are included
-func (b *Block) getChunksIndexes(t []Transaction) ([]uint64, uint64, error) {
-	chunks, buffMap, err := makeChunks(chunksSize, b.transactions, b.interStateRoots)
+// CheckBlockFromRoot checks that the block is constructed correctly like CheckBlock, after asserting that the state
+// tree holds the state of the given pre-state root; this catches a desynced state tree before it is modified.
+func (b *Block) CheckBlockFromRoot(stateTree *smt.SparseMerkleTree, preStateRoot []byte) (*FraudProof, error) {
+	if stateTree == nil {
+		return nil, ErrNilStateTree
+	}
+	if !bytes.Equal(stateTree.Root(), preStateRoot) {
+		return nil, ErrPreStateRootMismatch
+	}
+	return b.CheckBlock(stateTree)
+}
+
+// CheckBlockVerbose checks the block like CheckBlock, and also returns the transaction disputed by the fraud proof (see
+// FraudProof.TxIndex), so that callers can inspect it directly.
+func (b *Block) CheckBlockVerbose(stateTree *smt.SparseMerkleTree) (*FraudProof, *Transaction, error) {
+	fp, err := b.CheckBlock(stateTree)
+	if err != nil || fp == nil || fp.txIndex >= uint64(len(b.transactions)) {
+		return fp, nil, err
+	}
+	t := b.transactions[fp.txIndex]
+	return fp, &t, nil
+}
+
+// CheckBlockAll checks every intermediate state root of the block (and its state root, if its last transactions do not
+// complete a group) like CheckBlock, but returns a fraud proof for each one that is invalid instead of stopping at the
+// first one, sorted by the index of the disputed transaction. A root following an invalid one cannot be proven invalid,
+// as no state is known to match the root it claims to follow, and is skipped. Only the state transitions are checked;
+// the other rules are checked by CheckBlock. The state tree must hold the state preceding the block, and holds the
+// state following the honest replay of the block on return.
+func (b *Block) CheckBlockAll(stateTree *smt.SparseMerkleTree) ([]*FraudProof, error) {
+	if stateTree == nil {
+		return nil, ErrNilStateTree
+	}
+	err := b.checkDataRoot()
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
-	var chunksIndexes []uint64
-	for i := 0; i < len(t); i++ {
-		index := uint64(buffMap[t[i].HashKey()]/chunksSize)
-		length := int(binary.LittleEndian.Uint16(t[i].Serialize()[:MaxSize]))
-		last := length/chunksSize
-		for j := 0; j <= last; j++ {
-			chunksIndexes = append(chunksIndexes, index + uint64(j))
+	// replay the block group by group, noting the invalid roots following a valid one
+	preStateRoot := append([]byte{}, stateTree.Root()...)
+	var invalid []int // index of the last transaction of the group preceding each invalid root
+	valid := true
+	for first := 0; first < len(b.transactions); first += Step {
+		last := first + Step - 1
+		claimed := b.stateRoot
+		if last >= len(b.transactions) {
+			last = len(b.transactions) - 1
+		} else {
+			claimed = b.interStateRoots[first/Step]
+		}
+		err := replayTransactions(b.transactions[first:last+1], stateTree, b.stateEncoding)
+		if err != nil {
+			return nil, err
 		}
-		if length > (chunksSize - buffMap[t[i].HashKey()]%chunksSize) {
-			chunksIndexes = append(chunksIndexes, index+uint64(last)+1) // ugly fix
+		matches := bytes.Equal(stateTree.Root(), claimed)
+		if valid && !matches {
+			invalid = append(invalid, last)
 		}
+		valid = matches
 	}
+	postStateRoot := append([]byte{}, stateTree.Root()...)
 
-	uniquesMap := make(map[uint64]bool)
-	var uniques []uint64
-	for _, entry := range chunksIndexes {
-		if _, value := uniquesMap[entry]; !value {
-			uniquesMap[entry] = true
-			uniques = append(uniques, entry)
+	fps := make([]*FraudProof, 0, len(invalid))
+	for _, last := range invalid {
+		stateTree.SetRoot(preStateRoot)
+		fp, err := b.replayFraudProof(last, stateTree)
+		if err != nil {
+			return nil, err
 		}
+		fps = append(fps, fp)
 	}
+	stateTree.SetRoot(postStateRoot)
+	sort.SliceStable(fps, func(i, j int) bool { return fps[i].txIndex < fps[j].txIndex })
+	return fps, nil
+}
 
-	return uniques, uint64(len(chunks)), nil
+// CheckBlockRange checks that the intermediate state roots of the transactions [from, to) are constructed correctly,
+// and returns a fraud proof if they are not. The range must be aligned on intermediate state roots ('from' must be a
+// multiple of Step, and 'to' a multiple of Step or the number of transactions), and the input state tree must hold
+// the state preceding the transaction 'from'; this lets multiple verifiers split the work of checking a block.
+func (b *Block) CheckBlockRange(stateTree *smt.SparseMerkleTree, from, to int) (*FraudProof, error) {
+	if stateTree == nil {
+		return nil, ErrNilStateTree
+	}
+	if from < 0 || from > to || to > len(b.transactions) || from%Step != 0 ||
+		(to%Step != 0 && to != len(b.transactions)) {
+		return nil, errors.New("range is not aligned on intermediate state roots")
+	}
+	for i := from; i < to; i++ {
+		err := b.transactions[i].CheckTransaction()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	preStateRoot := b.preStateRoot
+	if from > 0 {
+		preStateRoot = b.interStateRoots[from/Step-1]
+	}
+	if !bytes.Equal(stateTree.Root(), preStateRoot) {
+		return nil, errors.New("state tree does not match the pre-state root of the range")
+	}
+
+	var group *FraudProof
+	for i := from; i < to; i++ {
+		if i%Step == 0 && b.declaresExpectedRoots(i, i+Step) {
+			var err error
+			group, err = b.groupStateProof(i, stateTree)
+			if err != nil {
+				return nil, err
+			}
+		}
+		_, _, err := fillStateTree(b.transactions[i:i+1], stateTree, b.stateEncoding)
+		if err == ErrInvalidExpectedRoot {
+			return b.expectedRootFraudProof(i, group)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if (i+1)%Step != 0 {
+			continue
+		}
+
+		k := (i+1)/Step - 1
+		if len(b.interStateRoots) <= k || !bytes.Equal(stateTree.Root(), b.interStateRoots[k]) {
+			// fraud proofs are generated against the final state of the block
+			err := replayTransactions(b.transactions[i+1:], stateTree, b.stateEncoding)
+			if err != nil {
+				return nil, err
+			}
+			return b.fraudProof(k, stateTree)
+		}
+	}
+
+	return nil, nil
 }
 
-// VerifyFraudProof verifies whether or not a fraud proof is valid.
-func (b *Block) VerifyFraudProof(fp FraudProof) bool {
-	// 1. check that the transactions, prevStateRoot, nextStateRoot are in the data tree
-	for i := 0; i < len(fp.proofChunks); i++ {
-		ret := merkletree.VerifyProof(sha512.New512_256(), b.dataRoot, fp.proofChunks[i], fp.chunksIndexes[i], fp.numOfLeaves)
-		if ret != true {
+// fraudProof generates a fraud proof for the i-th intermediate state root from the (final) state tree of the block.
+func (b *Block) fraudProof(i int, stateTree *smt.SparseMerkleTree) (*FraudProof, error) {
+	// 1. get the transactions causing the (first) invalid intermediate state
+	t := b.transactions[i*Step:(i+1)*Step]
+
+	// 2. generate Merkle proofs of the keys-values contained in the transaction
+	var writeKeys, oldData, readKeys, readData [][]byte
+	for j := 0; j < len(t); j++ {
+		for k := 0; k < len(t[j].writeKeys); k++ {
+			writeKeys = append(writeKeys, t[j].writeKeys[k])
+			oldData = append(oldData, t[j].oldData[k])
+		}
+		for k := 0; k < len(t[j].readKeys); k++ {
+			readKeys = append(readKeys, t[j].readKeys[k])
+			readData = append(readData, t[j].readData[k])
+		}
+	}
+
+	proofstate := make([]smt.SparseCompactMerkleProof, len(writeKeys))
+	for j := 0; j < len(writeKeys); j++ {
+		proof, err := stateTree.ProveCompact(writeKeys[j])
+		if err != nil {
+			return nil, err
+		}
+		proofstate[j] = proof
+	}
+
+	// 3. get chunks concerned by the proof
+	// TODO compact 'makeChunks' and 'getChunksIndexes'
+	chunksIndexes, _, err := b.getChunksIndexes(i*Step, (i+1)*Step)
+	if err != nil {
+		return nil, err
+	}
+
+	// 4. generate Merkle proofs of the transactions, previous state root, and next state root
+	concernedChunks, proofChunks, numOfLeaves, err := b.proveChunks(chunksIndexes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FraudProof{
+		kind:          KindInvalidStateRoot,
+		writeKeys:     writeKeys,
+		oldData:       oldData,
+		readKeys:      readKeys,
+		readData:      readData,
+		proofState:    proofstate,
+		chunks:        concernedChunks,
+		proofChunks:   proofChunks,
+		txIndex:       uint64((i+1)*Step - 1),
+		stateRoot:     append([]byte{}, stateTree.Root()...),
+		hashAlgorithm: b.hashAlgorithm,
+		chunkSize:     uint64(b.chunkSize),
+		chunksIndexes: chunksIndexes,
+		numOfLeaves:   numOfLeaves,
+		newHash:       b.newHash,
+		stateEncoding: b.stateEncoding}, nil
+}
+
+// verifyTxIndex checks that the chunks of a fraud proof of an invalid state root hold the group of transactions ending
+// with the disputed transaction; it cannot be checked against a block without its transactions (eg. a header).
+func (b *Block) verifyTxIndex(fp FraudProof) bool {
+	if b.transactions == nil {
+		return true
+	}
+	if fp.txIndex%uint64(Step) != uint64(Step-1) || fp.txIndex >= uint64(len(b.transactions)) {
+		return false
+	}
+	first := int(fp.txIndex) - (Step - 1)
+	chunksIndexes, _, err := b.getChunksIndexes(first, first+Step)
+	if err != nil || len(chunksIndexes) != len(fp.chunksIndexes) {
+		return false
+	}
+	for i := 0; i < len(chunksIndexes); i++ {
+		if chunksIndexes[i] != fp.chunksIndexes[i] {
 			return false
 		}
 	}
+	return true
+}
 
-	// 2. extract new data from chunks
-	var indexes []int
-	var buff []byte
-	for i := 0; i < len(fp.chunks); i++ {
-		indexes = append(indexes, int(fp.chunks[i][0]))
-		buff = append(buff, fp.chunks[i][1:]...)
+// finalWrites returns whether the writes of the i-th group of transactions are the last writes of their keys, and the
+// state tree holding the state following the block has the state root of the block; only then can a fraud proof of an
+// invalid state root for the group be verified against the state root of the block.
+func (b *Block) finalWrites(i int, stateTree *smt.SparseMerkleTree) (bool, error) {
+	if !bytes.Equal(stateTree.Root(), b.stateRoot) {
+		return false, nil
 	}
+	for _, t := range b.transactions[i*Step : (i+1)*Step] {
+		for j := 0; j < len(t.writeKeys); j++ {
+			value, err := stateTree.Get(t.writeKeys[j])
+			if err != nil {
+				return false, err
+			}
+			if !bytes.Equal(value, b.stateEncoding.encode(t.newData[j])) {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
 
-	var newData [][]byte
-	buff = buff[indexes[0]:]
-	for i := 0; len(buff) >= MaxSize; i++ {
-		length := int(binary.LittleEndian.Uint16(buff[:MaxSize]))
-		if len(buff) < length {
-			break
+// startsChunk returns whether the i-th group of transactions starts the first transaction of its first chunk; otherwise
+// the chunks of a fraud proof of an invalid state root for the group would also reveal the writes of the transactions
+// preceding it in the chunk.
+func (b *Block) startsChunk(i int) (bool, error) {
+	if i == 0 {
+		return true, nil
+	}
+	tree, err := b.ensureDataTree()
+	if err != nil {
+		return false, err
+	}
+	size := b.chunkSize - 1
+	return tree.positions[i*Step-1]/size != tree.positions[i*Step]/size, nil
+}
+
+// MatchesStateRoot returns whether the state root claimed by the block after its transactions is the given state root,
+// eg. computed by a reference implementation.
+func (b *Block) MatchesStateRoot(expected []byte) bool {
+	return bytes.Equal(b.stateRoot, expected)
+}
+
+// NumLeaves returns the number of leaves (ie. chunks) of the data tree of the block, as stored in its fraud proofs.
+func (b *Block) NumLeaves() uint64 {
+	length := 0
+	for i := 0; i < len(b.transactions); i++ {
+		length += 1 + b.transactions[i].Size()
+	}
+	for i := 0; i < len(b.interStateRoots); i++ {
+		length += 1 + len(b.interStateRoots[i])
+	}
+	size := b.chunkSize - 1
+	return uint64((length + size - 1) / size)
+}
+
+// EstimateProofCost returns the number of chunks and state proofs contained in a worst-case fraud proof for the block,
+// without generating it.
+func (b *Block) EstimateProofCost() (chunks int, stateProofs int) {
+	size := b.chunkSize - 1
+	offset := 0
+	for i := 0; i < len(b.interStateRoots) && (i+1)*Step <= len(b.transactions); i++ {
+		start := offset
+		writes := 0
+		for _, t := range b.transactions[i*Step : (i+1)*Step] {
+			offset += 1 + len(t.Serialize())
+			writes += len(t.writeKeys)
 		}
-		t, _ := Deserialize(buff[:length])
-		buff = buff[length:]
-		newData = append(newData, t.newData...)
+		if n := (offset-1)/size - start/size + 1; n > chunks {
+			chunks = n
+		}
+		if writes > stateProofs {
+			stateProofs = writes
+		}
+		offset += 1 + len(b.interStateRoots[i])
+	}
+	return chunks, stateProofs
+}
+
+// WithoutTransactions returns a copy of the block storing only the hashes of its transactions; their bodies must then
+// be fetched by a resolver to check the block.
+func (b *Block) WithoutTransactions() *Block {
+	pruned := *b
+	pruned.transactionHashes = make([][]byte, len(b.transactions))
+	for i := 0; i < len(b.transactions); i++ {
+		pruned.transactionHashes[i] = b.transactions[i].Hash()
 	}
+	pruned.transactions = nil
+	pruned.dataTree = nil
+	return &pruned
+}
 
-	// 3. check keys-values contained in the transaction are in the state tree for old data
-	subtree := smt.NewDeepSparseMerkleSubTree(smt.NewSimpleMap(), sha512.New512_256(), b.stateRoot)
-	for i := 0; i < len(fp.writeKeys); i++ {
-		proof, err := smt.DecompactProof(fp.proofState[i], sha512.New512_256())
+// Size returns the size of the serialized transactions of the block, in bytes.
+func (b *Block) Size() int {
+	size := 0
+	for i := 0; i < len(b.transactions); i++ {
+		size += b.transactions[i].Size()
+	}
+	return size
+}
+
+// Serialize converts a block into an array of bytes. The hash function of the block is not serialized.
+func (b *Block) Serialize() []byte {
+	var buff []byte
+	buff = appendBytes(buff, b.Header().Serialize())
+	buff = appendBytes(buff, b.preStateRoot)
+	buff = appendBytes(buff, b.proposer)
+	buff = appendBytes(buff, b.signature)
+	buff = appendUint64(buff, uint64(b.chunkSize))
+	if b.rejectPhantomWrites {
+		buff = append(buff, 1)
+	} else {
+		buff = append(buff, 0)
+	}
+	buff = appendSlices(buff, b.interStateRoots)
+	buff = appendUint64(buff, uint64(len(b.transactions)))
+	for i := 0; i < len(b.transactions); i++ {
+		buff = append(buff, b.transactions[i].Serialize()...)
+	}
+	return buff
+}
+
+// DeserializeBlock converts a serialized block (ie. array of bytes) into a block structure using the hash function
+// identified by its header. It returns ErrTruncatedBlock if the block ends before all of its fields and transactions,
+// and ErrUnsupportedHashAlgorithm if the hash function is unknown.
+func DeserializeBlock(buff []byte) (*Block, error) {
+	d := &decoder{buff}
+	b := &Block{newHash: sha512.New512_256, dataTreeMu: new(sync.Mutex)}
+	var fields [4][]byte
+	for i := 0; i < len(fields); i++ {
+		field, err := d.bytes()
 		if err != nil {
-			return false
+			return nil, ErrTruncatedBlock
+		}
+		fields[i] = field
+	}
+	h, err := DeserializeBlockHeader(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	b.dataRoot, b.stateRoot, b.writeKeysRoot, b.height, b.parentHash, b.timestamp = h.dataRoot, h.stateRoot,
+		h.writeKeysRoot, h.height, h.parentHash, h.timestamp
+	b.numTransactions = h.numTransactions
+	b.hashAlgorithm, b.checkOldData, b.checkReads, b.strictWrites = h.hashAlgorithm, h.checkOldData, h.checkReads,
+		h.strictWrites
+	b.newHash, err = h.hashAlgorithm.newHash()
+	if err != nil {
+		return nil, err
+	}
+	b.preStateRoot, b.proposer, b.signature = fields[1], fields[2], fields[3]
+	chunkSize, err := d.uint64()
+	if err != nil {
+		return nil, ErrTruncatedBlock
+	}
+	if chunkSize < 2 || chunkSize > 256 {
+		return nil, ErrInvalidChunkSize
+	}
+	b.chunkSize = int(chunkSize)
+	rejectPhantomWrites, err := d.uint8()
+	if err != nil {
+		return nil, ErrTruncatedBlock
+	}
+	b.rejectPhantomWrites = rejectPhantomWrites != 0
+	b.interStateRoots, err = d.slices()
+	if err != nil {
+		return nil, ErrTruncatedBlock
+	}
+
+	// every transaction is prefixed with its length
+	n, err := d.uint64()
+	if err != nil {
+		return nil, ErrTruncatedBlock
+	}
+	if n > uint64(len(d.buff)/MaxSize) {
+		return nil, ErrTruncatedBlock
+	}
+	b.transactions = make([]Transaction, n)
+	for i := uint64(0); i < n; i++ {
+		if len(d.buff) < MaxSize {
+			return nil, ErrTruncatedBlock
+		}
+		length := int(binary.LittleEndian.Uint16(d.buff[:MaxSize]))
+		if length < MaxSize || len(d.buff) < length {
+			return nil, ErrTruncatedBlock
 		}
-		err = subtree.AddBranch(proof, fp.writeKeys[i], newData[i])
+		t, err := Deserialize(d.buff[:length])
 		if err != nil {
-			return false
+			return nil, err
 		}
-		// 4. update keys with new data
-		_, err = subtree.Update(fp.writeKeys[i], newData[i])
+		b.transactions[i] = *t
+		d.buff = d.buff[length:]
+	}
+	if len(d.buff) != 0 {
+		return nil, errors.New("trailing bytes after block")
+	}
+	return b, nil
+}
+
+// CheckBlockWithResolver checks a block whose transactions are stored out-of-band, fetching their bodies with the
+// resolver. It returns a fraud proof if the block is not constructed correctly.
+func (b *Block) CheckBlockWithResolver(stateTree *smt.SparseMerkleTree, resolve TransactionResolver) (
+	*FraudProof, error) {
+	if b.transactionHashes == nil {
+		return b.CheckBlock(stateTree)
+	}
+
+	t := make([]Transaction, len(b.transactionHashes))
+	for i := 0; i < len(b.transactionHashes); i++ {
+		tx, err := resolve(b.transactionHashes[i])
 		if err != nil {
-			return false
+			return nil, err
+		}
+		if !bytes.Equal(tx.Hash(), b.transactionHashes[i]) {
+			return nil, errors.New("resolved transaction does not match its hash")
+		}
+		t[i] = tx
+	}
+
+	full := *b
+	full.transactions = t
+	full.transactionHashes = nil
+	full.dataTree = nil
+	return full.CheckBlock(stateTree)
+}
+
+// getChunksIndexes returns the indexes and number of chunks in which the transactions of the block from index 'from' up
+// to index 'to' (excluded) are included
+func (b *Block) getChunksIndexes(from, to int) ([]uint64, uint64, error) {
+	tree, err := b.ensureDataTree()
+	if err != nil {
+		return nil, 0, err
+	}
+	chunks, positions := tree.chunks, tree.positions
+
+	// each chunk carries 'chunkSize - 1' bytes of data after its position byte
+	size := b.chunkSize - 1
+	var chunksIndexes []uint64
+	for i := from; i < to; i++ {
+		offset := positions[i]
+		length := 1 + int(binary.LittleEndian.Uint16(b.transactions[i].Serialize()[:MaxSize]))
+		for j := offset/size; j <= (offset+length-1)/size; j++ {
+			chunksIndexes = append(chunksIndexes, uint64(j))
 		}
 	}
-	if !bytes.Equal(b.stateRoot, subtree.Root()) {
+
+	uniquesMap := make(map[uint64]bool)
+	var uniques []uint64
+	for _, entry := range chunksIndexes {
+		if _, value := uniquesMap[entry]; !value {
+			uniquesMap[entry] = true
+			uniques = append(uniques, entry)
+		}
+	}
+
+	return uniques, uint64(len(chunks)), nil
+}
+
+// VerifyFraudProof verifies whether or not a fraud proof is valid, under the verification policy of the block (see
+// WithVerifyPolicy).
+func (b *Block) VerifyFraudProof(fp FraudProof) bool {
+	ok, _ := b.VerifyFraudProofWithPolicy(fp, b.verifyPolicy)
+	return ok
+}
+
+// verifyFraudProof verifies whether or not a fraud proof is valid, given the cap on the number of transactions of a
+// block (0 for no cap).
+func (b *Block) verifyFraudProof(fp FraudProof, maxTransactions uint64) bool {
+	if fp.Validate() != nil || fp.hashAlgorithm != b.hashAlgorithm {
 		return false
 	}
+	switch fp.kind {
+	case KindInvalidNonce:
+		return b.verifyNonceFraudProof(fp)
+	case KindInvalidExpectedRoot:
+		return b.verifyExpectedRootFraudProof(fp)
+	case KindStaleOldData:
+		return b.verifyStaleOldDataFraudProof(fp)
+	case KindInvalidRead:
+		return b.verifyInvalidReadFraudProof(fp)
+	case KindBlindWrite:
+		return b.verifyBlindWriteFraudProof(fp)
+	case KindInconsistentRead:
+		return b.verifyInconsistentReadFraudProof(fp)
+	case KindInvalidInterStateRoot:
+		return b.verifyInterStateRootFraudProof(fp)
+	case KindInvalidBalance:
+		// without the verifier's rule, only negative balances are invalid
+		return b.VerifyBalanceFraudProof(fp, BalanceRule{})
+	case KindTooManyTransactions:
+		return b.Header().VerifyTooManyTransactionsFraudProof(fp, maxTransactions)
+	case KindInvalidLayout:
+		return b.verifyLayoutFraudProof(fp)
+	case KindDuplicateTransaction:
+		return b.verifyDuplicateTransactionFraudProof(fp)
+	}
 
-	return true
+	// 1. check that the transactions, prevStateRoot, nextStateRoot are in the data tree
+	if !b.verifyChunks(fp) || !b.verifyTxIndex(fp) {
+		return false
+	}
+
+	// 2. apply the new data extracted from the chunks to the keys proven in the state tree
+	return fp.verifyState(b.stateRoot, b.newHash, b.stateEncoding)
 }
@@ -2,9 +2,24 @@ package fraudproofs
 
 import (
 	"github.com/lazyledger/smt"
+	"bytes"
 	"crypto/sha512"
+	"errors"
+	"time"
 )
 
+// ChainView is a read-only view of a blockchain.
+type ChainView interface {
+	// Len returns the number of blocks of the blockchain.
+	Len() uint64
+	// Block returns the block at the given height (starting at 1).
+	Block(height uint64) (*Block, error)
+	// Get returns the data committed in the state for the given key.
+	Get(key []byte) ([]byte, error)
+	// Header returns the header of the block at the given height (starting at 1).
+	Header(height uint64) (BlockHeader, error)
+}
+
 // Blockchain is a simple blockchain.
 type Blockchain struct {
 	// data structure
@@ -13,29 +28,386 @@ type Blockchain struct {
 
 	// implementation specific
 	stateTree *smt.SparseMerkleTree // sparse Merkle tree storing key-values of the transactions
+	logger Logger // diagnostics logger (no-op by default)
+	store StateStore // store in which the state tree is saved (nil if the state lives in memory)
+	workers int // number of workers validating the blocks of a batch concurrently (0 to validate them sequentially)
+	stats *stats // counters of the activity of the blockchain
+	namespace []byte // prefix of the keys of the blockchain in its store (nil if the store is not shared)
+	checkpoint uint64 // height of the trusted checkpoint the blockchain starts from (0 if it starts from the genesis)
+	rejected []FraudProof // fraud proofs of the blocks rejected by AppendWithProof
+	stateEncoding StateEncoding // encoding of the values of the state tree (see WithChainStateEncoding)
+}
+
+// ErrInvalidParent is returned when a block does not extend the last block of the blockchain, ie. its height or its
+// parent hash do not match it (see WithParent).
+var ErrInvalidParent = errors.New("block does not extend the last block of the blockchain")
+
+// ErrProvenInvalid is returned by AppendWithProof when the block is rejected by a fraud proof.
+var ErrProvenInvalid = errors.New("block is proven invalid by a fraud proof")
+
+// BlockchainOption configures optional parameters of a blockchain.
+type BlockchainOption func(*Blockchain)
+
+// WithWorkers sets the number of workers validating the blocks of a batch concurrently in AppendBatch; the blocks are
+// still applied to the state one at a time, in order. Zero (the default) validates the blocks sequentially.
+func WithWorkers(n int) BlockchainOption {
+	return func(bc *Blockchain) {
+		bc.workers = n
+	}
+}
+
+// WithNamespace sets the namespace of the blockchain in its store, so that several blockchains can share a store
+// without their states colliding; it only applies to blockchains created with NewBlockchainWithStore.
+func WithNamespace(prefix []byte) BlockchainOption {
+	return func(bc *Blockchain) {
+		bc.namespace = append([]byte{}, prefix...)
+	}
+}
+
+// WithChainStateEncoding sets the encoding of the values of the state tree of the blockchain, which must be the one its
+// blocks are built with (see WithStateEncoding); Get decodes the data committed in the state with it.
+func WithChainStateEncoding(e StateEncoding) BlockchainOption {
+	return func(bc *Blockchain) {
+		bc.stateEncoding = e
+	}
 }
 
 // NewBlockchain creates an empty blockchain.
-func NewBlockchain() *Blockchain {
-	return &Blockchain{0,nil, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())}
+func NewBlockchain(opts ...BlockchainOption) *Blockchain {
+	bc := &Blockchain{stateTree: smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()), logger: nopLogger{},
+		stats: newStats()}
+	for _, opt := range opts {
+		opt(bc)
+	}
+	return bc
+}
+
+// NewBlockchainWithStore creates an empty blockchain whose state tree is saved in the store (in its namespace, see
+// WithNamespace); the state already saved in the store is the state preceding the first block.
+func NewBlockchainWithStore(store StateStore, opts ...BlockchainOption) *Blockchain {
+	bc := &Blockchain{logger: nopLogger{}, store: store, stats: newStats()}
+	for _, opt := range opts {
+		opt(bc)
+	}
+	if bc.namespace != nil {
+		bc.store = newNamespacedStore(store, bc.namespace)
+	}
+	bc.stateTree = OpenStateTree(bc.store, nil)
+	return bc
 }
 
-// Append appends a block to the blockchain or returns a fraud proof if the block is not constructed correctly.
-func (bc *Blockchain) Append(b *Block) (*FraudProof, error) {
-	fp, err := b.CheckBlock(bc.stateTree)
+// NewBlockchainFromCheckpoint creates a blockchain starting from a trusted checkpoint, eg. for fast sync: its state at
+// the given height is the state of the given root, whose nodes must be saved in the store, and the blocks up to that
+// height are not available. Every block appended must declare the state of the blockchain as its pre-state.
+func NewBlockchainFromCheckpoint(height uint64, stateRoot []byte, store StateStore, opts ...BlockchainOption) (
+	*Blockchain, error) {
+	if height == 0 {
+		return nil, errors.New("checkpoint must be above the genesis")
+	}
+	bc := NewBlockchainWithStore(store, opts...)
+	bc.stateTree.SetRoot(append([]byte{}, stateRoot...))
+	bc.length, bc.checkpoint = int(height), height
+	err := SaveStateTree(bc.store, bc.stateTree)
 	if err != nil {
 		return nil, err
 	}
-	if fp != nil {
-		return fp, nil
+	return bc, nil
+}
+
+// Reset discards every block and the state of the blockchain and zeroes its statistics, leaving it as returned by
+// NewBlockchain, so that it can be reused across tests and benchmarks; the logger and the workers are kept. A
+// blockchain backed by a store is detached from it (the store is left untouched), and its state lives in memory from
+// then on.
+func (bc *Blockchain) Reset() {
+	bc.length, bc.last, bc.checkpoint = 0, nil, 0
+	bc.rejected = nil
+	bc.stateTree = smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	bc.store = nil
+	bc.stats = newStats()
+}
+
+// Stats returns the counters of the activity of the blockchain; it can be called while blocks are appended.
+func (bc *Blockchain) Stats() Stats {
+	return bc.stats.snapshot()
+}
+
+// SetLogger sets the logger receiving the blockchain's diagnostics; a nil logger discards them.
+func (bc *Blockchain) SetLogger(logger Logger) {
+	if logger == nil {
+		logger = nopLogger{}
 	}
+	bc.logger = logger
+}
+
+// Append appends a block to the blockchain and returns the height at which it was accepted, or returns a fraud proof
+// (and a height of 0) if the block is not constructed correctly.
+func (bc *Blockchain) Append(b *Block) (uint64, *FraudProof, error) {
+	return bc.append(b, b.CheckBlock)
+}
 
-	if bc.length == 0 {
-		bc.last = b
+// AppendWithProof appends a block received along with a fraud proof, eg. from a peer having found it invalid. If the
+// fraud proof verifies against the block, the block is refused without being checked, the fraud proof is recorded (see
+// RejectedProofs) and ErrProvenInvalid is returned; otherwise the block is appended as with Append, and
+// ErrProvenInvalid is returned (and the fraud proof generated by the check recorded) if it is not constructed
+// correctly.
+func (bc *Blockchain) AppendWithProof(b *Block, fp FraudProof) error {
+	if !b.VerifyFraudProof(fp) {
+		_, generated, err := bc.Append(b)
+		if err != nil || generated == nil {
+			return err
+		}
+		fp = *generated
 	} else {
-		b.prev = bc.last
-		bc.last = b
+		bc.logger.Infof("block at height %d rejected by its fraud proof", bc.length+1)
+	}
+	bc.rejected = append(bc.rejected, *fp.Copy())
+	return ErrProvenInvalid
+}
+
+// RejectedProofs returns the fraud proofs of the blocks rejected by AppendWithProof, in the order they were rejected.
+func (bc *Blockchain) RejectedProofs() []FraudProof {
+	return append([]FraudProof{}, bc.rejected...)
+}
+
+// append appends a block to the blockchain after checking it against the state tree with the given function. If the
+// block is rejected, the state tree is restored to the state preceding the block.
+func (bc *Blockchain) append(b *Block, check func(*smt.SparseMerkleTree) (*FraudProof, error)) (uint64, *FraudProof,
+	error) {
+	// the parent of the first block following a checkpoint is unknown, so only its height is checked
+	if b.height != uint64(bc.length+1) {
+		return 0, nil, ErrInvalidParent
+	}
+	if bc.last != nil && !bytes.Equal(b.parentHash, bc.last.Hash()) || bc.last == nil && bc.checkpoint == 0 &&
+		len(b.parentHash) != 0 {
+		return 0, nil, ErrInvalidParent
 	}
+
+	// the blocks preceding a checkpoint are unknown, so the pre-state of the block is checked against the state instead
+	if bc.checkpoint > 0 && !bytes.Equal(b.preStateRoot, bc.stateTree.Root()) {
+		return 0, nil, ErrPreStateRootMismatch
+	}
+	stateRoot := append([]byte{}, bc.stateTree.Root()...)
+	start := time.Now()
+	fp, err := check(bc.stateTree)
+	bc.logger.Debugf("checked block in %v", time.Since(start))
+	bc.stats.check(time.Since(start))
+	if err != nil || fp != nil {
+		bc.stateTree.SetRoot(stateRoot)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if fp != nil {
+		bc.logger.Infof("fraud proof generated for block at height %d", bc.length+1)
+		bc.stats.add(&bc.stats.s.FraudProofs)
+		return 0, fp, nil
+	}
+
+	b.prev = bc.last
+	bc.last = b
 	bc.length++
+	if bc.store != nil {
+		err := SaveStateTree(bc.store, bc.stateTree)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	bc.logger.Infof("block accepted at height %d", bc.length)
+	bc.stats.add(&bc.stats.s.Blocks)
+	return uint64(bc.length), nil, nil
+}
+
+// AppendBatch appends the blocks to the blockchain atomically. If a block is rejected, the blockchain and its state
+// are left as they were before the batch, and the fraud proof (or error) caused by the block is returned. If the
+// blockchain has workers, the data roots of the blocks are checked concurrently while the blocks are applied.
+func (bc *Blockchain) AppendBatch(blocks []*Block) (*FraudProof, error) {
+	length, last := bc.length, bc.last
+	stateRoot := append([]byte{}, bc.stateTree.Root()...)
+	stop := make(chan struct{})
+	defer close(stop)
+	checked := bc.checkDataRoots(blocks, stop)
+	for i, b := range blocks {
+		var fp *FraudProof
+		var err error
+		if checked == nil {
+			_, fp, err = bc.Append(b)
+		} else if err = <-checked[i]; err == nil {
+			_, fp, err = bc.append(b, b.checkState)
+		}
+		if err == nil && fp == nil {
+			continue
+		}
+
+		bc.logger.Infof("batch rolled back to height %d", length)
+		bc.length, bc.last = length, last
+		bc.stateTree.SetRoot(stateRoot)
+		if bc.store != nil {
+			saveErr := SaveStateTree(bc.store, bc.stateTree)
+			if err == nil {
+				err = saveErr
+			}
+		}
+		return fp, err
+	}
 	return nil, nil
 }
+
+// checkDataRoots checks the data roots of the blocks with the workers of the blockchain, and returns the channels
+// receiving the result of each block (or nil if the blockchain has no workers). The workers stop picking blocks once
+// the stop channel is closed.
+func (bc *Blockchain) checkDataRoots(blocks []*Block, stop <-chan struct{}) []chan error {
+	if bc.workers <= 0 {
+		return nil
+	}
+	checked := make([]chan error, len(blocks))
+	for i := range checked {
+		checked[i] = make(chan error, 1)
+	}
+
+	indexes := make(chan int)
+	go func() {
+		defer close(indexes)
+		for i := range blocks {
+			select {
+			case indexes <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	for w := 0; w < bc.workers; w++ {
+		go func() {
+			for i := range indexes {
+				checked[i] <- blocks[i].checkDataRoot()
+			}
+		}()
+	}
+	return checked
+}
+
+// Rewind discards the blocks above the given height, and brings the state back to the state at that height (ie. the
+// pre-state of the first discarded block).
+func (bc *Blockchain) Rewind(height uint64) error {
+	if height > uint64(bc.length) {
+		return errors.New("cannot rewind above the last block")
+	}
+	if height < bc.checkpoint {
+		return errors.New("cannot rewind below the checkpoint")
+	}
+	if height == uint64(bc.length) {
+		return nil
+	}
+	next, err := bc.Block(height + 1)
+	if err != nil {
+		return err
+	}
+	last := next.prev
+	if height == 0 {
+		last = nil
+	}
+
+	bc.stateTree.SetRoot(append([]byte{}, next.preStateRoot...))
+	bc.length, bc.last = int(height), last
+	bc.logger.Infof("blockchain rewound to height %d", height)
+	if bc.store != nil {
+		return SaveStateTree(bc.store, bc.stateTree)
+	}
+	return nil
+}
+
+// VerifyStream checks again every block of the blockchain against the state preceding it, and calls the function
+// with the height of each invalid block and its fraud proof as they are found; it stops as soon as the function returns
+// false. Pruned blocks are skipped, and the state of the blockchain is left untouched.
+func (bc *Blockchain) VerifyStream(fn func(height uint64, fp *FraudProof) bool) error {
+	stateRoot := append([]byte{}, bc.stateTree.Root()...)
+	defer bc.stateTree.SetRoot(stateRoot)
+	for height := bc.checkpoint + 1; height <= uint64(bc.length); height++ {
+		b, err := bc.Block(height)
+		if err != nil {
+			return err
+		}
+		if b.transactionHashes != nil {
+			continue // the block has been pruned
+		}
+		bc.stateTree.SetRoot(append([]byte{}, b.preStateRoot...))
+		fp, err := b.CheckBlock(bc.stateTree)
+		if err != nil {
+			return err
+		}
+		if fp != nil && !fn(height, fp) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Prune drops the bodies of the transactions of the blocks up to the given height, keeping their hashes (see
+// WithoutTransactions) along with the roots committed by the blocks, so that fraud proofs can still be verified
+// against them (see VerifyFraudProof).
+func (bc *Blockchain) Prune(height uint64) error {
+	if height > uint64(bc.length) {
+		return errors.New("cannot prune above the last block")
+	}
+	b := bc.last
+	for i := uint64(bc.length); i > bc.checkpoint; i-- {
+		if i <= height && b.transactionHashes == nil {
+			pruned := b.WithoutTransactions()
+			b.transactions, b.transactionHashes, b.dataTree = nil, pruned.transactionHashes, nil
+		}
+		b = b.prev
+	}
+	bc.logger.Infof("blockchain pruned up to height %d", height)
+	return nil
+}
+
+// VerifyFraudProof verifies a fraud proof against the block at the given height, even if the block has been pruned:
+// fraud proofs are self-contained, and are verified against the roots committed by the block, without the state of the
+// blockchain. The index of the transaction disputed by the fraud proof is only checked against blocks holding the
+// bodies of their transactions.
+func (bc *Blockchain) VerifyFraudProof(height uint64, fp FraudProof) bool {
+	b, err := bc.Block(height)
+	if err != nil {
+		return false
+	}
+	return b.VerifyFraudProof(fp)
+}
+
+// Len returns the number of blocks of the blockchain.
+func (bc *Blockchain) Len() uint64 {
+	return uint64(bc.length)
+}
+
+// Block returns the block at the given height (starting at 1).
+func (bc *Blockchain) Block(height uint64) (*Block, error) {
+	if height <= bc.checkpoint || height > uint64(bc.length) {
+		return nil, errors.New("no block at this height")
+	}
+	b := bc.last
+	for i := uint64(bc.length); i > height; i-- {
+		b = b.prev
+	}
+	return b, nil
+}
+
+// Get returns the data committed in the state for the given key.
+func (bc *Blockchain) Get(key []byte) ([]byte, error) {
+	value, err := bc.stateTree.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return bc.stateEncoding.decode(value), nil
+}
+
+// StateRoot returns the root of the state committed by the blockchain.
+func (bc *Blockchain) StateRoot() []byte {
+	return StateSnapshotHash(bc.stateTree)
+}
+
+// Header returns the header of the block at the given height (starting at 1).
+func (bc *Blockchain) Header(height uint64) (BlockHeader, error) {
+	b, err := bc.Block(height)
+	if err != nil {
+		return BlockHeader{}, err
+	}
+	return b.Header(), nil
+}
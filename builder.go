@@ -0,0 +1,111 @@
+package fraudproofs
+
+import (
+	"github.com/lazyledger/smt"
+)
+
+// BlockBuilder collects transactions (eg. from a mempool) and builds a block out of them.
+type BlockBuilder struct {
+	stateTree    *smt.SparseMerkleTree // state preceding the block
+	transactions []Transaction
+	skipInvalid  bool // skip invalid transactions instead of aborting the build
+}
+
+// BuilderOption configures optional parameters of a block builder.
+type BuilderOption func(*BlockBuilder)
+
+// WithSkipInvalid sets whether the builder skips invalid transactions (malformed, breaking the rules of the block built
+// with the options of Build, depending on a later transaction, or using an invalid nonce) instead of failing to build
+// the block.
+func WithSkipInvalid(skip bool) BuilderOption {
+	return func(bb *BlockBuilder) {
+		bb.skipInvalid = skip
+	}
+}
+
+// NewBlockBuilder creates a block builder applying the transactions on top of the given state tree.
+func NewBlockBuilder(stateTree *smt.SparseMerkleTree, opts ...BuilderOption) *BlockBuilder {
+	bb := &BlockBuilder{stateTree: stateTree}
+	for _, opt := range opts {
+		opt(bb)
+	}
+	return bb
+}
+
+// Add adds a transaction to the block being built.
+func (bb *BlockBuilder) Add(t Transaction) {
+	bb.transactions = append(bb.transactions, t)
+}
+
+// Build builds a block with the transactions added to the builder, and returns the hashes of the transactions skipped
+// because they are invalid; if the builder does not skip invalid transactions, the first one aborts the build.
+func (bb *BlockBuilder) Build(opts ...BlockOption) (*Block, [][]byte, error) {
+	if bb.stateTree == nil {
+		return nil, nil, ErrNilStateTree
+	}
+	// check the transactions against the block being built, at the timestamp it will carry
+	template := newBlock(opts...)
+	opts = append(opts, WithTimestamp(template.timestamp))
+	positions := make(map[string]int)
+	for i := 0; i < len(bb.transactions); i++ {
+		if _, ok := positions[string(bb.transactions[i].Hash())]; !ok {
+			positions[string(bb.transactions[i].Hash())] = i
+		}
+	}
+
+	var valid []Transaction
+	var skipped [][]byte
+	next := make(map[string]uint64)
+	for i := 0; i < len(bb.transactions); i++ {
+		t := bb.transactions[i]
+		err := bb.check(template, t, i, positions, next)
+		if err != nil {
+			if !bb.skipInvalid {
+				return nil, nil, err
+			}
+			skipped = append(skipped, t.Hash())
+			continue
+		}
+		if len(t.sender) != 0 {
+			next[string(t.sender)] = t.nonce + 1
+		}
+		valid = append(valid, t)
+	}
+
+	b, err := NewBlock(valid, bb.stateTree, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, skipped, nil
+}
+
+// check verifies that the i-th transaction follows the rules of the block, does not depend on a transaction added after
+// it (given the first position of every added transaction), and uses the next nonce of its sender, given the next
+// nonces of the senders of the transactions already accepted; skipping transactions never breaks the order of
+// dependencies.
+func (bb *BlockBuilder) check(b *Block, t Transaction, i int, positions map[string]int, next map[string]uint64) error {
+	err := b.checkRules(&t)
+	if err != nil {
+		return err
+	}
+	for _, hash := range t.dependsOn {
+		if j, ok := positions[string(hash)]; ok && j >= i {
+			return ErrDependencyOrder
+		}
+	}
+	if len(t.sender) == 0 {
+		return nil
+	}
+	nonce, ok := next[string(t.sender)]
+	if !ok {
+		value, err := bb.stateTree.Get(nonceKey(t.sender))
+		if err != nil {
+			return err
+		}
+		nonce = nonceFromValue(value)
+	}
+	if t.nonce != nonce {
+		return ErrInvalidNonce
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package fraudproofs
+
+import (
+	"bytes"
+)
+
+// VerifyFraudProofChain verifies fraud proofs across consecutive blocks, where the invalid state of a block corrupts
+// the next ones: each header must link to the previous one by its parent hash, and each fraud proof must be valid
+// against its header. Headers only commit to the data root and the state root, so only fraud proofs of invalid state
+// roots can be verified this way. The hash function is selected from the identifier committed by each header, which
+// each fraud proof must embed.
+func VerifyFraudProofChain(proofs []FraudProof, headers []BlockHeader) bool {
+	if len(proofs) == 0 || len(proofs) != len(headers) {
+		return false
+	}
+	for i := 1; i < len(headers); i++ {
+		if !bytes.Equal(headers[i].parentHash, headers[i-1].Hash()) {
+			return false
+		}
+	}
+	for i := 0; i < len(proofs); i++ {
+		if proofs[i].kind != KindInvalidStateRoot {
+			return false
+		}
+		newHash, err := headers[i].hashAlgorithm.newHash()
+		if err != nil {
+			return false
+		}
+		if proofs[i].newHash != nil {
+			newHash = proofs[i].newHash // custom hash function (see WithHash)
+		}
+		b := &Block{dataRoot: headers[i].dataRoot, stateRoot: headers[i].stateRoot, newHash: newHash,
+			hashAlgorithm: headers[i].hashAlgorithm}
+		if !b.VerifyFraudProof(proofs[i]) {
+			return false
+		}
+	}
+	return true
+}
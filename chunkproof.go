@@ -0,0 +1,215 @@
+package fraudproofs
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// ErrInvalidChunkProof is returned when the data tree of a block fails to prove one of its chunks against the data
+// root, instead of generating a fraud proof that would not verify.
+var ErrInvalidChunkProof = errors.New("data tree does not prove the chunk against the data root")
+
+// dataTree holds the chunks of the data tree of a block and the position of each transaction in their data.
+type dataTree struct {
+	chunks    [][]byte
+	positions []int // by transaction index
+}
+
+// ensureDataTree returns the chunks of the data tree of the block, building them on first use only, so that blocks that
+// are stored (eg. deserialized) but never asked for a proof do not pay for them. They are built under the lock of the
+// block, so that several goroutines can generate or verify fraud proofs against the block concurrently; blocks created
+// without a lock (ie. not by a constructor of the package) must not be shared between goroutines. The block must not
+// be modified afterwards.
+func (b *Block) ensureDataTree() (*dataTree, error) {
+	if b.dataTreeMu != nil {
+		b.dataTreeMu.Lock()
+		defer b.dataTreeMu.Unlock()
+	}
+	if b.dataTree == nil {
+		chunks, positions, err := makeChunks(b.chunkSize, b.transactions, b.interStateRoots)
+		if err != nil {
+			return nil, err
+		}
+		b.dataTree = &dataTree{chunks, positions}
+	}
+	return b.dataTree, nil
+}
+
+// proveTransactions returns the indexes of the chunks holding the transactions of the block from the intermediate state
+// root preceding the i-th transaction up to the i-th transaction, along with the chunks, their Merkle proofs, the
+// number of leaves of the data tree, and the position of the first transaction in the data of the chunks.
+func (b *Block) proveTransactions(i int) ([]uint64, [][]byte, [][][]byte, uint64, uint64, error) {
+	tree, err := b.ensureDataTree()
+	if err != nil {
+		return nil, nil, nil, 0, 0, err
+	}
+	positions := tree.positions
+	k := i / Step
+	start, first := 0, 0
+	if k > 0 {
+		first = positions[k*Step]
+		start = first - len(b.interStateRoots[k-1]) - 1
+	}
+	end := positions[i] + 1 + len(b.transactions[i].Serialize())
+	size := b.chunkSize - 1
+	var chunksIndexes []uint64
+	for j := start / size; j <= (end-1)/size; j++ {
+		chunksIndexes = append(chunksIndexes, uint64(j))
+	}
+	chunks, proofChunks, numOfLeaves, err := b.proveChunks(chunksIndexes)
+	if err != nil {
+		return nil, nil, nil, 0, 0, err
+	}
+	return chunksIndexes, chunks, proofChunks, numOfLeaves, uint64(first - int(chunksIndexes[0])*size), nil
+}
+
+// ChunksForTransaction returns the chunks of the data tree covering the index-th transaction of the block (and nothing
+// else), along with their indexes and their Merkle proofs; with the number of leaves of the data tree (see NumLeaves),
+// they are the data needed to reveal the transaction against the data root of the block.
+func (b *Block) ChunksForTransaction(index int) ([][]byte, []uint64, [][][]byte, error) {
+	if index < 0 || index >= len(b.transactions) {
+		return nil, nil, nil, errors.New("transaction index out of range")
+	}
+	tree, err := b.ensureDataTree()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	start := tree.positions[index]
+	end := start + 1 + len(b.transactions[index].Serialize())
+	size := b.chunkSize - 1
+	var chunksIndexes []uint64
+	for j := start / size; j <= (end-1)/size; j++ {
+		chunksIndexes = append(chunksIndexes, uint64(j))
+	}
+	chunks, proofChunks, _, err := b.proveChunks(chunksIndexes)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return chunks, chunksIndexes, proofChunks, nil
+}
+
+// extractTransactions checks the chunks of a fraud proof generated by proveTransactions, and returns the transactions
+// they hold along with the intermediate state root preceding them.
+func (b *Block) extractTransactions(fp FraudProof) ([]*Transaction, []byte, bool) {
+	_, preStateRoot, ok := b.groupData(fp, fp.txIndex/uint64(Step))
+	if !ok {
+		return nil, nil, false
+	}
+
+	// extract the transactions up to the one the fraud proof is about
+	t, err := fp.transactions()
+	if err != nil {
+		return nil, nil, false
+	}
+	return t, preStateRoot, true
+}
+
+// groupData checks that the chunks of a fraud proof are consecutive chunks of the data tree of the block, and that the
+// data of the chunks at the offset of the fraud proof starts the k-th group of transactions, ie. follows the
+// intermediate state root preceding the group. It returns the data from the offset, along with the intermediate state
+// root preceding the group.
+func (b *Block) groupData(fp FraudProof, k uint64) ([]byte, []byte, bool) {
+	if !b.verifyChunks(fp) {
+		return nil, nil, false
+	}
+	for i := 1; i < len(fp.chunksIndexes); i++ {
+		if fp.chunksIndexes[i] != fp.chunksIndexes[0]+uint64(i) {
+			return nil, nil, false
+		}
+	}
+
+	var buff []byte
+	for i := 0; i < len(fp.chunks); i++ {
+		buff = append(buff, fp.chunks[i][1:]...)
+	}
+	if fp.offset > uint64(len(buff)) {
+		return nil, nil, false
+	}
+	preStateRoot := b.preStateRoot
+	if k == 0 {
+		if fp.chunksIndexes[0] != 0 || fp.offset != 0 {
+			return nil, nil, false
+		}
+	} else {
+		if k > uint64(len(b.interStateRoots)) {
+			return nil, nil, false
+		}
+		preStateRoot = b.interStateRoots[k-1]
+		if fp.offset < uint64(len(preStateRoot))+1 ||
+			buff[fp.offset-uint64(len(preStateRoot))-1] != byte(LeafStateRoot) ||
+			!bytes.Equal(buff[fp.offset-uint64(len(preStateRoot)):fp.offset], preStateRoot) {
+			return nil, nil, false
+		}
+	}
+	return buff[fp.offset:], preStateRoot, true
+}
+
+// proveChunks returns the chunks of the data tree at the given indexes, along with their Merkle proofs and the number
+// of leaves of the data tree.
+func (b *Block) proveChunks(chunksIndexes []uint64) ([][]byte, [][][]byte, uint64, error) {
+	tree, err := b.ensureDataTree()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	chunks := tree.chunks
+	var concernedChunks [][]byte
+	for j := 0; j < len(chunksIndexes); j++ {
+		if chunksIndexes[j] >= uint64(len(chunks)) {
+			return nil, nil, 0, ErrInvalidChunkProof
+		}
+		concernedChunks = append(concernedChunks, chunks[chunksIndexes[j]])
+	}
+
+	proofChunks := make([][][]byte, len(chunksIndexes))
+	var numOfLeaves uint64
+	scheme := b.dataTreeScheme.orDefault()
+	hasher := b.newHash() // shared by every temporary data tree
+	for j := 0; j < len(chunksIndexes); j++ {
+		// merkletree.Tree cannot call SetIndex on Tree if Tree has not been reset
+		// a dirty workaround is to copy the data tree
+		hasher.Reset()
+		tmpDataTree := scheme.New(hasher)
+		err = tmpDataTree.SetIndex(chunksIndexes[j])
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		for i := 0; i < len(chunks); i++ {
+			tmpDataTree.Push(chunks[i])
+		}
+		_, proof, _, leaves := tmpDataTree.Prove()
+
+		// a proof that does not verify would only make an invalid fraud proof
+		hasher.Reset()
+		if len(proof) == 0 || !scheme.VerifyProof(hasher, b.dataRoot, proof, chunksIndexes[j], leaves) {
+			return nil, nil, 0, ErrInvalidChunkProof
+		}
+		numOfLeaves = leaves
+		proofChunks[j] = proof
+	}
+	return concernedChunks, proofChunks, numOfLeaves, nil
+}
+
+// verifyChunks checks that the chunks of the fraud proof are in the data tree of the block.
+func (b *Block) verifyChunks(fp FraudProof) bool {
+	return fp.verifyChunks(b.dataRoot, b.newHash, b.dataTreeScheme)
+}
+
+// verifyChunks checks that the chunks of the fraud proof are in the data tree of the given root and scheme.
+func (fp *FraudProof) verifyChunks(dataRoot []byte, newHash func() hash.Hash, scheme DataTreeScheme) bool {
+	if len(fp.chunks) == 0 || len(fp.chunks) != len(fp.proofChunks) || len(fp.chunks) != len(fp.chunksIndexes) {
+		return false
+	}
+	scheme = scheme.orDefault()
+	hasher := newHash() // reset and reused for every proof
+	for i := 0; i < len(fp.proofChunks); i++ {
+		if len(fp.proofChunks[i]) == 0 || len(fp.chunks[i]) == 0 || !bytes.Equal(fp.proofChunks[i][0], fp.chunks[i]) {
+			return false
+		}
+		hasher.Reset()
+		if !scheme.VerifyProof(hasher, dataRoot, fp.proofChunks[i], fp.chunksIndexes[i], fp.numOfLeaves) {
+			return false
+		}
+	}
+	return true
+}
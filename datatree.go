@@ -0,0 +1,65 @@
+package fraudproofs
+
+import (
+	"hash"
+
+	"github.com/NebulousLabs/merkletree"
+)
+
+// DataTree is a binary Merkle tree committing to the chunks of the data of a block; *merkletree.Tree implements it.
+// Proofs start with the proven leaf, followed by the hashes needed to recompute the root.
+type DataTree interface {
+	// SetIndex selects the leaf proven by Prove; it must be called before pushing the first leaf.
+	SetIndex(index uint64) error
+	// Push appends a leaf to the tree.
+	Push(leaf []byte)
+	// Root returns the root of the tree.
+	Root() []byte
+	// Prove returns the root of the tree, the proof of the selected leaf, its index, and the number of leaves.
+	Prove() (root []byte, proof [][]byte, index uint64, numLeaves uint64)
+}
+
+// DataTreeScheme builds the data trees of blocks and verifies their proofs, so that other binary Merkle trees than
+// NebulousLabs/merkletree (eg. RFC 6962) can be plugged in with WithDataTree. A block and the verifiers of its fraud
+// proofs must use the same scheme.
+type DataTreeScheme struct {
+	// New creates an empty data tree hashing with h.
+	New func(h hash.Hash) DataTree
+	// VerifyProof checks that the proof of the leaf at the given index is in the data tree of the given root.
+	VerifyProof func(h hash.Hash, root []byte, proof [][]byte, index uint64, numLeaves uint64) bool
+}
+
+// NebulousDataTree is the default data tree scheme, backed by NebulousLabs/merkletree.
+var NebulousDataTree = DataTreeScheme{
+	New:         func(h hash.Hash) DataTree { return merkletree.New(h) },
+	VerifyProof: merkletree.VerifyProof,
+}
+
+// WithDataTree sets the scheme of the data tree of the block (defaults to NebulousDataTree). It is not serialized, like
+// the hash function: deserialized blocks and header-only verifiers use the default scheme.
+func WithDataTree(s DataTreeScheme) BlockOption {
+	return func(b *Block) {
+		b.dataTreeScheme = s
+	}
+}
+
+// orDefault returns the scheme, or NebulousDataTree if it is not set.
+func (s DataTreeScheme) orDefault() DataTreeScheme {
+	if s.New == nil || s.VerifyProof == nil {
+		return NebulousDataTree
+	}
+	return s
+}
+
+// computeDataRoot returns the root of the data tree of the chunks with the scheme of the block; the default tree hashes
+// its leaves in parallel.
+func (b *Block) computeDataRoot(chunks [][]byte) []byte {
+	if b.dataTreeScheme.New == nil || b.dataTreeScheme.VerifyProof == nil {
+		return parallelDataRoot(chunks, b.newHash)
+	}
+	tree := b.dataTreeScheme.New(b.newHash())
+	for i := 0; i < len(chunks); i++ {
+		tree.Push(chunks[i])
+	}
+	return tree.Root()
+}
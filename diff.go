@@ -0,0 +1,60 @@
+package fraudproofs
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// FraudProofDiff returns a human-readable description of every field in which the fraud proofs differ (eg.
+// "chunksIndexes[2]: 5 != 7"), to debug a prover and a verifier disagreeing on a fraud proof; byte arrays are printed
+// in hexadecimal. It returns nothing if the fraud proofs are equal.
+func FraudProofDiff(a, b *FraudProof) []string {
+	var diff []string
+	integer := func(name string, x, y uint64) {
+		if x != y {
+			diff = append(diff, fmt.Sprintf("%s: %d != %d", name, x, y))
+		}
+	}
+	element := func(name string, x, y []byte) {
+		if !bytes.Equal(x, y) {
+			diff = append(diff, fmt.Sprintf("%s: %x != %x", name, x, y))
+		}
+	}
+	elements := func(name string, x, y [][]byte) {
+		integer("len("+name+")", uint64(len(x)), uint64(len(y)))
+		for i := 0; i < len(x) && i < len(y); i++ {
+			element(fmt.Sprintf("%s[%d]", name, i), x[i], y[i])
+		}
+	}
+
+	integer("kind", uint64(a.kind), uint64(b.kind))
+	elements("writeKeys", a.writeKeys, b.writeKeys)
+	elements("oldData", a.oldData, b.oldData)
+	elements("readKeys", a.readKeys, b.readKeys)
+	elements("readData", a.readData, b.readData)
+	integer("len(proofState)", uint64(len(a.proofState)), uint64(len(b.proofState)))
+	for i := 0; i < len(a.proofState) && i < len(b.proofState); i++ {
+		elements(fmt.Sprintf("proofState[%d]", i), a.proofState[i], b.proofState[i])
+	}
+	elements("chunks", a.chunks, b.chunks)
+	integer("len(proofChunks)", uint64(len(a.proofChunks)), uint64(len(b.proofChunks)))
+	for i := 0; i < len(a.proofChunks) && i < len(b.proofChunks); i++ {
+		elements(fmt.Sprintf("proofChunks[%d]", i), a.proofChunks[i], b.proofChunks[i])
+	}
+	integer("txIndex", a.txIndex, b.txIndex)
+	integer("duplicateIndex", a.duplicateIndex, b.duplicateIndex)
+	element("stateRoot", a.stateRoot, b.stateRoot)
+	element("postStateRoot", a.postStateRoot, b.postStateRoot)
+	integer("chunkSize", a.chunkSize, b.chunkSize)
+	if a.hashedKeys != b.hashedKeys {
+		diff = append(diff, fmt.Sprintf("hashedKeys: %t != %t", a.hashedKeys, b.hashedKeys))
+	}
+	integer("len(chunksIndexes)", uint64(len(a.chunksIndexes)), uint64(len(b.chunksIndexes)))
+	for i := 0; i < len(a.chunksIndexes) && i < len(b.chunksIndexes); i++ {
+		integer(fmt.Sprintf("chunksIndexes[%d]", i), a.chunksIndexes[i], b.chunksIndexes[i])
+	}
+	integer("numOfLeaves", a.numOfLeaves, b.numOfLeaves)
+	integer("offset", a.offset, b.offset)
+	integer("hashAlgorithm", uint64(a.hashAlgorithm), uint64(b.hashAlgorithm))
+	return diff
+}
@@ -0,0 +1,122 @@
+package fraudproofs
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// firstDuplicate returns the indexes of the first copy and of the second copy of the first transaction appearing twice
+// in the transactions, or -1 if every transaction is unique.
+func firstDuplicate(t []Transaction) (int, int) {
+	seen := make(map[[256]byte]int)
+	for j := 0; j < len(t); j++ {
+		key := t[j].HashKey()
+		if i, ok := seen[key]; ok {
+			return i, j
+		}
+		seen[key] = j
+	}
+	return -1, -1
+}
+
+// CheckDuplicateTransactions checks that the block does not hold the same transaction twice (ie. a transaction replayed
+// within the block), and returns a fraud proof if it does. CheckBlock does not reject duplicate transactions, as
+// transactions without a sender may legitimately repeat; nodes enforcing the rule call it along with CheckBlock.
+func (b *Block) CheckDuplicateTransactions() (*FraudProof, error) {
+	i, j := firstDuplicate(b.transactions)
+	if i < 0 {
+		return nil, nil
+	}
+
+	// reveal the chunks from the intermediate state root preceding the group of the first copy up to the second copy
+	tree, err := b.ensureDataTree()
+	if err != nil {
+		return nil, err
+	}
+	positions := tree.positions
+	k := i / Step
+	first := positions[k*Step]
+	start := first
+	if k > 0 {
+		start -= 1 + len(b.interStateRoots[k-1])
+	}
+	end := positions[j] + 1 + b.transactions[j].Size()
+	size := b.chunkSize - 1
+	var chunksIndexes []uint64
+	for c := start / size; c <= (end-1)/size; c++ {
+		chunksIndexes = append(chunksIndexes, uint64(c))
+	}
+	chunks, proofChunks, numOfLeaves, err := b.proveChunks(chunksIndexes)
+	if err != nil {
+		return nil, err
+	}
+	return &FraudProof{
+		kind:           KindDuplicateTransaction,
+		chunks:         chunks,
+		proofChunks:    proofChunks,
+		txIndex:        uint64(j),
+		duplicateIndex: uint64(i),
+		chunkSize:      uint64(b.chunkSize),
+		chunksIndexes:  chunksIndexes,
+		numOfLeaves:    numOfLeaves,
+		offset:         uint64(first - int(chunksIndexes[0])*size),
+		newHash:        b.newHash,
+		hashAlgorithm:  b.hashAlgorithm,
+		stateEncoding:  b.stateEncoding,
+	}, nil
+}
+
+// transactionPositions returns the position of each transaction of the block in the data of the chunks.
+func (b *Block) transactionPositions() []int {
+	positions := make([]int, len(b.transactions))
+	position := 0
+	for i := 0; i < len(b.transactions); i++ {
+		positions[i] = position
+		position += 1 + b.transactions[i].Size()
+		if (i+1)%Step == 0 {
+			position += 1 + len(b.interStateRoots[(i+1)/Step-1])
+		}
+	}
+	return positions
+}
+
+// verifyDuplicateTransactionFraudProof verifies a fraud proof claiming that the transactions of the block at the
+// duplicate index and at the index of the fraud proof are byte-identical. The chunks are walked from the group of the
+// first copy, checking every intermediate state root on the way, so that both copies are known to be transactions.
+func (b *Block) verifyDuplicateTransactionFraudProof(fp FraudProof) bool {
+	if fp.duplicateIndex >= fp.txIndex {
+		return false
+	}
+	k := fp.duplicateIndex / uint64(Step)
+	buff, _, ok := b.groupData(fp, k)
+	if !ok {
+		return false
+	}
+
+	var copies [][]byte
+	for i := k * uint64(Step); i <= fp.txIndex; i++ {
+		if len(buff) < 1+MaxSize || LeafKind(buff[0]) != LeafTransaction {
+			return false
+		}
+		length := int(binary.LittleEndian.Uint16(buff[1 : 1+MaxSize]))
+		if length < MaxSize || len(buff) < 1+length {
+			return false
+		}
+		if i == fp.duplicateIndex || i == fp.txIndex {
+			copies = append(copies, buff[1:1+length])
+		}
+		buff = buff[1+length:]
+		if i == fp.txIndex || (i+1)%uint64(Step) != 0 {
+			continue
+		}
+		if (i+1)/uint64(Step) > uint64(len(b.interStateRoots)) {
+			return false
+		}
+		root := b.interStateRoots[(i+1)/uint64(Step)-1]
+		if len(buff) < 1+len(root) || LeafKind(buff[0]) != LeafStateRoot || !bytes.Equal(buff[1:1+len(root)], root) {
+			return false
+		}
+		buff = buff[1+len(root):]
+	}
+	return len(copies) == 2 && bytes.Equal(copies[0], copies[1])
+}
@@ -0,0 +1,97 @@
+package fraudproofs
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// errTruncated is returned when decoding runs out of bytes.
+var errTruncated = errors.New("unexpected end of buffer")
+
+// appendBytes appends a length-prefixed array of bytes to the buffer.
+func appendBytes(buff []byte, data []byte) []byte {
+	size := make([]byte, MaxSize)
+	binary.LittleEndian.PutUint16(size, uint16(len(data)))
+	buff = append(buff, size...)
+	return append(buff, data...)
+}
+
+// appendSlices appends a length-prefixed list of length-prefixed arrays of bytes to the buffer.
+func appendSlices(buff []byte, data [][]byte) []byte {
+	size := make([]byte, MaxSize)
+	binary.LittleEndian.PutUint16(size, uint16(len(data)))
+	buff = append(buff, size...)
+	for i := 0; i < len(data); i++ {
+		buff = appendBytes(buff, data[i])
+	}
+	return buff
+}
+
+// appendUint64 appends a fixed-size integer to the buffer.
+func appendUint64(buff []byte, n uint64) []byte {
+	number := make([]byte, 8)
+	binary.LittleEndian.PutUint64(number, n)
+	return append(buff, number...)
+}
+
+// decoder reads the values written by the append functions.
+type decoder struct {
+	buff []byte
+}
+
+func (d *decoder) size() (int, error) {
+	if len(d.buff) < MaxSize {
+		return 0, errTruncated
+	}
+	size := int(binary.LittleEndian.Uint16(d.buff[:MaxSize]))
+	d.buff = d.buff[MaxSize:]
+	return size, nil
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	size, err := d.size()
+	if err != nil {
+		return nil, err
+	}
+	if len(d.buff) < size {
+		return nil, errTruncated
+	}
+	data := make([]byte, size)
+	copy(data, d.buff[:size])
+	d.buff = d.buff[size:]
+	return data, nil
+}
+
+func (d *decoder) slices() ([][]byte, error) {
+	size, err := d.size()
+	if err != nil {
+		return nil, err
+	}
+	var data [][]byte
+	for i := 0; i < size; i++ {
+		item, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, item)
+	}
+	return data, nil
+}
+
+func (d *decoder) uint64() (uint64, error) {
+	if len(d.buff) < 8 {
+		return 0, errTruncated
+	}
+	n := binary.LittleEndian.Uint64(d.buff[:8])
+	d.buff = d.buff[8:]
+	return n, nil
+}
+
+func (d *decoder) uint8() (uint8, error) {
+	if len(d.buff) < 1 {
+		return 0, errTruncated
+	}
+	n := d.buff[0]
+	d.buff = d.buff[1:]
+	return n, nil
+}
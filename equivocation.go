@@ -0,0 +1,31 @@
+package fraudproofs
+
+import (
+	"bytes"
+)
+
+// EquivocationProof proves that a proposer signed two different blocks at the same height.
+type EquivocationProof struct {
+	first  SignedHeader
+	second SignedHeader
+}
+
+// NewEquivocationProof creates an equivocation proof from two conflicting signed headers.
+func NewEquivocationProof(first, second SignedHeader) EquivocationProof {
+	return EquivocationProof{first, second}
+}
+
+// VerifyEquivocation verifies that both headers are validly signed by the same proposer at the same height, and that
+// they are different.
+func VerifyEquivocation(proof EquivocationProof) bool {
+	if !proof.first.Verify() || !proof.second.Verify() {
+		return false
+	}
+	if !bytes.Equal(proof.first.proposer, proof.second.proposer) {
+		return false
+	}
+	if proof.first.header.height != proof.second.header.height {
+		return false
+	}
+	return !bytes.Equal(proof.first.header.Hash(), proof.second.header.Hash())
+}
@@ -0,0 +1,115 @@
+package fraudproofs
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+
+	"github.com/lazyledger/smt"
+)
+
+// ErrInvalidExpectedRoot is returned when a transaction does not lead to the state root it expects.
+var ErrInvalidExpectedRoot = errors.New("transaction does not lead to the state root it expects")
+
+// stateKeys returns the keys of the state tree updated by the transaction.
+func (t *Transaction) stateKeys() [][]byte {
+	if len(t.sender) == 0 {
+		return t.writeKeys
+	}
+	return append(append([][]byte{}, t.writeKeys...), nonceKey(t.sender))
+}
+
+// declaresExpectedRoots returns whether a transaction of the block in [from, to) declares an expected state root.
+func (b *Block) declaresExpectedRoots(from, to int) bool {
+	if to > len(b.transactions) {
+		to = len(b.transactions)
+	}
+	for i := from; i < to; i++ {
+		if len(b.transactions[i].expectedRoot) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// groupStateProof returns a partial fraud proof holding the values and Merkle proofs of the keys updated by the group
+// of transactions starting at the i-th transaction. The state tree must hold the state preceding the group.
+func (b *Block) groupStateProof(i int, stateTree *smt.SparseMerkleTree) (*FraudProof, error) {
+	end := i + Step
+	if end > len(b.transactions) {
+		end = len(b.transactions)
+	}
+	return groupStateProof(b.transactions[i:end], stateTree, b.chunkSize, b.newHash, b.hashAlgorithm, b.stateEncoding)
+}
+
+// groupStateProof returns a partial fraud proof holding the values and Merkle proofs of the keys updated by the
+// transactions, from the state held by the state tree.
+func groupStateProof(t []Transaction, stateTree *smt.SparseMerkleTree, chunkSize int, newHash func() hash.Hash,
+	a HashAlgorithm, e StateEncoding) (*FraudProof, error) {
+	fp := &FraudProof{kind: KindInvalidExpectedRoot, stateRoot: append([]byte{}, stateTree.Root()...),
+		chunkSize: uint64(chunkSize), newHash: newHash, hashAlgorithm: a, stateEncoding: e}
+	proven := make(map[string]bool)
+	for j := 0; j < len(t); j++ {
+		for _, key := range t[j].stateKeys() {
+			if proven[string(key)] {
+				continue
+			}
+			proven[string(key)] = true
+
+			value, err := stateTree.Get(key)
+			if err != nil {
+				return nil, err
+			}
+			proof, err := stateTree.ProveCompact(key)
+			if err != nil {
+				return nil, err
+			}
+			fp.writeKeys = append(fp.writeKeys, key)
+			fp.oldData = append(fp.oldData, value)
+			fp.proofState = append(fp.proofState, proof)
+		}
+	}
+	return fp, nil
+}
+
+// expectedRootFraudProof completes the partial fraud proof returned by groupStateProof for the i-th transaction of the
+// block, which does not lead to the state root it expects.
+func (b *Block) expectedRootFraudProof(i int, group *FraudProof) (*FraudProof, error) {
+	chunksIndexes, chunks, proofChunks, numOfLeaves, offset, err := b.proveTransactions(i)
+	if err != nil {
+		return nil, err
+	}
+
+	fp := group.Copy()
+	fp.chunks = chunks
+	fp.proofChunks = proofChunks
+	fp.txIndex = uint64(i)
+	fp.postStateRoot = append([]byte(nil), b.transactions[i].expectedRoot...)
+	fp.chunksIndexes = chunksIndexes
+	fp.numOfLeaves = numOfLeaves
+	fp.offset = offset
+	return fp, nil
+}
+
+// verifyExpectedRootFraudProof verifies a fraud proof claiming that a transaction of the block does not lead to the
+// state root it expects.
+func (b *Block) verifyExpectedRootFraudProof(fp FraudProof) bool {
+	if len(fp.oldData) != len(fp.writeKeys) || len(fp.proofState) != len(fp.writeKeys) {
+		return false
+	}
+	t, preStateRoot, ok := b.extractTransactions(fp)
+	if !ok {
+		return false
+	}
+	invalid := t[len(t)-1]
+	if len(invalid.expectedRoot) == 0 || !fp.matchesTransition(preStateRoot, invalid.expectedRoot) {
+		return false
+	}
+
+	// check the values of the keys updated by the transactions in the intermediate state, and replay the transactions
+	root, err := fp.recomputeRoot(preStateRoot, b.newHash, b.stateEncoding)
+	if err != nil {
+		return false
+	}
+	return !bytes.Equal(root, invalid.expectedRoot)
+}
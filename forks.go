@@ -0,0 +1,37 @@
+package fraudproofs
+
+import (
+	"bytes"
+)
+
+// Forks tracks competing blockchains (eg. built on top of blocks of different proposers), and picks the canonical one.
+type Forks struct {
+	chains []*Blockchain
+}
+
+// Add adds a competing blockchain.
+func (f *Forks) Add(bc *Blockchain) {
+	f.chains = append(f.chains, bc)
+}
+
+// Canonical returns the canonical blockchain, or nil if there is none: the longest one and, among blockchains of the
+// same length, the one whose last block has the lowest hash (compared byte by byte), so that every node converges to
+// the same blockchain whatever the order in which it learns the forks.
+func (f *Forks) Canonical() *Blockchain {
+	var canonical *Blockchain
+	for _, bc := range f.chains {
+		if canonical == nil || bc.Len() > canonical.Len() ||
+			(bc.Len() == canonical.Len() && bytes.Compare(tipHash(bc), tipHash(canonical)) < 0) {
+			canonical = bc
+		}
+	}
+	return canonical
+}
+
+// tipHash returns the hash of the last block of the blockchain, or nil if it has no block.
+func tipHash(bc *Blockchain) []byte {
+	if bc.last == nil {
+		return nil
+	}
+	return bc.last.Hash()
+}
@@ -2,12 +2,64 @@
 package fraudproofs
 
 import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"errors"
 	"github.com/lazyledger/smt"
+	"hash"
+)
+
+// fraudProofVersion is the version of the fraud proof serialization format.
+const fraudProofVersion byte = 8
+
+// FraudProofKind is the kind of misbehaviour proven by a fraud proof.
+type FraudProofKind byte
+
+const (
+	// KindInvalidStateRoot proves that an intermediate state root of a block is invalid.
+	KindInvalidStateRoot FraudProofKind = iota
+	// KindInvalidNonce proves that a transaction of a block does not use the next nonce of its sender.
+	KindInvalidNonce
+	// KindInvalidExpectedRoot proves that a transaction of a block does not lead to the state root it expects; its
+	// oldData hold the values of the writeKeys as stored in the state tree.
+	KindInvalidExpectedRoot
+	// KindInvalidBalance proves that a write of a block breaks a balance rule; see BalanceRule.
+	KindInvalidBalance
+	// KindStaleOldData proves that a write of a block declares oldData that do not match the value of its key in the
+	// state preceding the transaction; see WithCheckOldData.
+	KindStaleOldData
+	// KindInvalidInterStateRoot proves that an intermediate state root of a block does not result from the group of
+	// transactions preceding it, applied to the previous intermediate state root; see SparseBlock. It also proves that
+	// the state root of a block does not result from its last transactions, when they do not complete a group. Its
+	// oldData hold the values of the keys updated by the group as stored in the state tree.
+	KindInvalidInterStateRoot
+	// KindInvalidRead proves that a transaction of a block declares readData that do not match the value of its key in
+	// the state it reads from; see WithCheckReads.
+	KindInvalidRead
+	// KindBlindWrite proves that a transaction of a block writes to a key existing in the state preceding it without
+	// declaring a read of it; see WithStrictWrites.
+	KindBlindWrite
+	// KindInconsistentRead proves that a transaction of a block reads a key it also writes, and declares readData that
+	// do not match the oldData of its write; see WithCheckReads. It only reveals the transaction.
+	KindInconsistentRead
+	// KindTooManyTransactions proves that a block holds more transactions than a consensus cap allows; see
+	// BlockHeader.VerifyTooManyTransactionsFraudProof. It reveals the chunks up to the first transaction over the cap.
+	KindTooManyTransactions
+	// KindInvalidLayout proves that the chunks of the data tree of a block do not hold an intermediate state root after
+	// every Step transactions and nowhere else; see Block.CheckDataLayout. It reveals the chunks from the start of the
+	// data up to the misplaced entry.
+	KindInvalidLayout
+	// KindDuplicateTransaction proves that a block holds the same transaction twice; see
+	// Block.CheckDuplicateTransactions. It reveals the chunks from the group of the first copy up to the second one.
+	KindDuplicateTransaction
 )
 
 // FraudProof is a fraud proof.
 type FraudProof struct {
 	// data structure
+	kind FraudProofKind
 	writeKeys [][]byte
 	oldData [][]byte
 	readKeys [][]byte
@@ -15,8 +67,665 @@ type FraudProof struct {
 	proofState []smt.SparseCompactMerkleProof
 	chunks [][]byte
 	proofChunks [][][]byte
+	txIndex uint64 // index of the disputed transaction (the last one of its group for invalid state roots)
+	stateRoot []byte // root of the state the state proofs are against
+	// root of the state claimed after the disputed transactions, for fraud proofs of invalid intermediate state roots
+	// and expected roots (nil if unknown, for fraud proofs serialized before version 6)
+	postStateRoot []byte
+	// index of the first copy of the disputed transaction, for fraud proofs of duplicate transactions (0 for the other
+	// kinds, and for fraud proofs serialized before version 7)
+	duplicateIndex uint64
+	// hash function of the data tree and of the state tree (SHA-512/256 for fraud proofs serialized before version 8)
+	hashAlgorithm HashAlgorithm
+	chunkSize uint64 // size of the chunks of the data tree (0 if unknown, for fraud proofs serialized before version 4)
+	hashedKeys bool // whether the writeKeys hold the paths of the keys in the state tree (see HashKeys)
 
 	// implementation specific
 	chunksIndexes []uint64
 	numOfLeaves uint64
+	offset uint64 // position of the first transaction in the data of the chunks (invalid nonce or expected root)
+	newHash func() hash.Hash // hash function of the state tree (not serialized)
+	stateEncoding StateEncoding // encoding of the values of the state tree (not serialized)
+}
+
+// Kind returns the kind of misbehaviour proven by the fraud proof.
+func (fp *FraudProof) Kind() FraudProofKind {
+	return fp.kind
+}
+
+// TxIndex returns the index in its block of the transaction disputed by the fraud proof; for fraud proofs of invalid
+// state roots and intermediate state roots, it is the last transaction of the group preceding the disputed root.
+func (fp *FraudProof) TxIndex() uint64 {
+	return fp.txIndex
+}
+
+// Validate checks the structural invariants of the fraud proof, independently of any block.
+func (fp *FraudProof) Validate() error {
+	if fp.kind > KindDuplicateTransaction {
+		return fmt.Errorf("unknown fraud proof kind %d", fp.kind)
+	}
+	if _, err := fp.hashAlgorithm.newHash(); err != nil {
+		return err
+	}
+	if len(fp.writeKeys) != len(fp.oldData) {
+		return errors.New("number of writeKeys does not match the number of oldData")
+	}
+	if len(fp.readKeys) != len(fp.readData) {
+		return errors.New("number of readKeys does not match the number of readData")
+	}
+	if fp.hashedKeys {
+		err := fp.validateHashedKeys()
+		if err != nil {
+			return err
+		}
+	}
+	proven := len(fp.writeKeys)
+	switch fp.kind {
+	case KindInvalidNonce, KindStaleOldData, KindInvalidRead, KindBlindWrite:
+		proven = len(fp.readKeys)
+	case KindInvalidBalance, KindInconsistentRead, KindTooManyTransactions, KindInvalidLayout, KindDuplicateTransaction:
+		proven = 0 // the data is read from the chunks, or not read at all
+	}
+	if len(fp.proofState) != proven {
+		return errors.New("number of state proofs does not match the number of keys")
+	}
+	if len(fp.chunks) == 0 {
+		return errors.New("fraud proof has no chunks")
+	}
+	if len(fp.chunks) != len(fp.proofChunks) || len(fp.chunks) != len(fp.chunksIndexes) {
+		return errors.New("number of chunks does not match the number of chunk proofs or chunk indexes")
+	}
+	for i := 0; i < len(fp.chunks); i++ {
+		if len(fp.chunks[i]) == 0 {
+			return fmt.Errorf("chunk %d is empty", i)
+		}
+	}
+	if fp.numOfLeaves < uint64(len(fp.chunks)) {
+		return errors.New("number of leaves is smaller than the number of chunks")
+	}
+	for i := 0; i < len(fp.chunksIndexes); i++ {
+		if fp.chunksIndexes[i] >= fp.numOfLeaves {
+			return fmt.Errorf("chunk index %d is out of range", fp.chunksIndexes[i])
+		}
+		if i > 0 && fp.chunksIndexes[i] <= fp.chunksIndexes[i-1] {
+			return errors.New("chunk indexes are not sorted")
+		}
+	}
+	return fp.validateChunkSize()
+}
+
+// validateChunkSize checks that the chunks of the fraud proof have the chunk size it embeds: every chunk but the last
+// leaf of the data tree is full.
+func (fp *FraudProof) validateChunkSize() error {
+	if fp.chunkSize == 0 {
+		return nil // fraud proofs serialized before version 4 do not embed their chunk size
+	}
+	if fp.chunkSize < 2 || fp.chunkSize > 256 {
+		return ErrInvalidChunkSize
+	}
+	for i := 0; i < len(fp.chunks); i++ {
+		size := uint64(len(fp.chunks[i]))
+		if size > fp.chunkSize || (size != fp.chunkSize && fp.chunksIndexes[i] != fp.numOfLeaves-1) {
+			return fmt.Errorf("chunk %d does not match the chunk size", i)
+		}
+	}
+	return nil
+}
+
+// RecomputedRoot applies the writes of the fraud proof to the state it proves, and returns the resulting state root; a
+// developer can compare it against the state root claimed by the block. Fraud proofs of invalid nonces do not write to
+// the state, and deserialized fraud proofs use the hash function they identify and the raw state encoding.
+func (fp *FraudProof) RecomputedRoot() ([]byte, error) {
+	if len(fp.stateRoot) == 0 {
+		return nil, errors.New("fraud proof does not hold the root of its state proofs")
+	}
+	return fp.recomputeRoot(fp.stateRoot, fp.hashFunction(), fp.stateEncoding)
+}
+
+// hashFunction returns the hash function of the fraud proof; deserialized fraud proofs use the hash function identified
+// by their hash algorithm, or SHA-512/256 if it is unknown.
+func (fp *FraudProof) hashFunction() func() hash.Hash {
+	if fp.newHash == nil {
+		newHash, err := fp.hashAlgorithm.newHash()
+		if err != nil {
+			return sha512.New512_256
+		}
+		return newHash
+	}
+	return fp.newHash
+}
+
+// HashAlgorithm returns the identifier of the hash function of the data tree and of the state tree the fraud proof is
+// about.
+func (fp *FraudProof) HashAlgorithm() HashAlgorithm {
+	return fp.hashAlgorithm
+}
+
+// VerifyChunks checks that the chunks of the fraud proof are in the data tree of the given root, ie. that the data the
+// fraud proof is about is committed by the block, independently of the state transition. The data tree is expected to
+// use the default scheme (see WithDataTree).
+func (fp *FraudProof) VerifyChunks(dataRoot []byte) bool {
+	return fp.verifyChunks(dataRoot, fp.hashFunction(), NebulousDataTree)
+}
+
+// VerifyState checks that applying the writes of a fraud proof of an invalid state root to the keys it proves leads to
+// the given state root, independently of the inclusion of its chunks in the data tree. The writes are the ones of the
+// transactions held in the chunks; the fraud proof does not check if it declares other writes.
+func (fp *FraudProof) VerifyState(stateRoot []byte) bool {
+	return fp.verifyState(stateRoot, fp.hashFunction(), fp.stateEncoding)
+}
+
+// verifyState checks the state half of a fraud proof of an invalid state root against the given state root.
+func (fp *FraudProof) verifyState(stateRoot []byte, newHash func() hash.Hash, e StateEncoding) bool {
+	if fp.kind != KindInvalidStateRoot {
+		return false
+	}
+	root, err := fp.recomputeRoot(stateRoot, newHash, e)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(root, stateRoot)
+}
+
+// VerifyTransition checks, without the block, that applying the transactions held in the chunks of a fraud proof of an
+// invalid intermediate state root or expected root to the state proven against its pre-state root does not lead to its
+// post-state root; the verifier must still check that both roots and the chunks are the ones of the block.
+func (fp *FraudProof) VerifyTransition() bool {
+	if fp.kind != KindInvalidInterStateRoot && fp.kind != KindInvalidExpectedRoot {
+		return false
+	}
+	if len(fp.stateRoot) == 0 || len(fp.postStateRoot) == 0 {
+		return false
+	}
+	root, err := fp.recomputeRoot(fp.stateRoot, fp.hashFunction(), fp.stateEncoding)
+	if err != nil {
+		return false
+	}
+	return !bytes.Equal(root, fp.postStateRoot)
+}
+
+// matchesTransition checks that the pre-state and post-state roots of a fraud proof of a state transition, if known,
+// are the ones claimed by the block before and after the disputed transactions.
+func (fp *FraudProof) matchesTransition(preStateRoot, postStateRoot []byte) bool {
+	return (len(fp.stateRoot) == 0 || bytes.Equal(fp.stateRoot, preStateRoot)) &&
+		(len(fp.postStateRoot) == 0 || bytes.Equal(fp.postStateRoot, postStateRoot))
+}
+
+// recomputeRoot applies the writes of the fraud proof to the state of the given root, and returns the resulting root.
+func (fp *FraudProof) recomputeRoot(stateRoot []byte, newHash func() hash.Hash, e StateEncoding) ([]byte, error) {
+	state := newProvenState(stateRoot, newHash)
+	switch fp.kind {
+	case KindInvalidStateRoot:
+		// the writes are the ones of the transactions revealed by the chunks, whatever the fraud proof declares
+		writeKeys, oldData, newData, err := fp.writes()
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(fp.writeKeys); i++ {
+			if !fp.provesKey(i, writeKeys[i], newHash) || !bytes.Equal(fp.oldData[i], oldData[i]) {
+				return nil, errors.New("fraud proof declares writes that the transactions do not make")
+			}
+		}
+		for i := 0; i < len(fp.writeKeys); i++ {
+			err := state.add(fp.proofState[i], writeKeys[i], e.encode(newData[i]))
+			if err != nil {
+				return nil, err
+			}
+			err = state.update(writeKeys[i], e.encode(newData[i]))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	case KindInvalidExpectedRoot, KindInvalidInterStateRoot:
+		t, err := fp.transactions()
+		if err != nil {
+			return nil, err
+		}
+		var keys [][]byte
+		for _, tx := range t {
+			keys = append(keys, tx.stateKeys()...)
+		}
+		writeKeys, err := fp.keys(keys, newHash)
+		if err != nil {
+			return nil, err
+		}
+		proven := make(map[string]bool)
+		for i := 0; i < len(writeKeys); i++ {
+			err := state.add(fp.proofState[i], writeKeys[i], fp.oldData[i])
+			if err != nil {
+				return nil, err
+			}
+			proven[string(writeKeys[i])] = true
+		}
+		for _, tx := range t {
+			keys := tx.stateKeys()
+			for j := 0; j < len(keys); j++ {
+				if !proven[string(keys[j])] {
+					return nil, errors.New("fraud proof does not prove every key written by the transactions")
+				}
+				value := nonceValue(tx.nonce + 1)
+				if j < len(tx.writeKeys) {
+					value = e.encode(tx.newData[j])
+				}
+				err := state.update(keys[j], value)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+	default:
+		return nil, errors.New("fraud proof does not write to the state")
+	}
+	return state.root(), nil
+}
+
+// writes extracts the keys, the old data and the new data of the writes of the transactions held in the chunks of the
+// fraud proof.
+func (fp *FraudProof) writes() ([][]byte, [][]byte, [][]byte, error) {
+	var buff []byte
+	for i := 0; i < len(fp.chunks); i++ {
+		buff = append(buff, fp.chunks[i][1:]...)
+	}
+
+	var keys, oldData, newData [][]byte
+	if int(fp.chunks[0][0]) > len(buff) {
+		return nil, nil, nil, errTruncated
+	}
+	buff = buff[fp.chunks[0][0]:]
+	rootSize := fp.hashFunction()().Size()
+	for len(buff) > MaxSize {
+		kind := LeafKind(buff[0])
+		buff = buff[1:]
+		if kind == LeafStateRoot {
+			if len(buff) < rootSize {
+				break
+			}
+			buff = buff[rootSize:]
+			continue
+		}
+		if kind != LeafTransaction {
+			return nil, nil, nil, ErrInvalidLeafKind
+		}
+		length := int(binary.LittleEndian.Uint16(buff[:MaxSize]))
+		if len(buff) < length {
+			break
+		}
+		t, err := Deserialize(buff[:length])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		buff = buff[length:]
+		keys = append(keys, t.writeKeys...)
+		oldData = append(oldData, t.oldData...)
+		newData = append(newData, t.newData...)
+	}
+	if len(newData) < len(fp.writeKeys) {
+		return nil, nil, nil, errors.New("chunks do not hold the new data of every writeKey")
+	}
+	return keys, oldData, newData, nil
+}
+
+// transactions extracts the transactions held in the chunks of the fraud proof from its offset up to its transaction.
+func (fp *FraudProof) transactions() ([]*Transaction, error) {
+	var buff []byte
+	for i := 0; i < len(fp.chunks); i++ {
+		buff = append(buff, fp.chunks[i][1:]...)
+	}
+	if fp.offset > uint64(len(buff)) {
+		return nil, errTruncated
+	}
+	buff = buff[fp.offset:]
+
+	// count the transactions rather than iterating up to txIndex, which would wrap around for the largest indexes
+	var t []*Transaction
+	for i := uint64(0); i <= fp.txIndex%uint64(Step); i++ {
+		if len(buff) < 1+MaxSize {
+			return nil, errTruncated
+		}
+		if LeafKind(buff[0]) != LeafTransaction {
+			return nil, ErrInvalidLeafKind
+		}
+		buff = buff[1:]
+		length := int(binary.LittleEndian.Uint16(buff[:MaxSize]))
+		if length < MaxSize || len(buff) < length {
+			return nil, errTruncated
+		}
+		tx, err := Deserialize(buff[:length])
+		if err != nil {
+			return nil, err
+		}
+		buff = buff[length:]
+		t = append(t, tx)
+	}
+	return t, nil
+}
+
+// Copy returns a deep copy of the fraud proof.
+func (fp *FraudProof) Copy() *FraudProof {
+	copyFp := &FraudProof{
+		kind:           fp.kind,
+		writeKeys:      copySlices(fp.writeKeys),
+		oldData:        copySlices(fp.oldData),
+		readKeys:       copySlices(fp.readKeys),
+		readData:       copySlices(fp.readData),
+		proofState:     make([]smt.SparseCompactMerkleProof, len(fp.proofState)),
+		chunks:         copySlices(fp.chunks),
+		proofChunks:    make([][][]byte, len(fp.proofChunks)),
+		txIndex:        fp.txIndex,
+		stateRoot:      append([]byte(nil), fp.stateRoot...),
+		postStateRoot:  append([]byte(nil), fp.postStateRoot...),
+		duplicateIndex: fp.duplicateIndex,
+		hashAlgorithm:  fp.hashAlgorithm,
+		chunkSize:      fp.chunkSize,
+		hashedKeys:     fp.hashedKeys,
+		chunksIndexes:  make([]uint64, len(fp.chunksIndexes)),
+		numOfLeaves:    fp.numOfLeaves,
+		offset:         fp.offset,
+		newHash:        fp.newHash,
+		stateEncoding:  fp.stateEncoding,
+	}
+	for i := 0; i < len(fp.proofState); i++ {
+		copyFp.proofState[i] = copySlices(fp.proofState[i])
+	}
+	for i := 0; i < len(fp.proofChunks); i++ {
+		copyFp.proofChunks[i] = copySlices(fp.proofChunks[i])
+	}
+	copy(copyFp.chunksIndexes, fp.chunksIndexes)
+	return copyFp
+}
+
+// Equal returns whether two fraud proofs are identical.
+func (fp *FraudProof) Equal(other *FraudProof) bool {
+	if fp.kind != other.kind || fp.txIndex != other.txIndex || fp.offset != other.offset ||
+		!bytes.Equal(fp.stateRoot, other.stateRoot) || !bytes.Equal(fp.postStateRoot, other.postStateRoot) ||
+		fp.duplicateIndex != other.duplicateIndex || fp.hashAlgorithm != other.hashAlgorithm ||
+		fp.chunkSize != other.chunkSize || fp.hashedKeys != other.hashedKeys {
+		return false
+	}
+	if fp.numOfLeaves != other.numOfLeaves || len(fp.chunksIndexes) != len(other.chunksIndexes) {
+		return false
+	}
+	for i := 0; i < len(fp.chunksIndexes); i++ {
+		if fp.chunksIndexes[i] != other.chunksIndexes[i] {
+			return false
+		}
+	}
+	if !equalSlices(fp.writeKeys, other.writeKeys) || !equalSlices(fp.oldData, other.oldData) ||
+		!equalSlices(fp.readKeys, other.readKeys) || !equalSlices(fp.readData, other.readData) ||
+		!equalSlices(fp.chunks, other.chunks) {
+		return false
+	}
+	if len(fp.proofState) != len(other.proofState) || len(fp.proofChunks) != len(other.proofChunks) {
+		return false
+	}
+	for i := 0; i < len(fp.proofState); i++ {
+		if !equalSlices(fp.proofState[i], other.proofState[i]) {
+			return false
+		}
+	}
+	for i := 0; i < len(fp.proofChunks); i++ {
+		if !equalSlices(fp.proofChunks[i], other.proofChunks[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// copySlices returns a deep copy of a list of arrays of bytes.
+func copySlices(data [][]byte) [][]byte {
+	if data == nil {
+		return nil
+	}
+	c := make([][]byte, len(data))
+	for i := 0; i < len(data); i++ {
+		c[i] = append([]byte{}, data[i]...)
+	}
+	return c
+}
+
+// equalSlices returns whether two lists of arrays of bytes are identical.
+func equalSlices(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// SizeBytes returns the size of the fraud proof in bytes.
+func (fp *FraudProof) SizeBytes() int {
+	size := 0
+	for _, field := range [][][]byte{fp.writeKeys, fp.oldData, fp.readKeys, fp.readData, fp.chunks} {
+		for i := 0; i < len(field); i++ {
+			size += len(field[i])
+		}
+	}
+	for i := 0; i < len(fp.proofState); i++ {
+		for j := 0; j < len(fp.proofState[i]); j++ {
+			size += len(fp.proofState[i][j])
+		}
+	}
+	for i := 0; i < len(fp.proofChunks); i++ {
+		for j := 0; j < len(fp.proofChunks[i]); j++ {
+			size += len(fp.proofChunks[i][j])
+		}
+	}
+	size += 8 * len(fp.chunksIndexes) // chunksIndexes
+	size += 8 // numOfLeaves
+	size += len(fp.stateRoot) + len(fp.postStateRoot)
+	size += 8 // chunkSize
+	size += 1 // hashAlgorithm
+	if fp.kind != KindInvalidStateRoot {
+		size += 8 + 8 // txIndex and offset
+	}
+	if fp.kind == KindDuplicateTransaction {
+		size += 8 // duplicateIndex
+	}
+	return size
+}
+
+// Serialize converts a fraud proof into an array of bytes, prefixed by the version of the format.
+func (fp *FraudProof) Serialize() []byte {
+	buff := []byte{fraudProofVersion, byte(fp.kind)}
+	buff = appendSlices(buff, fp.writeKeys)
+	buff = appendSlices(buff, fp.oldData)
+	buff = appendSlices(buff, fp.readKeys)
+	buff = appendSlices(buff, fp.readData)
+	buff = appendUint64(buff, uint64(len(fp.proofState)))
+	for i := 0; i < len(fp.proofState); i++ {
+		buff = appendSlices(buff, fp.proofState[i])
+	}
+	buff = appendSlices(buff, fp.chunks)
+	buff = appendUint64(buff, uint64(len(fp.proofChunks)))
+	for i := 0; i < len(fp.proofChunks); i++ {
+		buff = appendSlices(buff, fp.proofChunks[i])
+	}
+	buff = appendUint64(buff, uint64(len(fp.chunksIndexes)))
+	for i := 0; i < len(fp.chunksIndexes); i++ {
+		buff = appendUint64(buff, fp.chunksIndexes[i])
+	}
+	buff = appendUint64(buff, fp.numOfLeaves)
+	buff = appendUint64(buff, fp.txIndex)
+	buff = appendUint64(buff, fp.offset)
+	buff = appendBytes(buff, fp.stateRoot)
+	buff = appendUint64(buff, fp.chunkSize)
+	if fp.hashedKeys {
+		buff = append(buff, 1)
+	} else {
+		buff = append(buff, 0)
+	}
+	buff = appendBytes(buff, fp.postStateRoot)
+	buff = appendUint64(buff, fp.duplicateIndex)
+	buff = append(buff, byte(fp.hashAlgorithm))
+	return buff
+}
+
+// DeserializeFraudProof converts a serialized fraud proof (ie. array of bytes) into a fraud proof structure.
+func DeserializeFraudProof(buff []byte) (*FraudProof, error) {
+	if len(buff) == 0 {
+		return nil, errors.New("empty fraud proof")
+	}
+	switch buff[0] {
+	case 1:
+		return deserializeFraudProofV1(buff[1:])
+	case 2, 3, 4, 5, 6, 7, 8:
+		return deserializeFraudProofWithKind(buff[1:], buff[0])
+	default:
+		return nil, fmt.Errorf("unsupported fraud proof version %d", buff[0])
+	}
+}
+
+// deserializeFraudProofV1 deserializes a fraud proof of version 1 (without the version byte); version 1 only supports
+// invalid state roots.
+func deserializeFraudProofV1(buff []byte) (*FraudProof, error) {
+	d := &decoder{buff}
+	fp := &FraudProof{}
+	err := fp.decodeFields(d)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.buff) != 0 {
+		return nil, errors.New("trailing bytes after fraud proof")
+	}
+	return fp, nil
+}
+
+// deserializeFraudProofWithKind deserializes a fraud proof of version 2 to 7 (without the version byte); version 3 adds
+// the root of the state proofs, version 4 the chunk size, version 5 whether the keys are hashed, version 6 the
+// post-state root, and version 7 the index of the first copy of a duplicate transaction.
+func deserializeFraudProofWithKind(buff []byte, version byte) (*FraudProof, error) {
+	d := &decoder{buff}
+	kind, err := d.uint8()
+	if err != nil {
+		return nil, err
+	}
+	fp := &FraudProof{kind: FraudProofKind(kind)}
+	if fp.kind > KindDuplicateTransaction {
+		return nil, fmt.Errorf("unsupported fraud proof kind %d", kind)
+	}
+	err = fp.decodeFields(d)
+	if err != nil {
+		return nil, err
+	}
+	fp.txIndex, err = d.uint64()
+	if err != nil {
+		return nil, err
+	}
+	fp.offset, err = d.uint64()
+	if err != nil {
+		return nil, err
+	}
+	if version >= 3 {
+		fp.stateRoot, err = d.bytes()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if version >= 4 {
+		fp.chunkSize, err = d.uint64()
+		if err != nil {
+			return nil, err
+		}
+		if fp.chunkSize < 2 || fp.chunkSize > 256 {
+			return nil, ErrInvalidChunkSize
+		}
+	}
+	if version >= 5 {
+		flag, err := d.uint8()
+		if err != nil {
+			return nil, err
+		}
+		if flag > 1 {
+			return nil, errors.New("invalid hashed keys flag")
+		}
+		fp.hashedKeys = flag == 1
+	}
+	if version >= 6 {
+		fp.postStateRoot, err = d.bytes()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if version >= 7 {
+		fp.duplicateIndex, err = d.uint64()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if version >= 8 {
+		algorithm, err := d.uint8()
+		if err != nil {
+			return nil, err
+		}
+		fp.hashAlgorithm = HashAlgorithm(algorithm)
+	}
+	fp.newHash, err = fp.hashAlgorithm.newHash()
+	if err != nil {
+		return nil, err
+	}
+	if len(d.buff) != 0 {
+		return nil, errors.New("trailing bytes after fraud proof")
+	}
+	return fp, nil
+}
+
+// decodeFields decodes the fields shared by every version of the fraud proof serialization format.
+func (fp *FraudProof) decodeFields(d *decoder) error {
+	var err error
+	for _, field := range []*[][]byte{&fp.writeKeys, &fp.oldData, &fp.readKeys, &fp.readData} {
+		*field, err = d.slices()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := d.uint64()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		proof, err := d.slices()
+		if err != nil {
+			return err
+		}
+		fp.proofState = append(fp.proofState, proof)
+	}
+
+	fp.chunks, err = d.slices()
+	if err != nil {
+		return err
+	}
+
+	n, err = d.uint64()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		proof, err := d.slices()
+		if err != nil {
+			return err
+		}
+		fp.proofChunks = append(fp.proofChunks, proof)
+	}
+
+	n, err = d.uint64()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		index, err := d.uint64()
+		if err != nil {
+			return err
+		}
+		fp.chunksIndexes = append(fp.chunksIndexes, index)
+	}
+
+	fp.numOfLeaves, err = d.uint64()
+	return err
 }
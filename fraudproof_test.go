@@ -2,15 +2,33 @@ package fraudproofs
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
 	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
+	"hash"
+	"io"
+	"math"
 	"github.com/NebulousLabs/merkletree"
 	"github.com/lazyledger/smt"
+	"golang.org/x/crypto/sha3"
+	"math/big"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/quick"
 	"time"
 )
 
+var updateGolden = flag.Bool("update", false, "rewrite the golden serialization fixtures in testdata")
+
 func TestTransaction(test *testing.T) {
 	// create good transaction
 	_, err :=  NewTransaction(generateCorruptedTransactionInput())
@@ -18,104 +36,4401 @@ func TestTransaction(test *testing.T) {
 		test.Error("should return an error")
 	}
 
+	// create transaction with corrupted read data
+	_, err = NewTransaction(generateCorruptedReadTransactionInput())
+	if err != ErrReadKeyDataMismatch {
+		test.Error("should return ErrReadKeyDataMismatch")
+	}
+
 	// create bad transaction
 	goodT, err :=  NewTransaction(generateTransactionInput())
 	if err != nil {
-		test.Error(err)
+		test.Error(err)
+	}
+
+	// serialize and deserialize
+	buff := goodT.Serialize()
+	t, err := Deserialize(buff)
+	if err != nil {
+		test.Error(err)
+	} else if bytes.Compare(t.Serialize(), buff) != 0 {
+		test.Error("transaction not serialized and deserialize correctly")
+	}
+}
+
+func TestTransactionWith(test *testing.T) {
+	t, err := NewTransaction(generateTransactionInput())
+	if err != nil {
+		test.Fatal(err)
+	}
+	original := t.Serialize()
+
+	// only the nonce changes
+	c := t.With(WithNonce(5))
+	if c.nonce != 5 || t.nonce != 0 {
+		test.Error("only the copy should use the new nonce")
+	}
+	c.nonce = t.nonce
+	if !reflect.DeepEqual(c, t) {
+		test.Error("copy should only differ by its nonce")
+	}
+
+	// the copy is deep
+	c = t.With(WithNewData([]byte("new")))
+	c.writeKeys[0][0]++
+	c.readData[0][0]++
+	if !bytes.Equal(t.Serialize(), original) {
+		test.Error("original transaction should be untouched")
+	}
+	if !bytes.Equal(c.newData[0], []byte("new")) || len(c.newData) != 1 {
+		test.Error("copy should write the new data")
+	}
+}
+
+func TestVerifyTransactionAgainstState(test *testing.T) {
+	t, err := NewTransaction(generateTransactionInput())
+	if err != nil {
+		test.Error(err)
+	}
+
+	// commit the transaction's old data and read data in a state tree
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	proofs, err := generateTransactionStateProofs(t, stateTree, RawEncoding)
+	if err != nil {
+		test.Error(err)
+	}
+
+	// verify the transaction against the state root
+	ret := VerifyTransactionAgainstState(*t, stateTree.Root(), proofs)
+	if ret != true {
+		test.Error("transaction does not check against state")
+	}
+
+	// verify the transaction against a state tree with another hash function and state encoding
+	opts := []BlockOption{WithHashAlgorithm(HashSHA256), WithStateEncoding(RLPEncoding)}
+	otherTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha256.New())
+	otherProofs, err := generateTransactionStateProofs(t, otherTree, RLPEncoding)
+	if err != nil {
+		test.Error(err)
+	}
+	if !VerifyTransactionAgainstState(*t, otherTree.Root(), otherProofs, opts...) {
+		test.Error("transaction does not check against state with the options of its block")
+	}
+	if VerifyTransactionAgainstState(*t, otherTree.Root(), otherProofs) {
+		test.Error("transaction should not check against state with the default options")
+	}
+
+	// verify a transaction with corrupted old data
+	t.oldData[0] = []byte("random")
+	ret = VerifyTransactionAgainstState(*t, stateTree.Root(), proofs)
+	if ret != false {
+		test.Error("corrupted transaction should not check against state")
+	}
+}
+
+func TestStateValue(test *testing.T) {
+	keys := [][]byte{[]byte("key1"), []byte("key2")}
+
+	// write the same bytes to the state, split differently across the keys: the second part of the first split is
+	// empty, which would be stored as no value at all without the length prefix
+	t1, err := NewTransaction(keys, [][]byte{[]byte("ab"), {}}, [][]byte{{}, {}}, keys, [][]byte{{}, {}}, []byte{})
+	if err != nil {
+		test.Error(err)
+	}
+	t2, err := NewTransaction(keys[:1], [][]byte{[]byte("ab")}, [][]byte{{}}, keys[:1], [][]byte{{}}, []byte{})
+	if err != nil {
+		test.Error(err)
+	}
+
+	b1, err := NewBlock([]Transaction{*t1}, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil {
+		test.Error(err)
+	}
+	b2, err := NewBlock([]Transaction{*t2}, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil {
+		test.Error(err)
+	}
+	if bytes.Equal(b1.stateRoot, b2.stateRoot) {
+		test.Error("differently split values should produce different state roots")
+	}
+}
+
+func TestBlock(test *testing.T) {
+	// create bad block (corrupted transactions)
+	_, err :=  NewBlock(generateCorruptedBlockInput())
+	if err == nil {
+		test.Error("should return an error")
+	}
+
+	// create block with a nil state tree
+	goodTransaction, stateTree := generateBlockInput(1000000)
+	_, err = NewBlock(goodTransaction, nil)
+	if err != ErrNilStateTree {
+		test.Error("should return ErrNilStateTree")
+	}
+
+	// create good block
+	goodBlock, err :=  NewBlock(goodTransaction, stateTree)
+	if err != nil {
+		test.Error(err)
+	}
+
+	// check block with a nil state tree
+	_, err = goodBlock.CheckBlock(nil)
+	if err != ErrNilStateTree {
+		test.Error("should return ErrNilStateTree")
+	}
+
+	// check good block
+	_, err = goodBlock.CheckBlock(stateTree)
+	if err != nil {
+		test.Error(err)
+	}
+
+	// check a bad block (corrupted transactions)
+	badBlock := generateBlockWithCorruptedTransactions()
+	_, err = badBlock.CheckBlock(stateTree)
+	if err == nil {
+		test.Error("should return an error")
+	}
+
+	// check bad block (corrupted intermediate state)
+	badBlock = corruptBlockInterStates(goodBlock)
+	goodFp, err := badBlock.CheckBlock(stateTree)
+	if err != nil {
+		test.Error(err)
+	} else if goodFp == nil {
+		test.Error("should return a fraud proof")
+	}
+
+	// verify fraud proof of bad block
+	ret := badBlock.VerifyFraudProof(*goodFp)
+	if ret != true {
+		test.Error("fraud proof does not check")
+	}
+
+	// verify corrupted fraud proof (corrupted chunks proof)
+	corruptedFp := corruptFraudproofChunks(goodFp)
+	ret = badBlock.VerifyFraudProof(*corruptedFp)
+	if ret != false {
+		test.Error("invalid fraud proof should not check")
+	}
+
+	// verify corrupted fraud proof (corrupted state proof)
+	corruptedFp = corruptFraudproofState(goodFp)
+	ret = badBlock.VerifyFraudProof(*corruptedFp)
+	if ret != false {
+		test.Error("invalid fraud proof should not check")
+	}
+}
+
+func TestPhantomWrites(test *testing.T) {
+	// create a transaction with a no-op write (new data equals old data)
+	writeKeys, newData, _, readKeys, readData, arbitrary := generateTransactionInput()
+	t, err := NewTransaction(writeKeys, newData, newData, readKeys, readData, arbitrary)
+	if err != nil {
+		test.Error(err)
+	}
+
+	// the no-op write is accepted by default
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	_, err = NewBlock([]Transaction{*t}, stateTree)
+	if err != nil {
+		test.Error(err)
+	}
+
+	// the no-op write is rejected when configured
+	_, err = NewBlock([]Transaction{*t}, stateTree, WithRejectPhantomWrites(true))
+	if err != ErrPhantomWrite {
+		test.Error("should return ErrPhantomWrite")
+	}
+}
+
+func TestSharedHasher(test *testing.T) {
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, err := NewBlock(goodTransaction, stateTree)
+	if err != nil {
+		test.Error(err)
+	}
+
+	// a reused (dirty) hasher produces the same data root as a fresh one
+	hasher := sha512.New512_256()
+	hasher.Write([]byte("random"))
+	hasher.Reset()
+	dataRoot, err := fillDataTree(goodBlock.transactions, goodBlock.interStateRoots, merkletree.New(hasher),
+		goodBlock.chunkSize)
+	if err != nil {
+		test.Error(err)
+	} else if !bytes.Equal(dataRoot, goodBlock.dataRoot) {
+		test.Error("data root should not depend on the hasher instance")
+	}
+
+	// proofs generated twice are identical and verify
+	badBlock := corruptBlockInterStates(goodBlock)
+	fp1, err := badBlock.CheckBlock(stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fp2, err := badBlock.CheckBlock(stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	for i := 0; i < len(fp1.proofChunks); i++ {
+		for j := 0; j < len(fp1.proofChunks[i]); j++ {
+			if !bytes.Equal(fp1.proofChunks[i][j], fp2.proofChunks[i][j]) {
+				test.Error("fraud proofs should be identical")
+			}
+		}
+	}
+	if !badBlock.VerifyFraudProof(*fp1) {
+		test.Error("fraud proof does not check")
+	}
+}
+
+func BenchmarkCheckBlock(b *testing.B) {
+	goodTransaction, stateTree := generateBlockInput(1000000)
+	goodBlock, err := NewBlock(goodTransaction, stateTree)
+	if err != nil {
+		b.Fatal(err)
+	}
+	badBlock := corruptBlockInterStates(goodBlock)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := badBlock.CheckBlock(stateTree)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVerifyFraudProof(b *testing.B) {
+	goodTransaction, stateTree := generateBlockInput(1000000)
+	goodBlock, err := NewBlock(goodTransaction, stateTree)
+	if err != nil {
+		b.Fatal(err)
+	}
+	badBlock := corruptBlockInterStates(goodBlock)
+	fp, err := badBlock.CheckBlock(stateTree)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		badBlock.VerifyFraudProof(*fp)
+	}
+}
+
+func TestBlockHeader(test *testing.T) {
+	// link the headers of two blocks, and append them
+	blockchain := NewBlockchain()
+	transactions, stateTree := generateBlockInput(10000)
+	first, _ := NewBlock(transactions, stateTree, WithHeight(1))
+	transactions, stateTree = generateBlockInput(10000)
+	second, _ := NewBlock(transactions, stateTree, WithParent(first.Header()))
+	blockchain.Append(first)
+	if _, _, err := blockchain.Append(second); err != nil {
+		test.Error(err)
+	}
+
+	header := second.Header()
+	if header.height != 2 || !bytes.Equal(header.parentHash, first.Hash()) {
+		test.Error("header not linked to the previous block")
+	}
+
+	// serialize and deserialize
+	h, err := DeserializeBlockHeader(header.Serialize())
+	if err != nil {
+		test.Error(err)
+	} else if !bytes.Equal(h.Hash(), second.Hash()) {
+		test.Error("header not serialized and deserialized correctly")
+	}
+
+	// deserialize a truncated header
+	_, err = DeserializeBlockHeader(header.Serialize()[:10])
+	if err == nil {
+		test.Error("should return an error")
+	}
+}
+
+func TestNewBlockWithValidator(test *testing.T) {
+	blacklisted := []byte("blacklisted")
+	errBlacklisted := errors.New("transaction writes to a blacklisted key")
+	validate := func(t Transaction, state StateReader) error {
+		for _, key := range t.writeKeys {
+			if bytes.Equal(key, blacklisted) {
+				return errBlacklisted
+			}
+		}
+		return nil
+	}
+
+	// transactions writing to allowed keys are accepted
+	goodTransaction, stateTree := generateBlockInput(10000)
+	_, err := NewBlockWithValidator(goodTransaction, stateTree, validate)
+	if err != nil {
+		test.Error(err)
+	}
+
+	// a transaction writing to a blacklisted key is rejected
+	badTransaction, stateTree := generateBlockInput(10000)
+	badTransaction[3].writeKeys = [][]byte{blacklisted}
+	root := append([]byte{}, stateTree.Root()...)
+	_, err = NewBlockWithValidator(badTransaction, stateTree, validate)
+	if err != errBlacklisted {
+		test.Error("should reject the transaction writing to a blacklisted key")
+	}
+	if !bytes.Equal(stateTree.Root(), root) {
+		test.Error("state tree should not be modified")
+	}
+
+	// the state is decoded with the state encoding of the block
+	stateTree = smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	stateTree.Update([]byte("key"), RLPEncoding.encode([]byte("data")))
+	var read []byte
+	_, err = NewBlockWithValidator(goodTransaction, stateTree, func(t Transaction, state StateReader) error {
+		read, err = state.Get([]byte("key"))
+		return err
+	}, WithStateEncoding(RLPEncoding))
+	if err != nil {
+		test.Fatal(err)
+	}
+	if !bytes.Equal(read, []byte("data")) {
+		test.Error("validator should read the decoded state")
+	}
+}
+
+func TestVerifyFraudProofChain(test *testing.T) {
+	// two consecutive blocks with corrupted intermediate state roots
+	firstTransactions, stateTree := generateBlockInput(10000)
+	firstBlock, _ := NewBlock(firstTransactions, stateTree)
+	secondTransactions, _ := generateBlockInput(10000)
+	secondBlock, _ := NewBlock(secondTransactions, stateTree)
+	first := corruptBlockInterStates(firstBlock)
+	second := corruptBlockInterStates(secondBlock)
+	second.parentHash = first.Hash()
+
+	stateTree = smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	firstFp, err := first.CheckBlock(stateTree)
+	if err != nil || firstFp == nil {
+		test.Fatal("should return a fraud proof for the first block")
+	}
+	stateTree = smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	_, err = NewBlock(firstTransactions, stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	secondFp, err := second.CheckBlock(stateTree)
+	if err != nil || secondFp == nil {
+		test.Fatal("should return a fraud proof for the second block")
+	}
+
+	proofs := []FraudProof{*firstFp, *secondFp}
+	if !VerifyFraudProofChain(proofs, []BlockHeader{first.Header(), second.Header()}) {
+		test.Error("chained fraud proofs should verify")
+	}
+	if VerifyFraudProofChain(proofs, []BlockHeader{second.Header(), first.Header()}) {
+		test.Error("headers in the wrong order should not verify")
+	}
+	second.parentHash = nil
+	if VerifyFraudProofChain(proofs, []BlockHeader{first.Header(), second.Header()}) {
+		test.Error("unlinked headers should not verify")
+	}
+	if VerifyFraudProofChain(proofs[:1], []BlockHeader{first.Header(), second.Header()}) {
+		test.Error("missing fraud proofs should not verify")
+	}
+}
+
+func TestStaleOldDataFraudProof(test *testing.T) {
+	// a chain of writes to the same key, each declaring the data written by the previous one
+	key := []byte("key")
+	values := [][]byte{{}, []byte("v1"), []byte("v2"), []byte("v3")}
+	var t []Transaction
+	for i := 1; i < len(values); i++ {
+		tmp, err := NewTransaction([][]byte{key}, [][]byte{values[i]}, [][]byte{values[i-1]}, [][]byte{key},
+			[][]byte{{}}, []byte{})
+		if err != nil {
+			test.Fatal(err)
+		}
+		t = append(t, *tmp)
+	}
+	goodBlock, err := NewBlock(t, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()),
+		WithCheckOldData(true))
+	if err != nil {
+		test.Fatal(err)
+	}
+	fp, err := goodBlock.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil || fp != nil {
+		test.Error("consistent oldData should not generate a fraud proof")
+	}
+
+	// a transaction ignoring the write of a previous transaction of the block (in the same group, or in a previous one)
+	for _, stale := range []int{1, 2} {
+		badTransactions := append([]Transaction{}, t...)
+		badTransactions[stale].oldData = [][]byte{{}}
+		_, err := NewBlock(badTransactions, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()),
+			WithCheckOldData(true))
+		if err != ErrStaleOldData {
+			test.Error("should return ErrStaleOldData")
+		}
+
+		badBlock, err := NewBlock(badTransactions, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+		if err != nil {
+			test.Fatal(err)
+		}
+		badBlock.checkOldData = true
+		fp, err := badBlock.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+		if err != nil {
+			test.Fatal(err)
+		}
+		if fp == nil || fp.Kind() != KindStaleOldData || fp.txIndex != uint64(stale) {
+			test.Fatal("should generate a stale oldData fraud proof")
+		}
+		deserialized, err := DeserializeFraudProof(fp.Serialize())
+		if err != nil {
+			test.Fatal(err)
+		}
+		if !badBlock.VerifyFraudProof(*fp) || !badBlock.VerifyFraudProof(*deserialized) {
+			test.Error("fraud proof should check")
+		}
+		if goodBlock.VerifyFraudProof(*fp) {
+			test.Error("fraud proof should not check against another block")
+		}
+		hash := badBlock.Hash()
+		badBlock.checkOldData = false
+		if badBlock.VerifyFraudProof(*fp) {
+			test.Error("fraud proof should not check against a block not checking oldData")
+		}
+		if bytes.Equal(badBlock.Hash(), hash) {
+			test.Error("header should commit to whether the block checks oldData")
+		}
+	}
+}
+
+func TestWitness(test *testing.T) {
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, _ := NewBlock(goodTransaction, stateTree)
+	badBlock := corruptBlockInterStates(goodBlock)
+	fp, err := badBlock.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil || fp == nil {
+		test.Fatal("should return a fraud proof")
+	}
+	witness, err := fp.Witness()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(witness)%sha512.Size256 != 0 {
+		test.Error("witness should be made of words of the width of the hash function")
+	}
+	if again, _ := fp.Witness(); !bytes.Equal(witness, again) {
+		test.Error("witness should be deterministic")
+	}
+
+	// a fraud proof of the same shape has a witness of the same length
+	other := fp.Copy()
+	for i := 0; i < len(other.chunks); i++ {
+		other.chunks[i][len(other.chunks[i])-1] ^= 0xff
+	}
+	otherWitness, err := other.Witness()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(otherWitness) != len(witness) || bytes.Equal(otherWitness, witness) {
+		test.Error("witness length should only depend on the shape of the fraud proof")
+	}
+
+	// a word more of data takes one more word in the witness
+	other = fp.Copy()
+	other.oldData[0] = append(other.oldData[0], make([]byte, sha512.Size256)...)
+	otherWitness, _ = other.Witness()
+	if len(otherWitness) != len(witness)+sha512.Size256 {
+		test.Error("witness should grow by one word per word of data")
+	}
+
+	// invalid fraud proofs have no witness
+	other = fp.Copy()
+	other.chunks = nil
+	if _, err := other.Witness(); err == nil {
+		test.Error("invalid fraud proof should have no witness")
+	}
+}
+
+func TestSparseBlock(test *testing.T) {
+	t, _ := generateBlockInput(10000)
+	for _, k := range []int{0, 3} {
+		goodBlock, _ := NewBlock(t, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+		badBlock, _ := NewBlock(t, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+		badBlock = corruptBlockInterState(badBlock, k)
+
+		// reveal only the three transactions following the intermediate state root preceding the corrupted one
+		from := k * Step
+		for _, b := range []*Block{goodBlock, badBlock} {
+			sb, err := b.Sparse(from, from+3)
+			if err != nil {
+				test.Fatal(err)
+			}
+			stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+			if _, _, err := fillStateTree(t[:from], stateTree, RawEncoding); err != nil {
+				test.Fatal(err)
+			}
+			fp, err := sb.CheckBlock(stateTree)
+			if err != nil {
+				test.Fatal(err)
+			}
+			if b == goodBlock {
+				if fp != nil {
+					test.Error("good sparse block should not generate a fraud proof")
+				}
+				continue
+			}
+			if fp == nil || fp.Kind() != KindInvalidInterStateRoot || fp.txIndex != uint64(from+Step-1) {
+				test.Fatal("bad sparse block should generate a fraud proof of its intermediate state root")
+			}
+			deserialized, err := DeserializeFraudProof(fp.Serialize())
+			if err != nil {
+				test.Fatal(err)
+			}
+			if !badBlock.VerifyFraudProof(*fp) || !badBlock.VerifyFraudProof(*deserialized) {
+				test.Error("fraud proof should check")
+			}
+			if goodBlock.VerifyFraudProof(*fp) {
+				test.Error("fraud proof should not check against the good block")
+			}
+		}
+	}
+
+	// revealed transactions that are not in the block are rejected
+	goodBlock, _ := NewBlock(t, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	sb, err := goodBlock.Sparse(2*Step, 2*Step+3)
+	if err != nil {
+		test.Fatal(err)
+	}
+	sb.transactions[1].newData = append([][]byte{[]byte("forged")}, sb.transactions[1].newData[1:]...)
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	fillStateTree(t[:2*Step], stateTree, RawEncoding)
+	if _, err := sb.CheckBlock(stateTree); err != ErrInvalidDataRoot {
+		test.Error("should return ErrInvalidDataRoot")
+	}
+	if _, err := goodBlock.Sparse(1, 3); err == nil {
+		test.Error("range should be aligned on intermediate state roots")
+	}
+}
+
+func TestFraudProofDiff(test *testing.T) {
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, _ := NewBlock(goodTransaction, stateTree)
+	badBlock := corruptBlockInterStates(goodBlock)
+	fp, err := badBlock.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil || fp == nil {
+		test.Fatal("should return a fraud proof")
+	}
+	if diff := FraudProofDiff(fp, fp.Copy()); len(diff) != 0 {
+		test.Error("equal fraud proofs should not differ")
+	}
+
+	diff := FraudProofDiff(fp, corruptFraudproofChunks(fp))
+	if len(diff) == 0 {
+		test.Fatal("corrupted fraud proof should differ")
+	}
+	for _, line := range diff {
+		if !strings.HasPrefix(line, "proofChunks[0]") && !strings.HasPrefix(line, "len(proofChunks[0])") {
+			test.Errorf("unexpected difference %q", line)
+		}
+	}
+
+	other := fp.Copy()
+	other.chunksIndexes[0] += 2
+	diff = FraudProofDiff(fp, other)
+	expected := fmt.Sprintf("chunksIndexes[0]: %d != %d", fp.chunksIndexes[0], other.chunksIndexes[0])
+	if len(diff) != 1 || diff[0] != expected {
+		test.Errorf("diff should be %q, got %q", expected, diff)
+	}
+}
+
+func TestStateEncoding(test *testing.T) {
+	for _, data := range [][]byte{{}, {0x7f}, {0x80}, []byte("dog"), make([]byte, 55), make([]byte, 56),
+		make([]byte, 1024)} {
+		if decoded := rlpData(rlpString(data)); decoded == nil || !bytes.Equal(decoded, data) {
+			test.Errorf("RLP encoding of %d bytes does not round trip", len(data))
+		}
+	}
+	if !bytes.Equal(rlpString([]byte("dog")), []byte{0x83, 'd', 'o', 'g'}) ||
+		!bytes.Equal(rlpString(make([]byte, 56))[:2], []byte{0xb8, 56}) {
+		test.Error("RLP encoding does not match the specification")
+	}
+
+	goodTransaction, _ := generateBlockInput(10000)
+	var fps []*FraudProof
+	var verifiers []*Block
+	for _, e := range []StateEncoding{RawEncoding, RLPEncoding} {
+		goodBlock, err := NewBlock(goodTransaction, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()),
+			WithStateEncoding(e))
+		if err != nil {
+			test.Fatal(err)
+		}
+		badBlock := corruptBlockInterStates(goodBlock)
+		fp, err := badBlock.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+		if err != nil || fp == nil {
+			test.Fatal("should return a fraud proof")
+		}
+		if !badBlock.VerifyFraudProof(*fp) || !fp.VerifyState(badBlock.stateRoot) {
+			test.Errorf("fraud proof with state encoding %d should check", e)
+		}
+		fps = append(fps, fp)
+		verifiers = append(verifiers, &Block{dataRoot: badBlock.dataRoot, stateRoot: badBlock.stateRoot,
+			chunkSize: chunksSize, newHash: sha512.New512_256})
+	}
+	if bytes.Equal(verifiers[0].stateRoot, verifiers[1].stateRoot) {
+		test.Error("state encodings should lead to different state roots")
+	}
+
+	// a raw-encoded fraud proof does not check under the RLP encoding
+	verifiers[0].stateEncoding = RLPEncoding
+	if verifiers[0].VerifyFraudProof(*fps[0]) {
+		test.Error("raw-encoded fraud proof should not check under the RLP encoding")
+	}
+	verifiers[1].stateEncoding = RLPEncoding
+	if !verifiers[1].VerifyFraudProof(*fps[1]) {
+		test.Error("RLP-encoded fraud proof should check under the RLP encoding")
+	}
+
+	// a blockchain of RLP-encoded blocks reads the data committed in its state
+	blockchain := NewBlockchain(WithChainStateEncoding(RLPEncoding))
+	b, err := NewBlock(goodTransaction, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()),
+		WithStateEncoding(RLPEncoding), extending(blockchain))
+	if err != nil {
+		test.Fatal(err)
+	}
+	if _, fp, err := blockchain.Append(b); err != nil || fp != nil {
+		test.Fatal("block should be appended")
+	}
+	last := goodTransaction[len(goodTransaction)-1]
+	if data, err := blockchain.Get(last.writeKeys[0]); err != nil || !bytes.Equal(data, last.newData[0]) {
+		test.Error("blockchain should decode the data committed in its state")
+	}
+}
+
+func TestReadFromPreBlock(test *testing.T) {
+	key := []byte("key")
+	preStateTree := func() *smt.SparseMerkleTree {
+		stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+		stateTree.Update(key, stateValue([]byte("v0")))
+		return stateTree
+	}
+	write, _ := NewTransaction([][]byte{key}, [][]byte{[]byte("v1")}, [][]byte{[]byte("v0")}, [][]byte{key},
+		[][]byte{[]byte("v0")}, []byte{})
+	padding, _ := NewTransaction([][]byte{[]byte("other")}, [][]byte{[]byte("x")}, [][]byte{{}},
+		[][]byte{[]byte("other")}, [][]byte{{}}, []byte{})
+
+	for _, c := range []struct {
+		readFromPreBlock bool
+		readData         string
+		valid            bool
+	}{{false, "v1", true}, {true, "v0", true}, {false, "v0", false}, {true, "v1", false}} {
+		read, err := NewTransaction([][]byte{[]byte("read")}, [][]byte{[]byte("r")}, [][]byte{{}}, [][]byte{key},
+			[][]byte{[]byte(c.readData)}, []byte{}, WithReadFromPreBlock(c.readFromPreBlock))
+		if err != nil {
+			test.Fatal(err)
+		}
+		deserialized, err := Deserialize(read.Serialize())
+		if err != nil || deserialized.readFromPreBlock != c.readFromPreBlock {
+			test.Fatal("transaction flag should survive serialization")
+		}
+
+		// the read transaction in the group of the write, and in the following group
+		for _, t := range [][]Transaction{{*write, *read}, {*write, *padding, *read}} {
+			_, err := NewBlock(t, preStateTree(), WithCheckReads(true))
+			if c.valid != (err == nil) || (!c.valid && err != ErrInvalidRead) {
+				test.Errorf("unexpected error %v for a read of %q from the pre-block state (%v)", err, c.readData,
+					c.readFromPreBlock)
+			}
+			if c.valid {
+				continue
+			}
+
+			b, err := NewBlock(t, preStateTree())
+			if err != nil {
+				test.Fatal(err)
+			}
+			b.checkReads = true
+			fp, err := b.CheckBlock(preStateTree())
+			if err != nil {
+				test.Fatal(err)
+			}
+			if fp == nil || fp.Kind() != KindInvalidRead || fp.txIndex != uint64(len(t)-1) {
+				test.Fatal("should generate a fraud proof of an invalid read")
+			}
+			deserialized, err := DeserializeFraudProof(fp.Serialize())
+			if err != nil {
+				test.Fatal(err)
+			}
+			if !b.VerifyFraudProof(*fp) || !b.VerifyFraudProof(*deserialized) {
+				test.Error("fraud proof should check")
+			}
+			hash := b.Hash()
+			b.checkReads = false
+			if b.VerifyFraudProof(*fp) {
+				test.Error("fraud proof should not check against a block not checking reads")
+			}
+			if bytes.Equal(b.Hash(), hash) {
+				test.Error("header should commit to whether the block checks reads")
+			}
+		}
+	}
+}
+
+func TestBlindWriteFraudProof(test *testing.T) {
+	balance, other := []byte("balance"), []byte("other")
+	preStateTree := func() *smt.SparseMerkleTree {
+		stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+		stateTree.Update(balance, stateValue([]byte("10")))
+		return stateTree
+	}
+	newTransaction := func(key, data, read []byte) Transaction {
+		tx, err := NewTransaction([][]byte{key}, [][]byte{data}, [][]byte{{}}, [][]byte{read}, [][]byte{{}}, []byte{})
+		if err != nil {
+			test.Fatal(err)
+		}
+		return *tx
+	}
+	readWrite := newTransaction(balance, []byte("0"), balance)        // overwrite of an existing key declaring its read
+	blindWrite := newTransaction(balance, []byte("0"), other)         // blind overwrite of an existing key
+	create := newTransaction([]byte("new"), []byte("0"), other)       // creation of a key
+	blindCreated := newTransaction([]byte("new"), []byte("1"), other) // blind overwrite of a key created by the block
+	padding := newTransaction([]byte("padding"), []byte("0"), other)
+
+	for _, c := range []struct {
+		t       []Transaction
+		invalid int
+	}{
+		{[]Transaction{readWrite, create}, -1},
+		{[]Transaction{create, blindWrite}, 1},
+		{[]Transaction{create, blindCreated}, 1},
+		{[]Transaction{create, padding, blindCreated}, 2},
+	} {
+		_, err := NewBlock(c.t, preStateTree(), WithStrictWrites(true))
+		if (c.invalid < 0) != (err == nil) || (c.invalid >= 0 && err != ErrBlindWrite) {
+			test.Errorf("unexpected error %v", err)
+		}
+
+		b, err := NewBlock(c.t, preStateTree())
+		if err != nil {
+			test.Fatal(err)
+		}
+		b.strictWrites = true
+		fp, err := b.CheckBlock(preStateTree())
+		if err != nil {
+			test.Fatal(err)
+		}
+		if c.invalid < 0 {
+			if fp != nil {
+				test.Error("declared writes should not generate a fraud proof")
+			}
+			continue
+		}
+		if fp == nil || fp.Kind() != KindBlindWrite || fp.txIndex != uint64(c.invalid) {
+			test.Fatal("should generate a fraud proof of a blind write")
+		}
+		deserialized, err := DeserializeFraudProof(fp.Serialize())
+		if err != nil {
+			test.Fatal(err)
+		}
+		if !b.VerifyFraudProof(*fp) || !b.VerifyFraudProof(*deserialized) {
+			test.Error("fraud proof should check")
+		}
+		hash := b.Hash()
+		b.strictWrites = false
+		if b.VerifyFraudProof(*fp) {
+			test.Error("fraud proof should not check against a block allowing blind writes")
+		}
+		if bytes.Equal(b.Hash(), hash) {
+			test.Error("header should commit to whether the block allows blind writes")
+		}
+	}
+}
+
+func TestTwoNodes(test *testing.T) {
+	// the first node builds a good block and a block with a corrupted intermediate state root on top of it
+	firstTransactions, stateTree := generateBlockInput(10000)
+	goodBlock, err := NewBlock(firstTransactions, stateTree, WithHeight(1))
+	if err != nil {
+		test.Fatal(err)
+	}
+	secondTransactions, _ := generateBlockInput(10000)
+	for i := 0; i < len(secondTransactions); i++ {
+		secondTransactions[i].newData[0] = []byte("second")
+	}
+	nextBlock, err := NewBlock(secondTransactions, stateTree, WithParent(goodBlock.Header()))
+	if err != nil {
+		test.Fatal(err)
+	}
+	badBlock := corruptBlockInterStates(nextBlock)
+	sent := map[string]*Block{string(goodBlock.Hash()): goodBlock, string(badBlock.Hash()): badBlock}
+
+	blocksReader, blocksWriter := io.Pipe()
+	proofsReader, proofsWriter := io.Pipe()
+
+	// the first node sends its blocks
+	go func() {
+		for _, b := range []*Block{goodBlock, badBlock} {
+			err := writeFrame(blocksWriter, b.Serialize())
+			if err != nil {
+				blocksWriter.CloseWithError(err)
+				return
+			}
+		}
+		blocksWriter.Close()
+	}()
+
+	// the second node checks the blocks it receives, and broadcasts fraud proofs back
+	go func() {
+		blockchain := NewBlockchain()
+		for {
+			buff, err := readFrame(blocksReader)
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				proofsWriter.CloseWithError(err)
+				return
+			}
+			b, err := DeserializeBlock(buff)
+			if err != nil {
+				proofsWriter.CloseWithError(err)
+				return
+			}
+			hash := b.Hash()
+			_, fp, err := blockchain.Append(b)
+			if err != nil {
+				proofsWriter.CloseWithError(err)
+				return
+			}
+			if fp != nil {
+				err = writeFrame(proofsWriter, append(hash, fp.Serialize()...))
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	// the first node verifies the fraud proofs it receives
+	var proven [][]byte
+	for {
+		buff, err := readFrame(proofsReader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			test.Fatal(err)
+		}
+		if len(buff) < sha512.Size256 {
+			test.Fatal("fraud proof message is too short")
+		}
+		b, ok := sent[string(buff[:sha512.Size256])]
+		if !ok {
+			test.Fatal("fraud proof for an unknown block")
+		}
+		fp, err := DeserializeFraudProof(buff[sha512.Size256:])
+		if err != nil {
+			test.Fatal(err)
+		}
+		if !b.VerifyFraudProof(*fp) {
+			test.Error("received fraud proof does not check")
+		}
+		proven = append(proven, buff[:sha512.Size256])
+	}
+	if len(proven) != 1 || !bytes.Equal(proven[0], badBlock.Hash()) {
+		test.Error("only the bad block should be proven fraudulent")
+	}
+}
+
+func TestFraudProofChunkSize(test *testing.T) {
+	for _, chunkSize := range []int{64, chunksSize} {
+		goodTransaction, stateTree := generateBlockInput(10000)
+		goodBlock, err := NewBlock(goodTransaction, stateTree, WithChunkSize(chunkSize))
+		if err != nil {
+			test.Fatal(err)
+		}
+		badBlock := corruptBlockInterStates(goodBlock)
+		fp, err := badBlock.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+		if err != nil || fp == nil {
+			test.Fatal("should return a fraud proof")
+		}
+		if fp.chunkSize != uint64(chunkSize) {
+			test.Error("fraud proof should embed its chunk size")
+		}
+
+		// the same verifier (expecting the default chunk size) checks the fraud proof with its embedded chunk size
+		verifier := &Block{dataRoot: badBlock.dataRoot, stateRoot: badBlock.stateRoot, chunkSize: chunksSize,
+			newHash: sha512.New512_256}
+		deserialized, err := DeserializeFraudProof(fp.Serialize())
+		if err != nil {
+			test.Fatal(err)
+		}
+		if !verifier.VerifyFraudProof(*fp) || !verifier.VerifyFraudProof(*deserialized) {
+			test.Errorf("fraud proof with chunk size %d does not check", chunkSize)
+		}
+
+		// a chunk size inconsistent with the chunks is rejected
+		inconsistent := fp.Copy()
+		inconsistent.chunkSize = uint64(chunkSize / 2)
+		if inconsistent.Validate() == nil || verifier.VerifyFraudProof(*inconsistent) {
+			test.Error("fraud proof with an inconsistent chunk size should not check")
+		}
+	}
+}
+
+func TestReSign(test *testing.T) {
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, _ := NewBlock(goodTransaction, stateTree)
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if goodBlock.ReSign(priv) != ErrNotSigned {
+		test.Error("should return ErrNotSigned")
+	}
+	goodBlock.Sign(priv)
+	oldSignature := goodBlock.signature
+
+	// modifying the timestamp makes the signature stale
+	goodBlock.timestamp++
+	if goodBlock.SignedHeader().Verify() {
+		test.Error("stale signature should not check")
+	}
+
+	// re-signing with another key is rejected
+	_, other, _ := ed25519.GenerateKey(nil)
+	if goodBlock.ReSign(other) != ErrProposerMismatch {
+		test.Error("should return ErrProposerMismatch")
+	}
+
+	err = goodBlock.ReSign(priv)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if !goodBlock.SignedHeader().Verify() {
+		test.Error("signature should check after re-signing")
+	}
+	stale := SignedHeader{goodBlock.Header(), goodBlock.proposer, oldSignature}
+	if stale.Verify() {
+		test.Error("old signature should not check")
+	}
+}
+
+func TestNumLeaves(test *testing.T) {
+	for _, chunkSize := range []int{64, chunksSize} {
+		goodTransaction, stateTree := generateBlockInput(10000)
+		goodBlock, err := NewBlock(goodTransaction, stateTree, WithChunkSize(chunkSize))
+		if err != nil {
+			test.Fatal(err)
+		}
+		chunks, _, _ := makeChunks(goodBlock.chunkSize, goodBlock.transactions, goodBlock.interStateRoots)
+		if goodBlock.NumLeaves() != uint64(len(chunks)) {
+			test.Error("number of leaves should match the number of chunks")
+		}
+
+		badBlock := corruptBlockInterStates(goodBlock)
+		fp, err := badBlock.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+		if err != nil || fp == nil {
+			test.Fatal("should return a fraud proof")
+		}
+		if badBlock.NumLeaves() != fp.numOfLeaves {
+			test.Error("number of leaves should match the fraud proof")
+		}
+	}
+	empty, _ := NewBlock(nil, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if empty.NumLeaves() != 0 {
+		test.Error("empty block should have no leaves")
+	}
+}
+
+func TestLeafKind(test *testing.T) {
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, err := NewBlock(goodTransaction, stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	stateTree = smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	_, err = goodBlock.CheckBlock(stateTree)
+	if err != nil {
+		test.Error(err)
+	}
+
+	// a block whose data root commits to a state root masquerading as a transaction is rejected
+	badBlock := retagFirstStateRoot(goodBlock, LeafTransaction)
+	stateTree = smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	_, err = badBlock.CheckBlock(stateTree)
+	if err != ErrInvalidDataRoot {
+		test.Error("should return ErrInvalidDataRoot")
+	}
+
+	// a transaction tagged as a state root is not extracted from the chunks of a fraud proof
+	leaf := append([]byte{0, byte(LeafStateRoot)}, goodTransaction[0].Serialize()...)
+	fp := &FraudProof{chunks: [][]byte{leaf}}
+	if _, err := fp.transactions(); err != ErrInvalidLeafKind {
+		test.Error("should return ErrInvalidLeafKind")
+	}
+	leaf[1] = byte(LeafTransaction)
+	if _, err := fp.transactions(); err != nil {
+		test.Error(err)
+	}
+}
+
+func TestRewind(test *testing.T) {
+	// append five blocks, each using the next nonce of the sender
+	sender := []byte("alice")
+	blockchain := NewBlockchain()
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	var blocks []*Block
+	for i := uint64(0); i < 5; i++ {
+		link := WithHeight(1)
+		if i > 0 {
+			link = WithParent(blocks[i-1].Header())
+		}
+		b, err := NewBlock(generateNonceBlockInput(sender, []uint64{i}), stateTree, link)
+		if err != nil {
+			test.Fatal(err)
+		}
+		_, fp, err := blockchain.Append(b)
+		if err != nil || fp != nil {
+			test.Fatal("block should be appended")
+		}
+		blocks = append(blocks, b)
+	}
+
+	// rewind to height 2
+	if blockchain.Rewind(6) == nil {
+		test.Error("should not rewind above the last block")
+	}
+	err := blockchain.Rewind(2)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if blockchain.Len() != 2 {
+		test.Error("blockchain should have two blocks")
+	}
+	if !bytes.Equal(blockchain.StateRoot(), blocks[1].stateRoot) {
+		test.Error("state should be rewound to height 2")
+	}
+
+	// append a different block 3, using the nonce following block 2
+	t, err := NewTransaction([][]byte{[]byte("key")}, [][]byte{[]byte("other")}, [][]byte{{}}, [][]byte{[]byte("key")},
+		[][]byte{{}}, []byte{}, WithSender(sender), WithNonce(2))
+	if err != nil {
+		test.Fatal(err)
+	}
+	stateTree.SetRoot(blocks[1].stateRoot)
+	b, err := NewBlock([]Transaction{*t}, stateTree, WithParent(blocks[1].Header()))
+	if err != nil {
+		test.Fatal(err)
+	}
+	height, fp, err := blockchain.Append(b)
+	if err != nil {
+		test.Error(err)
+	} else if fp != nil || height != 3 {
+		test.Error("block should be appended at height 3")
+	}
+	if parent, _ := blockchain.Block(2); parent != blocks[1] || !bytes.Equal(b.parentHash, blocks[1].Hash()) {
+		test.Error("block 3 should link to block 2")
+	}
+
+	// rewind to the empty blockchain
+	err = blockchain.Rewind(0)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if blockchain.Len() != 0 || !bytes.Equal(blockchain.StateRoot(), blocks[0].preStateRoot) {
+		test.Error("blockchain should be empty")
+	}
+}
+
+func TestLargeIndexes(test *testing.T) {
+	badBlock, err := forgeBlock(generateNonceBlockInput([]byte("alice"), []uint64{0, 1, 1, 2}))
+	if err != nil {
+		test.Fatal(err)
+	}
+	fp, err := badBlock.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil || fp == nil {
+		test.Fatal("should return a fraud proof")
+	}
+
+	// indexes close to the largest uint64 are rejected without wrapping around
+	large := fp.Copy()
+	large.txIndex = math.MaxUint64
+	large.numOfLeaves = math.MaxUint64
+	for i := 0; i < len(large.chunksIndexes); i++ {
+		large.chunksIndexes[i] = math.MaxUint64 - uint64(len(large.chunksIndexes)-i)
+	}
+	if badBlock.VerifyFraudProof(*large) {
+		test.Error("fraud proof with large indexes should not check")
+	}
+	large = fp.Copy()
+	large.txIndex = math.MaxUint64
+	if t, _ := large.transactions(); len(t) > Step {
+		test.Error("should not extract more transactions than an intermediate state root covers")
+	}
+
+	// a chunk position past the data of the chunks is rejected
+	large = fp.Copy()
+	large.chunks = [][]byte{{0xff, 0x00}}
+	if _, _, _, err := large.writes(); err == nil {
+		test.Error("should return an error")
+	}
+
+	// a serialized block with an out of range chunk size is rejected
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, _ := NewBlock(goodTransaction, stateTree)
+	goodBlock.chunkSize = 1 << 40
+	if _, err := DeserializeBlock(goodBlock.Serialize()); err != ErrInvalidChunkSize {
+		test.Error("should return ErrInvalidChunkSize")
+	}
+}
+
+func TestVerifyChunksAndState(test *testing.T) {
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, _ := NewBlock(goodTransaction, stateTree)
+	badBlock := corruptBlockInterStates(goodBlock)
+	fp, err := badBlock.CheckBlock(stateTree)
+	if err != nil || fp == nil {
+		test.Fatal("should return a fraud proof")
+	}
+	if !fp.VerifyChunks(badBlock.dataRoot) || !fp.VerifyState(badBlock.stateRoot) {
+		test.Error("both halves of the fraud proof should check")
+	}
+
+	// corrupting the chunk proofs only breaks the chunk-inclusion half
+	corruptedFp := corruptFraudproofChunks(fp)
+	if corruptedFp.VerifyChunks(badBlock.dataRoot) {
+		test.Error("corrupted chunk proofs should not check")
+	}
+	if !corruptedFp.VerifyState(badBlock.stateRoot) {
+		test.Error("state half should still check")
+	}
+
+	// corrupting the state proofs only breaks the state half
+	corruptedFp = corruptFraudproofState(fp)
+	if !corruptedFp.VerifyChunks(badBlock.dataRoot) || corruptedFp.VerifyState(badBlock.stateRoot) {
+		test.Error("only the state half should fail")
+	}
+}
+
+func TestMempoolPending(test *testing.T) {
+	var t []Transaction
+	for _, sender := range []string{"alice", "bob", "carol"} {
+		t = append(t, generateNonceBlockInput([]byte(sender), []uint64{0, 1, 2})...)
+	}
+
+	// add the transactions to two mempools in different orders
+	first, second := NewMempool(), NewMempool()
+	for i := 0; i < len(t); i++ {
+		first.Add(t[i])
+		second.Add(t[len(t)-1-i])
+	}
+	first.Add(t[0])
+	if first.Len() != len(t) {
+		test.Error("mempool should not hold duplicate transactions")
+	}
+
+	pending := first.Pending()
+	if !reflect.DeepEqual(pending, second.Pending()) {
+		test.Error("identical mempools should return the same pending transactions")
+	}
+	for i := 1; i < len(pending); i++ {
+		if pending[i-1].nonce > pending[i].nonce || (pending[i-1].nonce == pending[i].nonce &&
+			bytes.Compare(pending[i-1].Hash(), pending[i].Hash()) >= 0) {
+			test.Error("pending transactions should be sorted by nonce, then by hash")
+		}
+	}
+
+	// the pending transactions build a valid block
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	_, err := NewBlock(pending, stateTree)
+	if err != nil {
+		test.Error(err)
+	}
+}
+
+func TestAvailabilityChallenge(test *testing.T) {
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, err := NewBlock(goodTransaction, stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	chunks, _, err := makeChunks(goodBlock.chunkSize, goodBlock.transactions, goodBlock.interStateRoots)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// an available block answers the challenge
+	c, err := NewAvailabilityChallenge(uint64(len(chunks)), 10, rand.New(rand.NewSource(1)))
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(c.indexes) != 10 {
+		test.Error("challenge should sample 10 chunks")
+	}
+	r, err := goodBlock.RespondChallenge(c)
+	if err != nil {
+		test.Fatal(err)
+	}
+	err = goodBlock.VerifyChallengeResponse(c, r)
+	if err != nil {
+		test.Error(err)
+	}
+
+	// a withheld chunk fails the challenge
+	withheld := AvailabilityResponse{append([][]byte{}, r.chunks...), r.proofChunks}
+	withheld.chunks[3] = nil
+	if goodBlock.VerifyChallengeResponse(c, withheld) != ErrChunkUnavailable {
+		test.Error("should return ErrChunkUnavailable")
+	}
+	if goodBlock.VerifyChallengeResponse(c, AvailabilityResponse{}) != ErrChunkUnavailable {
+		test.Error("missing response should return ErrChunkUnavailable")
+	}
+}
+
+func TestStateSnapshotHash(test *testing.T) {
+	keys := [][]byte{[]byte("key1"), []byte("key2"), []byte("key3")}
+
+	// build the same state in two trees, writing the keys in a different order
+	first := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	second := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	for i := 0; i < len(keys); i++ {
+		first.Update(keys[i], stateValue(keys[i]))
+		second.Update(keys[len(keys)-1-i], stateValue(keys[len(keys)-1-i]))
+	}
+	if !bytes.Equal(StateSnapshotHash(first), StateSnapshotHash(second)) {
+		test.Error("identical states should have the same snapshot hash")
+	}
+	second.Update(keys[0], stateValue([]byte("random")))
+	if bytes.Equal(StateSnapshotHash(first), StateSnapshotHash(second)) {
+		test.Error("different states should have different snapshot hashes")
+	}
+
+	// the blockchain exposes the root of its state
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, _ := NewBlock(goodTransaction, stateTree, WithHeight(1))
+	bc := NewBlockchain()
+	_, _, err := bc.Append(goodBlock)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if !bytes.Equal(bc.StateRoot(), goodBlock.stateRoot) {
+		test.Error("blockchain should expose the state root of its last block")
+	}
+}
+
+func TestBlockBuilder(test *testing.T) {
+	// a mix of valid transactions, a malformed transaction and a transaction replaying a nonce
+	sender := []byte("alice")
+	t := generateNonceBlockInput(sender, []uint64{0, 1, 1, 2})
+	valid, _ := NewTransaction(generateTransactionInput())
+	malformed := valid.With(WithWriteKeys(valid.writeKeys[1:]...))
+	invalid := []Transaction{*malformed, t[2]}
+	mempool := []Transaction{t[0], *malformed, t[1], t[2], t[3]}
+
+	// invalid transactions abort the build by default
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	bb := NewBlockBuilder(stateTree)
+	for _, tx := range mempool {
+		bb.Add(tx)
+	}
+	_, _, err := bb.Build()
+	if err == nil {
+		test.Error("should return an error")
+	}
+
+	// invalid transactions are skipped when configured
+	bb = NewBlockBuilder(stateTree, WithSkipInvalid(true))
+	for _, tx := range mempool {
+		bb.Add(tx)
+	}
+	b, skipped, err := bb.Build()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if !reflect.DeepEqual(b.transactions, []Transaction{t[0], t[1], t[3]}) {
+		test.Error("block should only hold the valid transactions")
+	}
+	if len(skipped) != len(invalid) {
+		test.Fatal("should skip the invalid transactions")
+	}
+	for i := 0; i < len(invalid); i++ {
+		if !bytes.Equal(skipped[i], invalid[i].Hash()) {
+			test.Error("skipped transaction hashes do not match")
+		}
+	}
+
+	// transactions breaking the rules of the block are skipped as well: an expired transaction, a transaction
+	// carrying arbitrary data in strict mode, and a transaction depending on a later one
+	writeKeys, newData, oldData, readKeys, readData, _ := generateTransactionInput()
+	memo, _ := NewTransaction(writeKeys, newData, oldData, readKeys, readData, []byte("memo"))
+	expired := valid.With(WithValidUntil(100))
+	later := valid.With(WithNewData([]byte("later")))
+	dependent := valid.With(WithDependencies(later.Hash()))
+	mempool = []Transaction{*expired, *memo, *dependent, *later}
+	opts := []BlockOption{WithTimestamp(101), WithStrictMode(true)}
+	for i, want := range []error{ErrExpiredTransaction, ErrArbitraryData, ErrDependencyOrder} {
+		bb = NewBlockBuilder(stateTree)
+		for _, tx := range mempool[i:] {
+			bb.Add(tx)
+		}
+		_, _, err = bb.Build(opts...)
+		if err != want {
+			test.Errorf("should return %v, returned %v", want, err)
+		}
+	}
+	bb = NewBlockBuilder(stateTree, WithSkipInvalid(true))
+	for _, tx := range mempool {
+		bb.Add(tx)
+	}
+	b, skipped, err = bb.Build(opts...)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if !reflect.DeepEqual(b.transactions, []Transaction{*later}) || len(skipped) != 3 {
+		test.Error("block should only hold the transactions following its rules")
+	}
+	for i := 0; i < len(skipped); i++ {
+		if !bytes.Equal(skipped[i], mempool[i].Hash()) {
+			test.Error("skipped transaction hashes do not match")
+		}
+	}
+}
+
+func TestNoWriteProof(test *testing.T) {
+	var t []Transaction
+	for _, key := range []string{"c", "a", "e"} {
+		tmp, err := NewTransaction([][]byte{[]byte(key)}, [][]byte{[]byte("new")}, [][]byte{{}}, [][]byte{[]byte(key)},
+			[][]byte{{}}, []byte{})
+		if err != nil {
+			test.Fatal(err)
+		}
+		t = append(t, *tmp)
+	}
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	b, err := NewBlock(t, stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// prove keys untouched by the block (between, before and after the written keys)
+	for _, key := range []string{"b", "d", "0", "z"} {
+		p, err := b.ProveNoWrite([]byte(key))
+		if err != nil {
+			test.Error(err)
+		} else if !b.VerifyNoWriteProof([]byte(key), p) {
+			test.Errorf("proof that %q is not written does not check", key)
+		}
+	}
+
+	// a key written by a transaction cannot be proven untouched
+	_, err = b.ProveNoWrite([]byte("c"))
+	if err != ErrKeyWritten {
+		test.Error("should return ErrKeyWritten")
+	}
+	p, err := b.ProveNoWrite([]byte("b"))
+	if err != nil {
+		test.Fatal(err)
+	}
+	if b.VerifyNoWriteProof([]byte("c"), p) || b.VerifyNoWriteProof([]byte("a"), p) {
+		test.Error("proof should not check for a written key")
+	}
+
+	// a block writing no key proves every key untouched
+	empty, err := NewBlock(nil, stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	p, err = empty.ProveNoWrite([]byte("c"))
+	if err != nil {
+		test.Error(err)
+	} else if !empty.VerifyNoWriteProof([]byte("c"), p) || b.VerifyNoWriteProof([]byte("c"), p) {
+		test.Error("proof for an empty block should only check against an empty block")
+	}
+
+	// a root of the written keys omitting a written key is rejected, so the key cannot be proven untouched
+	forged := copyBlock(b)
+	forged.writeKeysRoot = writeKeysRoot([][]byte{[]byte("a"), []byte("e")}, sha512.New512_256)
+	if err := forged.Validate(); err != ErrInvalidWriteKeysRoot {
+		test.Error("should reject a root of the written keys omitting a written key")
+	}
+	if _, err := forged.CheckBlock(stateTree); err != ErrInvalidWriteKeysRoot {
+		test.Error("CheckBlock should reject a root of the written keys omitting a written key")
+	}
+}
+
+func TestBlockSize(test *testing.T) {
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, err := NewBlock(goodTransaction, stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if goodBlock.Size() > 10000 || goodBlock.Size() <= 10000-goodTransaction[0].Size() {
+		test.Error("transactions should fill the block")
+	}
+
+	// the serialized block only adds the header overhead to the transactions
+	empty := *goodBlock
+	empty.transactions = nil
+	if goodBlock.Size() != len(goodBlock.Serialize())-len(empty.Serialize()) {
+		test.Error("block size should match the size of the serialized transactions")
+	}
+}
+
+func TestBlockSerialization(test *testing.T) {
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, _ := NewBlock(goodTransaction, stateTree)
+
+	// serialize and deserialize
+	buff := goodBlock.Serialize()
+	b, err := DeserializeBlock(buff)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if !bytes.Equal(b.Serialize(), buff) || !bytes.Equal(b.Hash(), goodBlock.Hash()) {
+		test.Error("block not serialized and deserialized correctly")
+	}
+
+	// deserialize blocks truncated at several offsets
+	for _, offset := range []int{0, 1, 10, 100, len(buff) / 2, len(buff) - 225, len(buff) - 1} {
+		_, err := DeserializeBlock(buff[:offset])
+		if err != ErrTruncatedBlock {
+			test.Errorf("block truncated at %d: should return ErrTruncatedBlock, got %v", offset, err)
+		}
+	}
+}
+
+func TestFraudProofSerialization(test *testing.T) {
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, _ := NewBlock(goodTransaction, stateTree)
+	badBlock := corruptBlockInterStates(goodBlock)
+	goodFp, err := badBlock.CheckBlock(stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// serialize and deserialize
+	buff := goodFp.Serialize()
+	fp, err := DeserializeFraudProof(buff)
+	if err != nil {
+		test.Error(err)
+	} else if !bytes.Equal(fp.Serialize(), buff) {
+		test.Error("fraud proof not serialized and deserialized correctly")
+	} else if !badBlock.VerifyFraudProof(*fp) {
+		test.Error("deserialized fraud proof does not check")
+	}
+
+	// deserialize a fraud proof with an unknown version
+	buff[0] = 0xff
+	_, err = DeserializeFraudProof(buff)
+	if err == nil {
+		test.Error("should return an error")
+	}
+}
+
+func TestHashedKeys(test *testing.T) {
+	// write to keys longer than a hash
+	var t []Transaction
+	for i := 0; i < 10; i++ {
+		writeKeys, newData, oldData, readKeys, readData, arbitraryData := generateTransactionInput()
+		writeKeys[0] = bytes.Repeat([]byte{byte(i)}, 100)
+		tx, err := NewTransaction(writeKeys, newData, oldData, readKeys, readData, arbitraryData)
+		if err != nil {
+			test.Fatal(err)
+		}
+		t = append(t, *tx)
+	}
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	goodBlock, err := NewBlock(t, stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	badBlock := corruptBlockInterStates(goodBlock)
+	fp, err := badBlock.CheckBlock(stateTree)
+	if err != nil || fp == nil {
+		test.Fatal("should return a fraud proof")
+	}
+
+	// the fraud proof with hashed keys checks, and is smaller
+	hashed, err := fp.HashKeys()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if !badBlock.VerifyFraudProof(*hashed) {
+		test.Error("fraud proof with hashed keys does not check")
+	}
+	if len(hashed.Serialize()) >= len(fp.Serialize()) || hashed.SizeBytes() >= fp.SizeBytes() {
+		test.Error("fraud proof with hashed keys should be smaller")
+	}
+	deserialized, err := DeserializeFraudProof(hashed.Serialize())
+	if err != nil {
+		test.Error(err)
+	} else if !deserialized.Equal(hashed) || !badBlock.VerifyFraudProof(*deserialized) {
+		test.Error("deserialized fraud proof with hashed keys does not check")
+	}
+
+	// a hashed key not written by the transactions does not check
+	corrupted := hashed.Copy()
+	corrupted.writeKeys[0] = keyPath([]byte("other key"), sha512.New512_256)
+	if badBlock.VerifyFraudProof(*corrupted) {
+		test.Error("fraud proof with an unknown hashed key should not check")
+	}
+
+	// fraud proofs about a single key need the key
+	corrupted = fp.Copy()
+	corrupted.kind = KindBlindWrite
+	if _, err := corrupted.HashKeys(); err == nil {
+		test.Error("should return an error")
+	}
+}
+
+func TestDeclaredWrites(test *testing.T) {
+	var t []Transaction
+	for i := 0; i < 10; i++ {
+		writeKeys, newData, oldData, readKeys, readData, arbitraryData := generateTransactionInput()
+		writeKeys[0] = []byte{byte(i)}
+		tx, err := NewTransaction(writeKeys, newData, oldData, readKeys, readData, arbitraryData)
+		if err != nil {
+			test.Fatal(err)
+		}
+		t = append(t, *tx)
+	}
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	goodBlock, _ := NewBlock(t, stateTree)
+	badBlock := corruptBlockInterStates(goodBlock)
+	fp, err := badBlock.CheckBlock(stateTree)
+	if err != nil || fp == nil {
+		test.Fatal("should return a fraud proof")
+	}
+	if !badBlock.VerifyFraudProof(*fp) {
+		test.Fatal("fraud proof does not check")
+	}
+
+	// proven writes in another order than the revealed transactions make them do not check
+	forged := fp.Copy()
+	forged.writeKeys[0], forged.writeKeys[1] = forged.writeKeys[1], forged.writeKeys[0]
+	forged.proofState[0], forged.proofState[1] = forged.proofState[1], forged.proofState[0]
+	if badBlock.VerifyFraudProof(*forged) {
+		test.Error("fraud proof declaring other writes than the transactions should not check")
+	}
+
+	// nor does a write declaring other oldData
+	forged = fp.Copy()
+	forged.oldData[0] = []byte("forged")
+	if badBlock.VerifyFraudProof(*forged) {
+		test.Error("fraud proof declaring other oldData should not check")
+	}
+}
+
+func TestProvenState(test *testing.T) {
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	keys := [][]byte{[]byte("first"), []byte("second"), []byte("third")}
+	for i := 0; i < len(keys); i++ {
+		stateTree.Update(keys[i], stateValue([]byte{byte(i)}))
+	}
+	root := append([]byte{}, stateTree.Root()...)
+
+	// state proofs verify without the state tree
+	proofs := make([]smt.SparseCompactMerkleProof, len(keys))
+	for i := 0; i < len(keys); i++ {
+		proofs[i], _ = stateTree.ProveCompact(keys[i])
+		if !verifyStateProof(proofs[i], root, keys[i], stateValue([]byte{byte(i)}), sha512.New512_256) {
+			test.Errorf("state proof of key %d does not check", i)
+		}
+	}
+	if verifyStateProof(proofs[0], root, keys[0], stateValue([]byte("other")), sha512.New512_256) {
+		test.Error("state proof of another value should not check")
+	}
+
+	// the verifier agrees with the smt package, including on absent keys and tampered proofs
+	absent, _ := stateTree.ProveCompact([]byte("absent"))
+	tampered := append(smt.SparseCompactMerkleProof{}, proofs[1]...)
+	tampered[len(tampered)-1] = proofs[2][len(proofs[2])-1]
+	cases := []struct {
+		proof smt.SparseCompactMerkleProof
+		key   []byte
+		value []byte
+	}{
+		{proofs[0], keys[0], stateValue([]byte{0})},
+		{proofs[0], keys[1], stateValue([]byte{0})},
+		{proofs[1], keys[1], stateValue([]byte("other"))},
+		{absent, []byte("absent"), []byte{}},
+		{absent, []byte("absent"), stateValue([]byte{0})},
+		{tampered, keys[1], stateValue([]byte{1})},
+		{proofs[2][:len(proofs[2])-1], keys[2], stateValue([]byte{2})},
+	}
+	for i, c := range cases {
+		expected := smt.VerifyCompactProof(c.proof, root, c.key, c.value, sha512.New512_256())
+		if verifyStateProof(c.proof, root, c.key, c.value, sha512.New512_256) != expected {
+			test.Errorf("verifier disagrees with the smt package on case %d", i)
+		}
+	}
+	if !smt.VerifyCompactProof(absent, root, []byte("absent"), []byte{}, sha512.New512_256()) {
+		test.Error("state proof of an absent key should check")
+	}
+
+	// the verifier agrees with the hashing of the smt package on random trees, and rebuilds their updated roots
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 5; n++ {
+		randomTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+		randomKeys := make([][]byte, 1+r.Intn(20))
+		for i := 0; i < len(randomKeys); i++ {
+			randomKeys[i] = make([]byte, 1+r.Intn(32))
+			r.Read(randomKeys[i])
+			value := make([]byte, 1+r.Intn(64))
+			r.Read(value)
+			randomTree.Update(randomKeys[i], value)
+		}
+		randomRoot := append([]byte{}, randomTree.Root()...)
+		randomState := newProvenState(randomRoot, sha512.New512_256)
+		for _, key := range randomKeys {
+			value, _ := randomTree.Get(key)
+			proof, _ := randomTree.Prove(key)
+			compact, _ := randomTree.ProveCompact(key)
+			for _, v := range [][]byte{value, append(append([]byte{}, value...), 0)} {
+				expected := smt.VerifyProof(proof, randomRoot, key, v, sha512.New512_256())
+				if verifyStateProof(compact, randomRoot, key, v, sha512.New512_256) != expected {
+					test.Errorf("verifier disagrees with the smt package on a random tree %d", n)
+				}
+			}
+			if err := randomState.add(compact, key, value); err != nil {
+				test.Fatal(err)
+			}
+		}
+		for _, key := range randomKeys {
+			value := make([]byte, 1+r.Intn(64))
+			r.Read(value)
+			randomTree.Update(key, value)
+			if err := randomState.update(key, value); err != nil {
+				test.Fatal(err)
+			}
+		}
+		if !bytes.Equal(randomState.root(), randomTree.Root()) {
+			test.Errorf("proven state does not lead to the root of the random tree %d", n)
+		}
+	}
+
+	// updating the proven keys leads to the root of the updated state tree
+	state := newProvenState(root, sha512.New512_256)
+	for i := 0; i < len(keys); i++ {
+		if err := state.add(proofs[i], keys[i], stateValue([]byte{byte(i)})); err != nil {
+			test.Fatal(err)
+		}
+	}
+	if err := state.add(absent, []byte("absent"), []byte{}); err != nil {
+		test.Fatal(err)
+	}
+	for _, key := range append(keys, []byte("absent")) {
+		stateTree.Update(key, stateValue([]byte("updated")))
+		if err := state.update(key, stateValue([]byte("updated"))); err != nil {
+			test.Fatal(err)
+		}
+	}
+	partial := newProvenState(root, sha512.New512_256)
+	if err := partial.add(proofs[0], keys[0], stateValue([]byte{0})); err != nil {
+		test.Fatal(err)
+	}
+	if partial.update(keys[1], stateValue([]byte("updated"))) == nil {
+		test.Error("should not update a key without a proven branch")
+	}
+	if !bytes.Equal(state.root(), stateTree.Root()) {
+		test.Error("proven state does not lead to the root of the state tree")
+	}
+	if newProvenState(root, sha512.New512_256).add(proofs[0], keys[0], stateValue([]byte("other"))) == nil {
+		test.Error("should not add a branch of another value")
+	}
+
+	// fraud proofs still verify through the proven state
+	goodTransaction, blockStateTree := generateBlockInput(10000)
+	goodBlock, _ := NewBlock(goodTransaction, blockStateTree)
+	badBlock := corruptBlockInterStates(goodBlock)
+	fp, err := badBlock.CheckBlock(blockStateTree)
+	if err != nil || fp == nil {
+		test.Fatal("should return a fraud proof")
+	}
+	if !badBlock.VerifyFraudProof(*fp) {
+		test.Error("fraud proof does not check")
+	}
+}
+
+func TestStateTransitionProof(test *testing.T) {
+	// bring two state trees to the same pre-state
+	previous, replayTree := generateBlockInput(10000)
+	for i := 0; i < len(previous); i++ {
+		previous[i].newData[0] = []byte("previous")
+	}
+	proofTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	NewBlock(previous, replayTree)
+	NewBlock(previous, proofTree)
+
+	// replay the transactions of a new block on the first tree
+	transactions, _ := generateBlockInput(10000)
+	block, err := NewBlock(transactions, replayTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// prove the state transition from the second tree
+	p, err := block.StateTransitionProof(proofTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if !bytes.Equal(p.postStateRoot, replayTree.Root()) {
+		test.Error("aggregate proof does not match the replayed state")
+	}
+	if !block.VerifyStateTransitionProof(p) {
+		test.Error("state transition proof does not check")
+	}
+
+	// verify a corrupted state transition proof
+	corrupted := p
+	corrupted.newValues = append([][]byte{[]byte("random")}, p.newValues[1:]...)
+	if block.VerifyStateTransitionProof(corrupted) {
+		test.Error("invalid state transition proof should not check")
+	}
+
+	// a pre-state forged from the post-state by changing one key does not check
+	forgeTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	NewBlock(previous, forgeTree)
+	for i := 0; i < len(p.keys); i++ {
+		forgeTree.Update(p.keys[i], p.newValues[i])
+	}
+	forgedValue := stateValue([]byte("forged"))
+	forgeTree.Update(p.keys[0], forgedValue)
+	proof, _ := forgeTree.ProveCompact(p.keys[0])
+	forged := BlockStateProof{preStateRoot: append([]byte{}, forgeTree.Root()...), postStateRoot: block.stateRoot,
+		keys: p.keys[:1], oldValues: [][]byte{forgedValue}, newValues: p.newValues[:1],
+		proofs: []smt.SparseCompactMerkleProof{proof},
+		unchanged: append(append([][]byte{}, p.keys[1:]...), p.unchanged...)}
+	if block.VerifyStateTransitionProof(forged) {
+		test.Error("state transition proof from a forged pre-state should not check")
+	}
+
+	// new values not written by the transactions do not prove a forged state root
+	forgedBlock := *block
+	forgedBlock.stateRoot = append([]byte{}, forgeTree.Root()...)
+	forged = corrupted
+	forged.newValues[0], forged.postStateRoot = forgedValue, forgedBlock.stateRoot
+	if forgedBlock.VerifyStateTransitionProof(forged) {
+		test.Error("state transition proof with new values not written by the block should not check")
+	}
+
+	// a block claiming to leave the state unchanged does not check with its keys grouped as unchanged
+	unchangedBlock := *block
+	unchangedBlock.stateRoot = p.preStateRoot
+	forged = BlockStateProof{preStateRoot: p.preStateRoot, postStateRoot: p.preStateRoot,
+		unchanged: append(append([][]byte{}, p.keys...), p.unchanged...),
+		unchangedProofs: append(append([]smt.SparseCompactMerkleProof{}, p.proofs...), p.unchangedProofs...)}
+	if unchangedBlock.VerifyStateTransitionProof(forged) {
+		test.Error("state transition proof grouping changed keys as unchanged should not check")
+	}
+
+	// replaying the block on its post-state leaves its keys unchanged, which are grouped apart
+	replayed, err := NewBlock(transactions, replayTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	unchanged, err := replayed.StateTransitionProof(proofTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(unchanged.UnchangedKeys()) == 0 || len(unchanged.unchangedProofs) != len(unchanged.UnchangedKeys()) {
+		test.Error("unchanged keys should be grouped apart")
+	}
+	if !replayed.VerifyStateTransitionProof(unchanged) {
+		test.Error("state transition proof with unchanged keys does not check")
+	}
+	withoutProofs := unchanged
+	withoutProofs.unchangedProofs = nil
+	if replayed.VerifyStateTransitionProof(withoutProofs) {
+		test.Error("unchanged keys without proofs should not check")
+	}
+	unchanged.unchanged = append(unchanged.unchanged, p.keys[0])
+	unchanged.unchangedProofs = append(unchanged.unchangedProofs, p.proofs[0])
+	unchanged.keys = append(unchanged.keys, p.keys[0])
+	unchanged.oldValues = append(unchanged.oldValues, p.oldValues[0])
+	unchanged.newValues = append(unchanged.newValues, p.oldValues[0])
+	unchanged.proofs = append(unchanged.proofs, p.proofs[0])
+	if replayed.VerifyStateTransitionProof(unchanged) {
+		test.Error("key both changed and unchanged should not check")
+	}
+}
+
+func TestCheckBlockFromRoot(test *testing.T) {
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, _ := NewBlock(goodTransaction, stateTree)
+	badBlock := corruptBlockInterStates(goodBlock)
+
+	// a wrong pre-state root is caught before the state tree is modified
+	stateTree = smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	root := append([]byte{}, stateTree.Root()...)
+	_, err := badBlock.CheckBlockFromRoot(stateTree, goodBlock.stateRoot)
+	if err != ErrPreStateRootMismatch {
+		test.Error("should return ErrPreStateRootMismatch")
+	}
+	if !bytes.Equal(stateTree.Root(), root) {
+		test.Error("state tree should not be modified")
+	}
+
+	// the declared pre-state root is accepted
+	fp, err := badBlock.CheckBlockFromRoot(stateTree, badBlock.preStateRoot)
+	if err != nil {
+		test.Error(err)
+	} else if fp == nil || !badBlock.VerifyFraudProof(*fp) {
+		test.Error("should return a valid fraud proof")
+	}
+}
+
+func TestBlockValidate(test *testing.T) {
+	t, stateTree := generateBlockInput(10000)
+	b, err := NewBlock(t, stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if err := b.Validate(); err != nil {
+		test.Error(err)
+	}
+	if err := b.WithoutTransactions().Validate(); err != nil {
+		test.Error(err)
+	}
+
+	// each inconsistency returns its own error
+	invalid := copyBlock(b)
+	invalid.interStateRoots = invalid.interStateRoots[1:]
+	if err := invalid.Validate(); err != ErrInterStateRootCount {
+		test.Error("should reject a missing intermediate state root")
+	}
+	if err := invalid.WithoutTransactions().Validate(); err != ErrInterStateRootCount {
+		test.Error("should reject a missing intermediate state root without the transactions")
+	}
+	invalid = copyBlock(b)
+	invalid.transactions[1].readData = invalid.transactions[1].readData[1:]
+	if err := invalid.Validate(); err != ErrReadKeyDataMismatch {
+		test.Error("should reject a malformed transaction")
+	}
+	invalid = copyBlock(b)
+	invalid.dataRoot = invalid.stateRoot
+	if err := invalid.Validate(); err != ErrInvalidDataRoot {
+		test.Error("should reject an invalid data root")
+	}
+	invalid = copyBlock(b)
+	invalid.chunkSize = 1
+	if err := invalid.Validate(); err != ErrInvalidChunkSize {
+		test.Error("should reject an invalid chunk size")
+	}
+}
+
+func TestCheckBlockWithResolver(test *testing.T) {
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, _ := NewBlock(goodTransaction, stateTree)
+	badBlock := corruptBlockInterStates(goodBlock)
+	goodFp, err := badBlock.CheckBlock(stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// serve the transactions out-of-band
+	bodies := make(map[string]Transaction)
+	for _, t := range badBlock.transactions {
+		bodies[string(t.Hash())] = t
+	}
+	resolve := func(hash []byte) (Transaction, error) {
+		t, ok := bodies[string(hash)]
+		if !ok {
+			return Transaction{}, errors.New("unknown transaction")
+		}
+		return t, nil
+	}
+
+	// check the block storing only the transaction hashes
+	prunedBlock := badBlock.WithoutTransactions()
+	fp, err := prunedBlock.CheckBlockWithResolver(stateTree, resolve)
+	if err != nil {
+		test.Error(err)
+	} else if fp == nil {
+		test.Error("should return a fraud proof")
+	} else if !bytes.Equal(fp.Serialize(), goodFp.Serialize()) {
+		test.Error("resolver-backed block should produce the same fraud proof")
+	} else if !prunedBlock.VerifyFraudProof(*fp) {
+		test.Error("fraud proof does not check")
+	}
+
+	// check the block with a resolver that cannot find the transactions
+	_, err = prunedBlock.CheckBlockWithResolver(stateTree, func(hash []byte) (Transaction, error) {
+		return Transaction{}, errors.New("unknown transaction")
+	})
+	if err == nil {
+		test.Error("should return an error")
+	}
+}
+
+func TestGenerateRandomBlock(test *testing.T) {
+	block, stateTree, err := GenerateRandomBlock(10000, rand.New(rand.NewSource(1)))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// check the generated block
+	fp, err := block.CheckBlock(stateTree)
+	if err != nil {
+		test.Error(err)
+	} else if fp != nil {
+		test.Error("should not return a fraud proof")
+	}
+
+	// generate the same block from the same source
+	sameBlock, _, err := GenerateRandomBlock(10000, rand.New(rand.NewSource(1)))
+	if err != nil {
+		test.Error(err)
+	} else if !bytes.Equal(block.dataRoot, sameBlock.dataRoot) {
+		test.Error("blocks generated from the same source should be equal")
+	}
+}
+
+func TestFraudProofEqual(test *testing.T) {
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, _ := NewBlock(goodTransaction, stateTree)
+	goodFp, err := corruptBlockInterStates(goodBlock).CheckBlock(stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if !goodFp.Equal(goodFp.Copy()) {
+		test.Error("fraud proof should equal its copy")
+	}
+	if goodFp.Equal(corruptFraudproofChunks(goodFp)) {
+		test.Error("corrupted fraud proof should not equal the original")
+	}
+}
+
+func TestFraudProofValidate(test *testing.T) {
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, _ := NewBlock(goodTransaction, stateTree)
+	badBlock := corruptBlockInterStates(goodBlock)
+	goodFp, err := badBlock.CheckBlock(stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if err := goodFp.Validate(); err != nil {
+		test.Error(err)
+	}
+
+	// every structural violation is reported, and the fraud proof does not check
+	violations := map[string]func(fp *FraudProof){
+		"unknown kind":             func(fp *FraudProof) { fp.kind = 0xff },
+		"writeKeys/oldData":        func(fp *FraudProof) { fp.oldData = fp.oldData[1:] },
+		"readKeys/readData":        func(fp *FraudProof) { fp.readData = fp.readData[1:] },
+		"state proofs":             func(fp *FraudProof) { fp.proofState = fp.proofState[1:] },
+		"no chunks": func(fp *FraudProof) {
+			fp.chunks, fp.proofChunks, fp.chunksIndexes = nil, nil, nil
+		},
+		"chunk proofs":             func(fp *FraudProof) { fp.proofChunks = fp.proofChunks[1:] },
+		"empty chunk":              func(fp *FraudProof) { fp.chunks[0] = nil },
+		"numOfLeaves":              func(fp *FraudProof) { fp.numOfLeaves = 0 },
+		"chunk index out of range": func(fp *FraudProof) { fp.chunksIndexes[0] = fp.numOfLeaves },
+		"unsorted chunk indexes": func(fp *FraudProof) {
+			fp.chunks = append(fp.chunks, fp.chunks[0])
+			fp.proofChunks = append(fp.proofChunks, fp.proofChunks[0])
+			fp.chunksIndexes = append(fp.chunksIndexes, fp.chunksIndexes[0])
+		},
+	}
+	for name, violate := range violations {
+		fp := goodFp.Copy()
+		violate(fp)
+		if fp.Validate() == nil {
+			test.Errorf("%s: should return an error", name)
+		}
+		if badBlock.VerifyFraudProof(*fp) {
+			test.Errorf("%s: malformed fraud proof should not check", name)
+		}
+	}
+}
+
+func TestVerifyPolicy(test *testing.T) {
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, _ := NewBlock(goodTransaction, stateTree)
+	badBlock := corruptBlockInterStates(goodBlock)
+	fp, err := badBlock.CheckBlock(stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// a policy large enough accepts the fraud proof
+	ok, err := badBlock.VerifyFraudProofWithPolicy(*fp, VerifyPolicy{})
+	if err != nil || !ok {
+		test.Error("fraud proof should check without limits")
+	}
+
+	// policies too small reject the otherwise valid fraud proof
+	policies := map[error]VerifyPolicy{
+		ErrTooManyChunks:      {MaxChunks: len(fp.chunks) - 1},
+		ErrTooManyStateProofs: {MaxStateProofs: len(fp.proofState) - 1},
+		ErrTooManyLeaves:      {MaxLeaves: fp.numOfLeaves - 1},
+	}
+	for reason, policy := range policies {
+		ok, err := badBlock.VerifyFraudProofWithPolicy(*fp, policy)
+		if ok || err != reason {
+			test.Errorf("policy should reject the fraud proof with %q", reason)
+		}
+	}
+}
+
+func TestRecomputedRoot(test *testing.T) {
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, _ := NewBlock(goodTransaction, stateTree)
+	badBlock := corruptBlockInterStates(goodBlock)
+	fp, err := badBlock.CheckBlock(stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// the recomputed root is the one of the honest state, not the corrupted one
+	root, err := fp.RecomputedRoot()
+	if err != nil {
+		test.Fatal(err)
+	}
+	if bytes.Equal(root, badBlock.interStateRoots[0]) {
+		test.Error("recomputed root should differ from the corrupted root")
+	}
+	if !bytes.Equal(root, goodBlock.stateRoot) {
+		test.Error("recomputed root should match the honest state")
+	}
+
+	// deserialized fraud proofs recompute the same root
+	deserialized, err := DeserializeFraudProof(fp.Serialize())
+	if err != nil {
+		test.Error(err)
+	} else if recomputed, err := deserialized.RecomputedRoot(); err != nil || !bytes.Equal(recomputed, root) {
+		test.Error("deserialized fraud proof should recompute the same root")
+	}
+}
+
+func TestEquivocation(test *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.New(rand.NewSource(1)))
+	_, otherPriv, _ := ed25519.GenerateKey(rand.New(rand.NewSource(2)))
+
+	// sign two different headers at height 5
+	first, _ := NewBlock(generateBlockInput(10000))
+	second, _ := NewBlock(generateBlockInput(10000))
+	first.height, second.height = 5, 5
+	first.Sign(priv)
+	second.Sign(priv)
+
+	proof := NewEquivocationProof(first.SignedHeader(), second.SignedHeader())
+	if !VerifyEquivocation(proof) {
+		test.Error("equivocation proof does not check")
+	}
+
+	// the same header twice is not an equivocation
+	proof = NewEquivocationProof(first.SignedHeader(), first.SignedHeader())
+	if VerifyEquivocation(proof) {
+		test.Error("identical headers should not be an equivocation")
+	}
+
+	// headers signed by different proposers are not an equivocation
+	second.Sign(otherPriv)
+	proof = NewEquivocationProof(first.SignedHeader(), second.SignedHeader())
+	if VerifyEquivocation(proof) {
+		test.Error("headers of different proposers should not be an equivocation")
+	}
+
+	// headers at different heights are not an equivocation
+	second.height = 6
+	second.Sign(priv)
+	proof = NewEquivocationProof(first.SignedHeader(), second.SignedHeader())
+	if VerifyEquivocation(proof) {
+		test.Error("headers at different heights should not be an equivocation")
+	}
+}
+
+func TestCheckBlockRange(test *testing.T) {
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, _ := NewBlock(goodTransaction, stateTree)
+	middle := len(goodBlock.transactions) / (2 * Step) * Step
+
+	// corrupt an intermediate state root in the second half of the block
+	badBlock := corruptBlockInterState(goodBlock, len(goodBlock.interStateRoots)-1)
+
+	// check the first half from the state preceding the block
+	firstTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	fp, err := badBlock.CheckBlockRange(firstTree, 0, middle)
+	if err != nil {
+		test.Error(err)
+	} else if fp != nil {
+		test.Error("should not return a fraud proof")
+	}
+
+	// check the second half from a wrong pre-state
+	_, err = badBlock.CheckBlockRange(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()), middle,
+		len(badBlock.transactions))
+	if err == nil {
+		test.Error("should return an error")
+	}
+
+	// check the second half from the state preceding the transaction 'middle'
+	secondTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	fillStateTree(badBlock.transactions[:middle], secondTree, RawEncoding)
+	fp, err = badBlock.CheckBlockRange(secondTree, middle, len(badBlock.transactions))
+	if err != nil {
+		test.Error(err)
+	} else if fp == nil {
+		test.Error("should return a fraud proof")
+	} else if !badBlock.VerifyFraudProof(*fp) {
+		test.Error("fraud proof does not check")
+	}
+
+	// check a range which is not aligned on intermediate state roots
+	_, err = badBlock.CheckBlockRange(firstTree, 1, middle)
+	if err == nil {
+		test.Error("should return an error")
+	}
+
+	// check the ranges of an honest block starting at, and following, a first group writing nothing
+	idleTransaction, idleTree := generateIdleBlockInput(10000)
+	idleBlock, err := NewBlock(idleTransaction, idleTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	idleTree = smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	for _, r := range [][2]int{{0, Step}, {Step, len(idleBlock.transactions)}} {
+		fp, err = idleBlock.CheckBlockRange(idleTree, r[0], r[1])
+		if err != nil {
+			test.Error(err)
+		} else if fp != nil {
+			test.Errorf("range [%d, %d) of an honest block should not return a fraud proof", r[0], r[1])
+		}
+	}
+}
+
+func TestChunksForTransaction(test *testing.T) {
+	t, stateTree := generateBlockInput(10000)
+	b, err := NewBlock(t, stateTree, WithChunkSize(64))
+	if err != nil {
+		test.Fatal(err)
+	}
+	for _, index := range []int{0, len(t) - 1} {
+		chunks, chunksIndexes, proofChunks, err := b.ChunksForTransaction(index)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		// the chunks are in the data tree of the block
+		for i := 0; i < len(chunks); i++ {
+			if !bytes.Equal(proofChunks[i][0], chunks[i]) ||
+				!merkletree.VerifyProof(b.newHash(), b.dataRoot, proofChunks[i], chunksIndexes[i], b.NumLeaves()) {
+				test.Errorf("chunk %d of transaction %d does not re-derive the data root", i, index)
+			}
+		}
+
+		// the chunks hold the transaction, and nothing but the chunks covering it
+		var buff []byte
+		for i := 0; i < len(chunks); i++ {
+			buff = append(buff, chunks[i][1:]...)
+		}
+		entry := append([]byte{byte(LeafTransaction)}, t[index].Serialize()...)
+		size := b.chunkSize - 1
+		if !bytes.Contains(buff, entry) || len(chunks) > (len(entry)+size-1)/size+1 {
+			test.Errorf("chunks do not cover exactly transaction %d", index)
+		}
+	}
+	if _, _, _, err := b.ChunksForTransaction(len(t)); err == nil {
+		test.Error("should return an error")
+	}
+}
+
+func TestEstimateProofCost(test *testing.T) {
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, _ := NewBlock(goodTransaction, stateTree)
+	badBlock := corruptBlockInterStates(goodBlock)
+	fp, err := badBlock.CheckBlock(stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	chunks, stateProofs := badBlock.EstimateProofCost()
+	if len(fp.chunks) > chunks || len(fp.proofChunks) > chunks {
+		test.Error("fraud proof contains more chunks than estimated")
+	}
+	if len(fp.proofState) != stateProofs {
+		test.Error("fraud proof does not contain the estimated number of state proofs")
+	}
+}
+
+func TestKeccakHash(test *testing.T) {
+	goodTransaction, _ := generateBlockInput(10000)
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha3.NewLegacyKeccak256())
+	goodBlock, err := NewBlock(goodTransaction, stateTree, KeccakHash)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// the data root depends on the hash function
+	defaultBlock, _ := NewBlock(goodTransaction, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if bytes.Equal(goodBlock.dataRoot, defaultBlock.dataRoot) {
+		test.Error("data root should depend on the hash function")
+	}
+
+	// generate and verify a fraud proof under Keccak-256
+	badBlock := corruptBlockInterStates(goodBlock)
+	fp, err := badBlock.CheckBlock(stateTree)
+	if err != nil {
+		test.Error(err)
+	} else if fp == nil {
+		test.Error("should return a fraud proof")
+	} else if !badBlock.VerifyFraudProof(*fp) {
+		test.Error("fraud proof does not check")
+	}
+}
+
+func TestBlockchain(test *testing.T) {
+	// add good blocks to blockchain
+	blockchain := NewBlockchain()
+	transactions, stateTree := generateBlockInput(1000000)
+	goodBlock, _ := NewBlock(transactions, stateTree, WithHeight(1))
+	height, _, _ := blockchain.Append(goodBlock) // add a first block
+	if height != 1 {
+		test.Error("first block should be accepted at height 1")
+	}
+	transactions, stateTree = generateBlockInput(1000000)
+	secondBlock, _ := NewBlock(transactions, stateTree, WithParent(goodBlock.Header()))
+	height, fp, err := blockchain.Append(secondBlock) // add a second block
+	if err != nil {
+		test.Error(err)
+	} else if fp != nil {
+		test.Error("should not return a fraud proof")
+	} else if height != 2 {
+		test.Error("second block should be accepted at height 2")
+	}
+
+	// blocks not extending the last block are rejected, and left untouched
+	transactions, stateTree = generateBlockInput(1000000)
+	thirdBlock, _ := NewBlock(transactions, stateTree, WithParent(secondBlock.Header()))
+	wrongParent := copyBlock(thirdBlock)
+	wrongParent.parentHash = goodBlock.Hash()
+	wrongHeight := copyBlock(thirdBlock)
+	wrongHeight.height++
+	for _, b := range []*Block{goodBlock, secondBlock, wrongParent, wrongHeight} {
+		hash := b.Hash()
+		if _, _, err := blockchain.Append(b); err != ErrInvalidParent {
+			test.Error("should return ErrInvalidParent")
+		}
+		if !bytes.Equal(b.Hash(), hash) {
+			test.Error("rejected block should not be modified")
+		}
+	}
+	if blockchain.Len() != 2 {
+		test.Error("blocks not extending the last block should not be appended")
+	}
+
+	// add bad block to blockchain (corrupted intermediate state)
+	height, fp, err = blockchain.Append(corruptBlockInterStates(thirdBlock))
+	if err != nil {
+		test.Error(err)
+	} else if fp == nil {
+		test.Error("should return a fraud proof")
+	} else if height != 0 {
+		test.Error("rejected block should not return a height")
+	}
+
+	// add bad block to blockchain (corrupted transactions)
+	corrupted := generateBlockWithCorruptedTransactions()
+	corrupted.height, corrupted.parentHash = 3, secondBlock.Hash()
+	_, _, err = blockchain.Append(corrupted)
+	if err == nil || err == ErrInvalidParent {
+		test.Error("should return an error")
+	}
+}
+
+func TestAppendBatch(test *testing.T) {
+	blockchain := NewBlockchain()
+	transactions, stateTree := generateBlockInput(10000)
+	first, _ := NewBlock(transactions, stateTree, WithHeight(1))
+	blockchain.Append(first)
+	stateRoot := append([]byte{}, blockchain.stateTree.Root()...)
+
+	// a batch whose third block is corrupted is rolled back
+	var batch []*Block
+	parent := first
+	for i := 0; i < 4; i++ {
+		transactions, stateTree := generateBlockInput(10000)
+		b, _ := NewBlock(transactions, stateTree, WithParent(parent.Header()))
+		batch, parent = append(batch, b), b
+	}
+	good := batch[2]
+	batch[2] = corruptBlockInterStates(copyBlock(good))
+	fp, err := blockchain.AppendBatch(batch)
+	if err != nil {
+		test.Error(err)
+	} else if fp == nil {
+		test.Error("should return a fraud proof")
+	}
+	if blockchain.Len() != 1 {
+		test.Error("failed batch should not change the length of the blockchain")
+	}
+	if !bytes.Equal(blockchain.stateTree.Root(), stateRoot) {
+		test.Error("failed batch should not change the state")
+	}
+	if last, _ := blockchain.Block(1); last != first {
+		test.Error("failed batch should not change the last block")
+	}
+
+	// a batch of good blocks is appended
+	batch[2] = good
+	fp, err = blockchain.AppendBatch(batch)
+	if err != nil {
+		test.Error(err)
+	} else if fp != nil {
+		test.Error("should not return a fraud proof")
+	} else if blockchain.Len() != 5 {
+		test.Error("batch should be appended")
+	}
+}
+
+func TestAppendBatchWorkers(test *testing.T) {
+	// a batch of blocks built on top of each other
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	var blocks []*Block
+	for i := 0; i < 32; i++ {
+		t, _ := generateBlockInput(10000)
+		link := WithHeight(1)
+		if i > 0 {
+			link = WithParent(blocks[i-1].Header())
+		}
+		b, err := NewBlock(t, stateTree, link)
+		if err != nil {
+			test.Fatal(err)
+		}
+		blocks = append(blocks, b)
+	}
+
+	// the good batch, a batch with a corrupted intermediate state root, and a batch with a corrupted data root
+	badDataRoot := copyBlock(blocks[5])
+	badDataRoot.dataRoot = []byte("random")
+	batches := [][]*Block{
+		blocks,
+		append(append(append([]*Block{}, blocks[:20]...), corruptBlockInterStates(copyBlock(blocks[20]))),
+			blocks[21:]...),
+		append(append(append([]*Block{}, blocks[:5]...), badDataRoot), blocks[6:]...),
+	}
+	for _, batch := range batches {
+		sequential, pooled := NewBlockchain(), NewBlockchain(WithWorkers(4))
+		var sequentialBatch, pooledBatch []*Block
+		for _, b := range batch {
+			sequentialBatch = append(sequentialBatch, copyBlock(b))
+			pooledBatch = append(pooledBatch, copyBlock(b))
+		}
+		fp, err := sequential.AppendBatch(sequentialBatch)
+		pooledFp, pooledErr := pooled.AppendBatch(pooledBatch)
+		if err != pooledErr || (fp == nil) != (pooledFp == nil) || (fp != nil && !fp.Equal(pooledFp)) {
+			test.Error("pooled batch should return the same fraud proof (or error) as the sequential batch")
+		}
+		if sequential.Len() != pooled.Len() || !bytes.Equal(sequential.StateRoot(), pooled.StateRoot()) {
+			test.Error("pooled batch should lead to the same blockchain as the sequential batch")
+		}
+		for height := uint64(1); height <= sequential.Len(); height++ {
+			header, _ := sequential.Header(height)
+			pooledHeader, _ := pooled.Header(height)
+			if !bytes.Equal(header.Hash(), pooledHeader.Hash()) {
+				test.Error("pooled batch should append the same blocks as the sequential batch")
+			}
+		}
+	}
+}
+
+func TestBlockchainReset(test *testing.T) {
+	blockchain := NewBlockchain()
+	for i := 0; i < 3; i++ {
+		t, stateTree := generateBlockInput(10000)
+		b, _ := NewBlock(t, stateTree, extending(blockchain))
+		if _, fp, err := blockchain.Append(b); err != nil || fp != nil {
+			test.Fatal("block should be appended")
+		}
+	}
+
+	blockchain.Reset()
+	empty := NewBlockchain()
+	if blockchain.Len() != 0 || !bytes.Equal(blockchain.StateRoot(), empty.StateRoot()) {
+		test.Error("reset blockchain should be empty")
+	}
+	if _, err := blockchain.Block(1); err == nil {
+		test.Error("reset blockchain should have no block")
+	}
+
+	t, stateTree := generateBlockInput(10000)
+	b, _ := NewBlock(t, stateTree, extending(blockchain))
+	height, fp, err := blockchain.Append(b)
+	if err != nil || fp != nil || height != 1 {
+		test.Error("block should be appended at height 1 after a reset")
+	}
+	if !bytes.Equal(blockchain.StateRoot(), b.stateRoot) {
+		test.Error("state should be the state of the appended block")
+	}
+}
+
+func TestNamespace(test *testing.T) {
+	store := smt.NewSimpleMap()
+	first := NewBlockchainWithStore(store, WithNamespace([]byte("first")))
+	second := NewBlockchainWithStore(store, WithNamespace([]byte("second")))
+
+	// write a key on the first blockchain
+	t, _ := generateBlockInput(10000)
+	key, data := t[0].writeKeys[0], t[0].newData[0]
+	b, _ := NewBlock(t, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()), extending(first))
+	if _, fp, err := first.Append(b); err != nil || fp != nil {
+		test.Fatal("block should be appended")
+	}
+	if value, _ := second.Get(key); len(value) != 0 {
+		test.Error("write on a blockchain should not affect the other one")
+	}
+
+	// write the same key on the second blockchain
+	t, _ = generateBlockInput(10000)
+	for i := 0; i < len(t); i++ {
+		t[i].newData[0] = []byte("second")
+	}
+	b, _ = NewBlock(t, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()), extending(second))
+	if _, fp, err := second.Append(b); err != nil || fp != nil {
+		test.Fatal("block should be appended")
+	}
+	if value, _ := first.Get(key); !bytes.Equal(value, data) {
+		test.Error("write on a blockchain should not affect the other one")
+	}
+	if value, _ := second.Get(key); !bytes.Equal(value, []byte("second")) {
+		test.Error("blockchain should read its own writes")
+	}
+
+	// each namespace saves its own state root
+	reopened := NewBlockchainWithStore(store, WithNamespace([]byte("first")))
+	if !bytes.Equal(reopened.StateRoot(), first.StateRoot()) {
+		test.Error("state root of the namespace not persisted")
+	}
+}
+
+func TestForks(test *testing.T) {
+	// build two forks of the same length, with blocks of different timestamps
+	var chains []*Blockchain
+	for i := 0; i < 2; i++ {
+		bc := NewBlockchain()
+		for j := 0; j < 2; j++ {
+			t, _ := generateBlockInput(10000)
+			b, _ := NewBlock(t, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()),
+				WithTimestamp(int64(10*i+j)), extending(bc))
+			if _, fp, err := bc.Append(b); err != nil || fp != nil {
+				test.Fatal("block should be appended")
+			}
+		}
+		chains = append(chains, bc)
+	}
+	lowest := chains[0]
+	if bytes.Compare(chains[1].last.Hash(), chains[0].last.Hash()) < 0 {
+		lowest = chains[1]
+	}
+
+	// the canonical fork does not depend on the insertion order
+	forward, backward := &Forks{}, &Forks{}
+	forward.Add(chains[0])
+	forward.Add(chains[1])
+	backward.Add(chains[1])
+	backward.Add(chains[0])
+	if forward.Canonical() != lowest || backward.Canonical() != lowest {
+		test.Error("canonical fork should be the one whose last block has the lowest hash")
+	}
+
+	// a longer fork wins
+	longer := NewBlockchain()
+	for j := 0; j < 3; j++ {
+		t, stateTree := generateBlockInput(10000)
+		b, _ := NewBlock(t, stateTree, extending(longer))
+		if _, fp, err := longer.Append(b); err != nil || fp != nil {
+			test.Fatal("block should be appended")
+		}
+	}
+	forward.Add(longer)
+	if forward.Canonical() != longer {
+		test.Error("canonical fork should be the longest one")
+	}
+	if (&Forks{}).Canonical() != nil {
+		test.Error("no fork should have no canonical fork")
+	}
+}
+
+func TestBlockchainLogger(test *testing.T) {
+	blockchain := NewBlockchain()
+	logger := &captureLogger{}
+	blockchain.SetLogger(logger)
+
+	// add bad block to blockchain (corrupted intermediate state)
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, _ := NewBlock(goodTransaction, stateTree, extending(blockchain))
+	_, fp, err := blockchain.Append(corruptBlockInterStates(goodBlock))
+	if err != nil {
+		test.Error(err)
+	} else if fp == nil {
+		test.Error("should return a fraud proof")
+	}
+
+	found := false
+	for _, line := range logger.lines {
+		if strings.Contains(line, "fraud proof") {
+			found = true
+		}
+	}
+	if !found {
+		test.Error("fraud proof should be logged")
+	}
+}
+
+func TestChainView(test *testing.T) {
+	blockchain := NewBlockchain()
+	transactions, stateTree := generateBlockInput(10000)
+	first, _ := NewBlock(transactions, stateTree, WithHeight(1))
+	transactions, stateTree = generateBlockInput(10000)
+	second, _ := NewBlock(transactions, stateTree, WithParent(first.Header()))
+	blockchain.Append(first)
+	blockchain.Append(second)
+
+	// read the blockchain through the view
+	var view ChainView = blockchain
+	if view.Len() != 2 {
+		test.Error("view should contain two blocks")
+	}
+	b, err := view.Block(1)
+	if err != nil {
+		test.Error(err)
+	} else if b != first {
+		test.Error("view returned the wrong block")
+	}
+	h, err := view.Header(2)
+	if err != nil {
+		test.Error(err)
+	} else if !bytes.Equal(h.Hash(), second.Hash()) {
+		test.Error("view returned the wrong header")
+	}
+	_, err = view.Block(3)
+	if err == nil {
+		test.Error("should return an error")
+	}
+	data, err := view.Get(second.transactions[0].writeKeys[0])
+	if err != nil {
+		test.Error(err)
+	} else if !bytes.Equal(data, second.transactions[len(second.transactions)-1].newData[0]) {
+		test.Error("view returned the wrong state")
+	}
+
+	// the view cannot append blocks
+	if _, ok := reflect.TypeOf((*ChainView)(nil)).Elem().MethodByName("Append"); ok {
+		test.Error("view should not expose Append")
+	}
+}
+
+func TestNonceFraudProof(test *testing.T) {
+	// a block replaying a nonce is rejected
+	t := generateNonceBlockInput([]byte("alice"), []uint64{0, 1, 1, 2})
+	_, err := NewBlock(t, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != ErrInvalidNonce {
+		test.Error("should return ErrInvalidNonce")
+	}
+
+	// generate and verify fraud proofs for blocks replaying a nonce
+	for _, nonces := range [][]uint64{{0, 1, 1, 2}, {0, 0}} {
+		badBlock, err := forgeBlock(generateNonceBlockInput([]byte("alice"), nonces))
+		if err != nil {
+			test.Fatal(err)
+		}
+		fp, err := badBlock.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+		if err != nil {
+			test.Fatal(err)
+		}
+		if fp == nil || fp.Kind() != KindInvalidNonce {
+			test.Fatal("should generate an invalid nonce fraud proof")
+		}
+		if fp.txIndex != uint64(len(nonces)/2) {
+			test.Error("fraud proof should point at the replayed transaction")
+		}
+		if !badBlock.VerifyFraudProof(*fp) {
+			test.Error("fraud proof should check")
+		}
+
+		// the fraud proof survives serialization
+		deserialized, err := DeserializeFraudProof(fp.Serialize())
+		if err != nil {
+			test.Error(err)
+		} else if !deserialized.Equal(fp) {
+			test.Error("fraud proof not serialized and deserialized correctly")
+		}
+
+		// the fraud proof cannot blame a transaction with a valid nonce
+		corrupted := fp.Copy()
+		corrupted.txIndex--
+		if badBlock.VerifyFraudProof(*corrupted) {
+			test.Error("fraud proof blaming a valid nonce should not check")
+		}
+	}
+
+	// a block with sequential nonces passes and commits the next nonce
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	goodBlock, err := NewBlock(generateNonceBlockInput([]byte("alice"), []uint64{0, 1, 2}), stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fp, err := goodBlock.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil {
+		test.Error(err)
+	} else if fp != nil {
+		test.Error("honest block should not generate a fraud proof")
+	}
+	value, _ := stateTree.Get(nonceKey([]byte("alice")))
+	if nonceFromValue(value) != 3 {
+		test.Error("next nonce not committed in the state")
+	}
+
+	// the nonce cannot be proven against a wrong intermediate state root preceding it, which is disputed instead
+	badBlock, err := forgeBlock(generateNonceBlockInput([]byte("alice"), []uint64{0, 1, 2, 3, 4, 4}))
+	if err != nil {
+		test.Fatal(err)
+	}
+	badBlock = corruptBlockInterState(badBlock, 0)
+	fp, err = badBlock.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil || fp == nil {
+		test.Fatal("should return a fraud proof")
+	}
+	if fp.Kind() == KindInvalidNonce || fp.txIndex != uint64(Step-1) {
+		test.Error("fraud proof should dispute the wrong intermediate state root")
+	}
+	if !badBlock.VerifyFraudProof(*fp) {
+		test.Error("fraud proof of the wrong intermediate state root should check")
+	}
+}
+
+func TestExpectedRootFraudProof(test *testing.T) {
+	// declare the state root expected after each transaction
+	t := generateNonceBlockInput([]byte("alice"), []uint64{0, 1, 2, 3})
+	replayTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	for i := 0; i < len(t); i++ {
+		fillStateTree(t[i:i+1], replayTree, RawEncoding)
+		t[i].expectedRoot = append([]byte{}, replayTree.Root()...)
+	}
+	goodBlock, err := NewBlock(t, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil {
+		test.Fatal(err)
+	}
+	fp, err := goodBlock.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil {
+		test.Error(err)
+	} else if fp != nil {
+		test.Error("honest block should not generate a fraud proof")
+	}
+
+	for _, i := range []int{1, 2} {
+		// a block with a wrong expected root is rejected
+		badBlock := corruptExpectedRoot(goodBlock, i)
+		stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+		root := append([]byte{}, stateTree.Root()...)
+		_, err = NewBlock(badBlock.transactions, stateTree)
+		if err != ErrInvalidExpectedRoot {
+			test.Error("should return ErrInvalidExpectedRoot")
+		}
+		if !bytes.Equal(stateTree.Root(), root) {
+			test.Error("rejected block should not change the root of the state tree")
+		}
+
+		// generate and verify a fraud proof
+		fp, err := badBlock.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+		if err != nil {
+			test.Fatal(err)
+		}
+		if fp == nil || fp.Kind() != KindInvalidExpectedRoot || fp.txIndex != uint64(i) {
+			test.Fatal("should generate an invalid expected root fraud proof")
+		}
+		if !badBlock.VerifyFraudProof(*fp) {
+			test.Error("fraud proof should check")
+		}
+		deserialized, err := DeserializeFraudProof(fp.Serialize())
+		if err != nil {
+			test.Error(err)
+		} else if !badBlock.VerifyFraudProof(*deserialized) {
+			test.Error("deserialized fraud proof does not check")
+		}
+
+		// the fraud proof cannot lie about the state
+		corrupted := fp.Copy()
+		corrupted.oldData[0] = stateValue([]byte("random"))
+		if badBlock.VerifyFraudProof(*corrupted) {
+			test.Error("invalid fraud proof should not check")
+		}
+	}
+}
+
+func TestParallelDataRoot(test *testing.T) {
+	// the parallel and sequential roots match for every shape of tree
+	chunks := make([][]byte, 33)
+	for i := 0; i < len(chunks); i++ {
+		chunks[i] = []byte{byte(i)}
+	}
+	for n := 1; n <= len(chunks); n++ {
+		dataTree := merkletree.New(sha512.New512_256())
+		for i := 0; i < n; i++ {
+			dataTree.Push(chunks[i])
+		}
+		if !bytes.Equal(parallelDataRoot(chunks[:n], sha512.New512_256), dataTree.Root()) {
+			test.Errorf("parallel and sequential roots differ for %d leaves", n)
+		}
+	}
+
+	// the data root of a block matches the sequential root
+	b, _ := NewBlock(generateBlockInput(100000))
+	dataRoot, err := fillDataTree(b.transactions, b.interStateRoots, merkletree.New(b.newHash()), b.chunkSize)
+	if err != nil {
+		test.Error(err)
+	} else if !bytes.Equal(b.dataRoot, dataRoot) {
+		test.Error("block data root does not match the sequential root")
+	}
+}
+
+func BenchmarkDataRoot(b *testing.B) {
+	t, stateTree := generateBlockInput(1000000)
+	interStateRoots, _, _ := fillStateTree(t, stateTree, RawEncoding)
+	chunks, _, _ := makeChunks(chunksSize, t, interStateRoots)
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			dataTree := merkletree.New(sha512.New512_256())
+			for j := 0; j < len(chunks); j++ {
+				dataTree.Push(chunks[j])
+			}
+			dataTree.Root()
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			parallelDataRoot(chunks, sha512.New512_256)
+		}
+	})
+}
+
+func TestNewBlockStreaming(test *testing.T) {
+	// a block of unique transactions, a block replaying a transaction, and a block whose first group writes nothing
+	t, _ := generateBlockInput(100000)
+	idle, _ := generateIdleBlockInput(100000)
+	for _, input := range [][]Transaction{t, append(append([]Transaction{}, t[:5]...), t[1]), idle} {
+		for _, chunkSize := range []int{2, 37, 256} {
+			stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+			b, err := NewBlock(input, stateTree, WithChunkSize(chunkSize), WithTimestamp(1))
+			if err != nil {
+				test.Fatal(err)
+			}
+
+			// feed the transactions over a channel
+			txs := make(chan Transaction)
+			go func() {
+				for i := 0; i < len(input); i++ {
+					txs <- input[i]
+				}
+				close(txs)
+			}()
+			streamed, err := NewBlockStreaming(txs, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()),
+				WithChunkSize(chunkSize), WithTimestamp(1))
+			if err != nil {
+				test.Fatal(err)
+			}
+			if !bytes.Equal(streamed.dataRoot, b.dataRoot) {
+				test.Errorf("streamed data root does not match with chunks of %d bytes", chunkSize)
+			}
+			if !bytes.Equal(streamed.stateRoot, b.stateRoot) || !bytes.Equal(streamed.preStateRoot, b.preStateRoot) {
+				test.Errorf("streamed state roots do not match with chunks of %d bytes", chunkSize)
+			}
+			if !bytes.Equal(streamed.writeKeysRoot, b.writeKeysRoot) {
+				test.Errorf("streamed root of the written keys does not match with chunks of %d bytes", chunkSize)
+			}
+			pruned := b.WithoutTransactions()
+			if !bytes.Equal(streamed.Serialize(), pruned.Serialize()) ||
+				!reflect.DeepEqual(streamed.transactionHashes, pruned.transactionHashes) {
+				test.Errorf("streamed block does not match with chunks of %d bytes", chunkSize)
+			}
+		}
+	}
+
+	// an invalid transaction aborts the block
+	txs := make(chan Transaction, 2)
+	txs <- t[0]
+	txs <- t[0]
+	close(txs)
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	_, err := NewBlockStreaming(txs, stateTree, WithCheckOldData(true))
+	if err != ErrStaleOldData {
+		test.Error("should reject stale oldData")
+	}
+}
+
+func TestMaxBlockBytes(test *testing.T) {
+	t, stateTree := generateBlockInput(10000)
+	b, err := NewBlock(t, stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	limit := len(b.Serialize())
+
+	// a block of exactly the limit is accepted
+	stateTree = smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	if _, err := NewBlock(t, stateTree, WithMaxBlockBytes(limit)); err != nil {
+		test.Error(err)
+	}
+
+	// a block just over the limit is rejected, leaving the state untouched
+	stateTree = smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	root := append([]byte{}, stateTree.Root()...)
+	if _, err := NewBlock(t, stateTree, WithMaxBlockBytes(limit-1)); err != ErrBlockTooLarge {
+		test.Error("should reject a block over the limit")
+	}
+	if !bytes.Equal(stateTree.Root(), root) {
+		test.Error("rejected block should not change the state")
+	}
+
+	// so are blocks built from a stream of transactions
+	txs := make(chan Transaction, len(t))
+	for i := 0; i < len(t); i++ {
+		txs <- t[i]
+	}
+	close(txs)
+	stateTree = smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	if _, err := NewBlockStreaming(txs, stateTree, WithMaxBlockBytes(limit-1)); err != ErrBlockTooLarge {
+		test.Error("should reject a streamed block over the limit")
+	}
+}
+
+func TestDependencies(test *testing.T) {
+	first, _ := NewTransaction(generateTransactionInput())
+	writeKeys, newData, oldData, readKeys, readData, arbitrary := generateTransactionInput()
+	second, err := NewTransaction(writeKeys, newData, oldData, readKeys, readData, arbitrary,
+		WithDependencies(first.Hash()))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// the dependency survives serialization
+	deserialized, err := Deserialize(second.Serialize())
+	if err != nil {
+		test.Error(err)
+	} else if len(deserialized.dependsOn) != 1 || !bytes.Equal(deserialized.dependsOn[0], first.Hash()) {
+		test.Error("dependencies not serialized and deserialized correctly")
+	}
+
+	// a transaction cannot appear before a transaction it depends on
+	_, err = NewBlock([]Transaction{*second, *first}, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != ErrDependencyOrder {
+		test.Error("should return ErrDependencyOrder")
+	}
+	_, err = NewBlock([]Transaction{*first, *second}, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil {
+		test.Error(err)
+	}
+}
+
+func TestBalanceFraudProof(test *testing.T) {
+	// a block where a write brings a balance below zero
+	var t []Transaction
+	for i, data := range [][2][]byte{{{0x00, 0x64}, {0x00, 0x0a}}, {{0x00, 0x05}, {0xff, 0xfb}}} {
+		key := []byte{byte(i)}
+		tx, err := NewTransaction([][]byte{key}, [][]byte{data[1]}, [][]byte{data[0]}, [][]byte{key},
+			[][]byte{data[0]}, []byte{})
+		if err != nil {
+			test.Fatal(err)
+		}
+		t = append(t, *tx)
+	}
+	b, err := NewBlock(t, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil {
+		test.Fatal(err)
+	}
+	fp, err := b.CheckBalances(BalanceRule{})
+	if err != nil {
+		test.Fatal(err)
+	}
+	if fp == nil || fp.Kind() != KindInvalidBalance || fp.txIndex != 1 {
+		test.Fatal("should generate an invalid balance fraud proof")
+	}
+	if !b.VerifyFraudProof(*fp) {
+		test.Error("fraud proof should check")
+	}
+
+	// a withdrawal larger than authorized
+	withdrawal, err := NewBlock(t[:1], smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil {
+		test.Fatal(err)
+	}
+	rule := BalanceRule{MaxWithdrawal: big.NewInt(50)}
+	fp, err = withdrawal.CheckBalances(rule)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if fp == nil || fp.txIndex != 0 {
+		test.Fatal("should generate an invalid balance fraud proof")
+	}
+	if !withdrawal.VerifyBalanceFraudProof(*fp, rule) {
+		test.Error("fraud proof should check against the rule")
+	}
+	if withdrawal.VerifyFraudProof(*fp) {
+		test.Error("authorized withdrawal should not check without the rule")
+	}
+
+	// a withdrawal within the authorized amount does not generate a fraud proof
+	fp, err = withdrawal.CheckBalances(BalanceRule{MaxWithdrawal: big.NewInt(90)})
+	if err != nil {
+		test.Error(err)
+	} else if fp != nil {
+		test.Error("authorized withdrawal should not generate a fraud proof")
+	}
+}
+
+func TestSingleTransactionBlock(test *testing.T) {
+	t, _ := generateBlockInput(10000)
+	for _, n := range []int{1, 3} {
+		goodBlock, _ := NewBlock(t[:n], smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+		badBlock := copyBlock(goodBlock)
+		badBlock.stateRoot = make([]byte, len(goodBlock.stateRoot))
+
+		fp, err := goodBlock.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+		if err != nil {
+			test.Fatal(err)
+		}
+		if fp != nil {
+			test.Error("good block should not generate a fraud proof")
+		}
+		fp, err = badBlock.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+		if err != nil {
+			test.Fatal(err)
+		}
+		if fp == nil || fp.Kind() != KindInvalidInterStateRoot || fp.txIndex != uint64(n-1) {
+			test.Fatal("bad block should generate a fraud proof of its state root")
+		}
+		deserialized, err := DeserializeFraudProof(fp.Serialize())
+		if err != nil {
+			test.Fatal(err)
+		}
+		if !badBlock.VerifyFraudProof(*fp) || !badBlock.VerifyFraudProof(*deserialized) {
+			test.Error("fraud proof should check")
+		}
+		if goodBlock.VerifyFraudProof(*fp) {
+			test.Error("fraud proof should not check against the good block")
+		}
+	}
+}
+
+func TestVerifyStream(test *testing.T) {
+	// append four blocks, then corrupt the second and the fourth
+	sender := []byte("alice")
+	blockchain := NewBlockchain()
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	var blocks []*Block
+	for i := uint64(0); i < 4; i++ {
+		b, err := NewBlock(generateNonceBlockInput(sender, []uint64{2 * i, 2*i + 1}), stateTree, extending(blockchain))
+		if err != nil {
+			test.Fatal(err)
+		}
+		_, fp, err := blockchain.Append(b)
+		if err != nil || fp != nil {
+			test.Fatal("block should be appended")
+		}
+		blocks = append(blocks, b)
+	}
+	stateRoot := blockchain.StateRoot()
+	for _, i := range []int{1, 3} {
+		blocks[i].dataRoot = corruptBlockInterState(blocks[i], 0).dataRoot
+	}
+
+	var heights []uint64
+	err := blockchain.VerifyStream(func(height uint64, fp *FraudProof) bool {
+		b, _ := blockchain.Block(height)
+		if !b.VerifyFraudProof(*fp) {
+			test.Error("fraud proof should check")
+		}
+		heights = append(heights, height)
+		return true
+	})
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(heights) != 2 || heights[0] != 2 || heights[1] != 4 {
+		test.Errorf("fraud proofs should be found at heights 2 and 4, found at %v", heights)
+	}
+	if !bytes.Equal(blockchain.StateRoot(), stateRoot) {
+		test.Error("state of the blockchain should be left untouched")
+	}
+
+	// stop at the first fraud proof
+	heights = nil
+	err = blockchain.VerifyStream(func(height uint64, fp *FraudProof) bool {
+		heights = append(heights, height)
+		return false
+	})
+	if err != nil || len(heights) != 1 {
+		test.Error("should stop at the first fraud proof")
+	}
+}
+
+func TestPartialStateTree(test *testing.T) {
+	// fill the state with keys the block does not touch
+	t, stateTree := generateBlockInput(10000)
+	for i := 0; i < 20; i++ {
+		key := make([]byte, 32)
+		rand.Read(key)
+		t[i] = *t[i].With(WithWriteKeys(key))
+	}
+	if _, _, err := fillStateTree(t[:20], stateTree, RawEncoding); err != nil {
+		test.Fatal(err)
+	}
+	preStateRoot := append([]byte{}, stateTree.Root()...)
+	goodBlock, err := NewBlock(t[20:30], stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	stateTree.SetRoot(preStateRoot)
+	badBlock := corruptBlockInterState(copyBlock(goodBlock), 1)
+
+	for _, b := range []*Block{goodBlock, badBlock} {
+		partial, err := b.PartialStateTree(stateTree)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if !bytes.Equal(partial.Root(), preStateRoot) {
+			test.Fatal("partial state tree should have the root of the state")
+		}
+		fp, err := b.CheckBlock(partial)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if b == goodBlock {
+			if fp != nil {
+				test.Error("good block should not generate a fraud proof")
+			}
+			continue
+		}
+		if fp == nil || !badBlock.VerifyFraudProof(*fp) {
+			test.Error("bad block should generate a valid fraud proof")
+		}
+	}
+
+	// a block touching keys missing from the partial state tree cannot be checked
+	other, err := NewBlock(t[:20], smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil {
+		test.Fatal(err)
+	}
+	partial, err := goodBlock.PartialStateTree(stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if _, err := other.CheckBlock(partial); err != ErrMissingStateKey {
+		test.Errorf("should return ErrMissingStateKey, returned %v", err)
+	}
+
+	// the partial state tree hashes with the hash function of the block
+	t, _ = generateBlockInput(10000)
+	stateTree = smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha256.New())
+	preStateRoot = append([]byte{}, stateTree.Root()...)
+	block, err := NewBlock(t, stateTree, WithHashAlgorithm(HashSHA256))
+	if err != nil {
+		test.Fatal(err)
+	}
+	stateTree.SetRoot(preStateRoot)
+	partial, err = block.PartialStateTree(stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if fp, err := block.CheckBlock(partial); err != nil || fp != nil {
+		test.Errorf("block hashing with SHA-256 should check against its partial state tree, returned %v", err)
+	}
+}
+
+func TestMatchesStateRoot(test *testing.T) {
+	t, stateTree := generateBlockInput(10000)
+	b, err := NewBlock(t, stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// compute the state root independently
+	reference := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	for i := 0; i < len(t); i++ {
+		for j := 0; j < len(t[i].writeKeys); j++ {
+			if _, err := reference.Update(t[i].writeKeys[j], stateValue(t[i].newData[j])); err != nil {
+				test.Fatal(err)
+			}
+		}
+	}
+	if !b.MatchesStateRoot(reference.Root()) {
+		test.Error("block should match the state root computed independently")
+	}
+	if b.MatchesStateRoot(b.preStateRoot) || b.MatchesStateRoot(nil) {
+		test.Error("block should not match another state root")
+	}
+}
+
+func TestCheckBlockProperties(test *testing.T) {
+	config := &quick.Config{MaxCount: 25}
+
+	// a good block generates no fraud proof
+	good := func(rb randomBlock) bool {
+		b, err := NewBlock(rb.t, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()),
+			WithChunkSize(rb.chunkSize))
+		if err != nil {
+			return false
+		}
+		fp, err := b.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+		return err == nil && fp == nil
+	}
+	if err := quick.Check(good, config); err != nil {
+		test.Error(err)
+	}
+
+	// a block with a corrupted intermediate state root (or state root) generates a valid fraud proof
+	bad := func(rb randomBlock) bool {
+		goodBlock, err := NewBlock(rb.t, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()),
+			WithChunkSize(rb.chunkSize))
+		if err != nil {
+			return false
+		}
+		badBlock := copyBlock(goodBlock)
+		if rb.corrupt < len(goodBlock.interStateRoots) {
+			badBlock = corruptBlockInterState(badBlock, rb.corrupt)
+		} else {
+			badBlock.stateRoot = make([]byte, len(goodBlock.stateRoot))
+		}
+		fp, err := badBlock.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+		if err != nil || fp == nil {
+			return false
+		}
+		deserialized, err := DeserializeFraudProof(fp.Serialize())
+		if err != nil {
+			return false
+		}
+		return badBlock.VerifyFraudProof(*fp) && badBlock.VerifyFraudProof(*deserialized) &&
+			!goodBlock.VerifyFraudProof(*fp)
+	}
+	if err := quick.Check(bad, config); err != nil {
+		test.Error(err)
+	}
+}
+
+func TestFraudProofTxIndex(test *testing.T) {
+	t, _ := generateBlockInput(10000)
+	for _, k := range []int{0, 2, 5} {
+		b, _ := NewBlock(t, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+		b = corruptBlockInterState(b, k)
+		fp, err := b.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+		if err != nil {
+			test.Fatal(err)
+		}
+		if fp == nil || fp.Kind() != KindInvalidStateRoot || fp.TxIndex() != uint64(k*Step+Step-1) {
+			test.Fatal("fraud proof should dispute the last transaction of the corrupted group")
+		}
+		if !b.VerifyFraudProof(*fp) {
+			test.Error("fraud proof should check")
+		}
+
+		// the chunks must hold the disputed group
+		for _, txIndex := range []uint64{fp.txIndex - 1, fp.txIndex + uint64(Step), uint64(len(t) + Step - 1)} {
+			moved := fp.Copy()
+			moved.txIndex = txIndex
+			if b.VerifyFraudProof(*moved) {
+				test.Errorf("fraud proof should not check with the index %d", txIndex)
+			}
+		}
+	}
+}
+
+func TestTransitionRoots(test *testing.T) {
+	t, _ := generateBlockInput(10000)
+	goodBlock, _ := NewBlock(t, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	badBlock, _ := NewBlock(t, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	badBlock = corruptBlockInterState(badBlock, 3)
+	sb, err := badBlock.Sparse(3*Step, 4*Step)
+	if err != nil {
+		test.Fatal(err)
+	}
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	if _, _, err := fillStateTree(t[:3*Step], stateTree, RawEncoding); err != nil {
+		test.Fatal(err)
+	}
+	fp, err := sb.CheckBlock(stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if fp == nil || !bytes.Equal(fp.stateRoot, badBlock.interStateRoots[2]) ||
+		!bytes.Equal(fp.postStateRoot, badBlock.interStateRoots[3]) {
+		test.Fatal("fraud proof should hold the intermediate state roots surrounding the disputed group")
+	}
+	deserialized, err := DeserializeFraudProof(fp.Serialize())
+	if err != nil {
+		test.Fatal(err)
+	}
+	if !deserialized.Equal(fp) || !fp.VerifyTransition() || !badBlock.VerifyFraudProof(*fp) {
+		test.Error("fraud proof should check")
+	}
+
+	// the roots must be the ones claimed by the block
+	mismatched := fp.Copy()
+	mismatched.postStateRoot = goodBlock.interStateRoots[3]
+	if mismatched.VerifyTransition() || badBlock.VerifyFraudProof(*mismatched) {
+		test.Error("fraud proof with a mismatched post-state root should not check")
+	}
+	mismatched = fp.Copy()
+	mismatched.stateRoot = goodBlock.preStateRoot
+	if mismatched.VerifyTransition() || badBlock.VerifyFraudProof(*mismatched) {
+		test.Error("fraud proof with a mismatched pre-state root should not check")
+	}
+}
+
+func TestLazyDataTree(test *testing.T) {
+	t, _ := generateBlockInput(10000)
+	b, _ := NewBlock(t, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	stored, err := DeserializeBlock(b.Serialize())
+	if err != nil {
+		test.Fatal(err)
+	}
+	if stored.dataTree != nil {
+		test.Error("data tree should not be built when the block is deserialized")
+	}
+	fp, err := stored.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil || fp != nil {
+		test.Fatal("good block should not generate a fraud proof")
+	}
+	if stored.dataTree != nil {
+		test.Error("data tree should not be built to check a good block")
+	}
+
+	// the data tree is built on the first proof, and reused afterwards
+	chunks, _, _, err := stored.ChunksForTransaction(1)
+	if err != nil {
+		test.Fatal(err)
+	}
+	tree := stored.dataTree
+	if tree == nil {
+		test.Fatal("data tree should be built once a proof is requested")
+	}
+	expected, _, _, _ := b.ChunksForTransaction(1)
+	if !equalSlices(chunks, expected) {
+		test.Error("chunks should be the ones of the block")
+	}
+	if _, _, _, err := stored.ChunksForTransaction(2); err != nil || stored.dataTree != tree {
+		test.Error("data tree should be built only once")
+	}
+}
+
+func TestCheckpoint(test *testing.T) {
+	// sync three blocks, each using the next nonce of the sender
+	sender := []byte("alice")
+	store := smt.NewSimpleMap()
+	synced := NewBlockchainWithStore(store)
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	var blocks []*Block
+	for i := uint64(0); i < 4; i++ {
+		link := WithHeight(1)
+		if i > 0 {
+			link = WithParent(blocks[i-1].Header())
+		}
+		b, err := NewBlock(generateNonceBlockInput(sender, []uint64{i}), stateTree, link)
+		if err != nil {
+			test.Fatal(err)
+		}
+		blocks = append(blocks, b)
+	}
+	for _, b := range blocks[:3] {
+		if _, fp, err := synced.Append(b); err != nil || fp != nil {
+			test.Fatal("block should be appended")
+		}
+	}
+
+	// start from the state at height 3, without the first blocks
+	if _, err := NewBlockchainFromCheckpoint(0, synced.StateRoot(), store); err == nil {
+		test.Error("checkpoint should be above the genesis")
+	}
+	blockchain, err := NewBlockchainFromCheckpoint(3, synced.StateRoot(), store)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if blockchain.Len() != 3 || !bytes.Equal(blockchain.StateRoot(), synced.StateRoot()) {
+		test.Fatal("blockchain should start from the checkpoint")
+	}
+	if _, err := blockchain.Block(3); err == nil {
+		test.Error("blocks preceding the checkpoint should not be available")
+	}
+	if _, _, err := blockchain.Append(blocks[2]); err != ErrInvalidParent {
+		test.Errorf("block below the next height should be rejected, returned %v", err)
+	}
+	mismatched := copyBlock(blocks[2])
+	mismatched.height = 4
+	if _, _, err := blockchain.Append(mismatched); err != ErrPreStateRootMismatch {
+		test.Errorf("block with a mismatched pre-state should be rejected, returned %v", err)
+	}
+	height, fp, err := blockchain.Append(blocks[3])
+	if err != nil || fp != nil || height != 4 {
+		test.Fatal("block should be appended on top of the checkpoint")
+	}
+	if b, err := blockchain.Block(4); err != nil || b != blocks[3] {
+		test.Error("appended block should be available")
+	}
+	if blockchain.Rewind(2) == nil {
+		test.Error("should not rewind below the checkpoint")
+	}
+	if err := blockchain.Rewind(3); err != nil || !bytes.Equal(blockchain.StateRoot(), blocks[3].preStateRoot) {
+		test.Error("should rewind to the checkpoint")
+	}
+}
+
+func TestAppendAfterRejectedBlock(test *testing.T) {
+	// start a blockchain from the state following a first block
+	sender := []byte("alice")
+	store := smt.NewSimpleMap()
+	synced := NewBlockchainWithStore(store)
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	first, err := NewBlock(generateNonceBlockInput(sender, []uint64{0}), stateTree, WithHeight(1))
+	if err != nil {
+		test.Fatal(err)
+	}
+	if _, fp, err := synced.Append(first); err != nil || fp != nil {
+		test.Fatal("block should be appended")
+	}
+	blockchain, err := NewBlockchainFromCheckpoint(1, synced.StateRoot(), store)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// build a corrupted block and an honest block from the state of the checkpoint
+	stateRoot := append([]byte{}, stateTree.Root()...)
+	forged, err := NewBlock(generateNonceBlockInput(sender, []uint64{1, 2}), stateTree, WithParent(first.Header()))
+	if err != nil {
+		test.Fatal(err)
+	}
+	badBlock := corruptBlockInterStates(forged)
+	stateTree.SetRoot(stateRoot)
+	goodBlock, err := NewBlock(generateNonceBlockInput(sender, []uint64{1, 2}), stateTree, WithParent(first.Header()))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// rejecting the corrupted block leaves the state of the blockchain untouched
+	if _, fp, err := blockchain.Append(badBlock); err != nil || fp == nil {
+		test.Fatal("corrupted block should be rejected with a fraud proof")
+	}
+	if !bytes.Equal(blockchain.StateRoot(), stateRoot) {
+		test.Error("state should be restored after a rejected block")
+	}
+	if err := blockchain.AppendWithProof(badBlock, FraudProof{}); err != ErrProvenInvalid {
+		test.Errorf("corrupted block should be proven invalid, returned %v", err)
+	}
+	if !bytes.Equal(blockchain.StateRoot(), stateRoot) {
+		test.Error("state should be restored after a block rejected by AppendWithProof")
+	}
+	height, fp, err := blockchain.Append(goodBlock)
+	if err != nil || fp != nil || height != 2 {
+		test.Errorf("honest block should be appended after a rejected block, returned %v", err)
+	}
+}
+
+func TestInconsistentReadFraudProof(test *testing.T) {
+	// the second transaction reads the key it writes, but not the value it overwrites
+	key := []byte("key")
+	first, _ := NewTransaction([][]byte{key}, [][]byte{[]byte("v1")}, [][]byte{{}}, [][]byte{key}, [][]byte{{}},
+		[]byte{})
+	good, _ := NewTransaction([][]byte{key}, [][]byte{[]byte("v2")}, [][]byte{[]byte("v1")}, [][]byte{key},
+		[][]byte{[]byte("v1")}, []byte{})
+	bad := good.With()
+	bad.readData = [][]byte{[]byte("v0")}
+
+	_, err := NewBlock([]Transaction{*first, *bad}, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()),
+		WithCheckReads(true))
+	if err != ErrInconsistentRead {
+		test.Errorf("should return ErrInconsistentRead, returned %v", err)
+	}
+	goodBlock, err := NewBlock([]Transaction{*first, *good},
+		smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()), WithCheckReads(true))
+	if err != nil {
+		test.Fatal(err)
+	}
+	badBlock, err := forgeBlock([]Transaction{*first, *bad})
+	if err != nil {
+		test.Fatal(err)
+	}
+	badBlock.checkReads = true
+
+	fp, err := badBlock.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil {
+		test.Fatal(err)
+	}
+	if fp == nil || fp.Kind() != KindInconsistentRead || fp.txIndex != 1 {
+		test.Fatal("should generate an inconsistent read fraud proof")
+	}
+	deserialized, err := DeserializeFraudProof(fp.Serialize())
+	if err != nil {
+		test.Fatal(err)
+	}
+	if !badBlock.VerifyFraudProof(*fp) || !badBlock.VerifyFraudProof(*deserialized) {
+		test.Error("fraud proof should check")
+	}
+	if goodBlock.VerifyFraudProof(*fp) {
+		test.Error("fraud proof should not check against another block")
+	}
+	badBlock.checkReads = false
+	if badBlock.VerifyFraudProof(*fp) {
+		test.Error("fraud proof should not check against a block not checking reads")
+	}
+}
+
+func TestStrictMode(test *testing.T) {
+	writeKeys, newData, oldData, readKeys, readData, _ := generateTransactionInput()
+	memo, err := NewTransaction(writeKeys, newData, oldData, readKeys, readData, []byte("memo"))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// the memo is serialized, along with the other flags
+	for _, tx := range []*Transaction{memo, memo.With(WithReadFromPreBlock(true))} {
+		deserialized, err := Deserialize(tx.Serialize())
+		if err != nil {
+			test.Fatal(err)
+		}
+		if !bytes.Equal(deserialized.arbitrary, tx.arbitrary) || deserialized.readFromPreBlock != tx.readFromPreBlock ||
+			!bytes.Equal(deserialized.Hash(), tx.Hash()) {
+			test.Error("deserialized transaction should carry the memo")
+		}
+	}
+	if bytes.Equal(memo.Hash(), memo.With(func(t *Transaction) { t.arbitrary = []byte{} }).Hash()) {
+		test.Error("memo should be part of the hash of the transaction")
+	}
+
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	if _, err := NewBlock([]Transaction{*memo}, stateTree); err != nil {
+		test.Error("memo-carrying transaction should be accepted in normal mode")
+	}
+	_, err = NewBlock([]Transaction{*memo}, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()),
+		WithStrictMode(true))
+	if err != ErrArbitraryData {
+		test.Errorf("memo-carrying transaction should be rejected in strict mode, returned %v", err)
+	}
+}
+
+func TestReadOnlyKeys(test *testing.T) {
+	// a and b are only read (b twice), c is read and written by different transactions, d is only written
+	a, b, c, d := []byte("a"), []byte("b"), []byte("c"), []byte("d")
+	first, _ := NewTransaction([][]byte{c, d}, [][]byte{{1}, {2}}, [][]byte{{}, {}}, [][]byte{b, a}, [][]byte{{}, {}},
+		[]byte{})
+	second, _ := NewTransaction([][]byte{d}, [][]byte{{3}}, [][]byte{{2}}, [][]byte{b}, [][]byte{{}}, []byte{})
+	third, _ := NewTransaction([][]byte{d}, [][]byte{{4}}, [][]byte{{3}}, [][]byte{c}, [][]byte{{1}}, []byte{})
+	block, err := NewBlock([]Transaction{*first, *second, *third},
+		smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil {
+		test.Fatal(err)
+	}
+	if keys := block.ReadOnlyKeys(); !equalSlices(keys, [][]byte{a, b}) {
+		test.Errorf("read-only keys should be a and b, got %q", keys)
+	}
+}
+
+func TestBrokenDataTree(test *testing.T) {
+	block, _ := NewBlock(generateBlockInput(10000))
+	block = corruptBlockInterStates(block)
+	tree, err := block.ensureDataTree()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// a data tree missing its last chunk, and one with a tampered chunk
+	tampered := copySlices(tree.chunks)
+	tampered[0][1] ^= 0xff
+	for _, chunks := range [][][]byte{tree.chunks[:len(tree.chunks)-1], tampered} {
+		block.dataTree = &dataTree{chunks: chunks, positions: tree.positions}
+		fp, err := block.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+		if err != ErrInvalidChunkProof || fp != nil {
+			test.Errorf("broken data tree should return ErrInvalidChunkProof and no fraud proof, got %v", err)
+		}
+	}
+
+	block.dataTree = tree
+	fp, err := block.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil || fp == nil {
+		test.Fatal("bad block should generate a fraud proof once the data tree is restored")
+	}
+}
+
+func TestAddTransaction(test *testing.T) {
+	t, _ := generateBlockInput(10000)
+	t = t[:2*Step+1] // the added transaction completes a group
+
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	block, err := NewBlock(t[:len(t)-1], stateTree, WithTimestamp(1))
+	if err != nil {
+		test.Fatal(err)
+	}
+	err = block.AddTransaction(t[len(t)-1], stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	expected, err := NewBlock(t, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()), WithTimestamp(1))
+	if err != nil {
+		test.Fatal(err)
+	}
+	if !bytes.Equal(block.dataRoot, expected.dataRoot) || !bytes.Equal(block.stateRoot, expected.stateRoot) ||
+		!equalSlices(block.interStateRoots, expected.interStateRoots) || !bytes.Equal(block.Hash(), expected.Hash()) {
+		test.Error("block should be the same as the one created with every transaction")
+	}
+	if !bytes.Equal(stateTree.Root(), block.stateRoot) {
+		test.Error("state tree should hold the state following the added transaction")
+	}
+	fp, err := block.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil || fp != nil {
+		test.Error("block should be valid after adding a transaction")
+	}
+
+	// an invalid transaction leaves the block and the state tree unchanged
+	dataRoot := block.dataRoot
+	bad := t[0].With(WithWriteKeys(t[0].writeKeys[1:]...))
+	if block.AddTransaction(*bad, stateTree) == nil {
+		test.Error("invalid transaction should not be added")
+	}
+	if len(block.transactions) != len(t) || !bytes.Equal(block.dataRoot, dataRoot) ||
+		!bytes.Equal(stateTree.Root(), block.stateRoot) {
+		test.Error("failing to add a transaction should leave the block and the state tree unchanged")
+	}
+}
+
+func TestBalanceDelta(test *testing.T) {
+	// alice goes from 200 to 100, bob from 200 to 300, and carol's new balance does not fit in an int64
+	keys := [][]byte{[]byte("alice"), []byte("bob"), []byte("carol")}
+	oldData := [][]byte{{0x00, 0xc8}, {0x00, 0xc8}, {0x01}}
+	t, err := NewTransaction(keys, [][]byte{{0x64}, {0x01, 0x2c}, {0x01, 0, 0, 0, 0, 0, 0, 0, 0}}, oldData, keys,
+		oldData, []byte{})
+	if err != nil {
+		test.Fatal(err)
+	}
+	deltas := t.BalanceDelta()
+	if len(deltas) != 2 || deltas["alice"] != -100 || deltas["bob"] != 100 {
+		test.Errorf("deltas should be -100 for alice and 100 for bob, got %v", deltas)
+	}
+	if _, ok := deltas["carol"]; ok {
+		test.Error("balance that does not fit in an int64 should be skipped")
+	}
+}
+
+func TestTooManyTransactionsFraudProof(test *testing.T) {
+	block, _ := NewBlock(generateBlockInput(10000))
+	header := block.Header()
+	count := header.NumTransactions()
+	fp, err := block.CheckMaxTransactions(count)
+	if err != nil || fp != nil {
+		test.Fatal("block within the cap should not generate a fraud proof")
+	}
+
+	// the cap falls within a group, so that the first transaction over it may start in any chunk
+	max := count - 3
+	fp, err = block.CheckMaxTransactions(max)
+	if err != nil || fp == nil {
+		test.Fatal("block over the cap should generate a fraud proof")
+	}
+	if !header.VerifyTooManyTransactionsFraudProof(*fp, max) {
+		test.Error("fraud proof should verify against the header")
+	}
+	deserialized, err := DeserializeFraudProof(fp.Serialize())
+	if err != nil || !header.VerifyTooManyTransactionsFraudProof(*deserialized, max) {
+		test.Error("deserialized fraud proof should verify against the header")
+	}
+	if header.VerifyTooManyTransactionsFraudProof(*fp, max+1) ||
+		header.VerifyTooManyTransactionsFraudProof(*fp, count) {
+		test.Error("fraud proof should not verify against a larger cap")
+	}
+	other, _ := NewBlock(generateBlockInput(20000))
+	if other.Header().VerifyTooManyTransactionsFraudProof(*fp, max) {
+		test.Error("fraud proof should not verify against the header of another block")
+	}
+
+	// the chunks must hold the transaction over the cap, counted from the start of the data
+	smaller, err := block.CheckMaxTransactions(max - 4)
+	if err != nil || smaller == nil {
+		test.Fatal("block over a smaller cap should generate a fraud proof")
+	}
+	smaller.txIndex = max
+	if header.VerifyTooManyTransactionsFraudProof(*smaller, max) {
+		test.Error("fraud proof not reaching the transaction over the cap should not verify")
+	}
+	chunks, chunksIndexes, proofChunks, err := block.ChunksForTransaction(int(max))
+	if err != nil {
+		test.Fatal(err)
+	}
+	single := fp.Copy()
+	single.chunks, single.chunksIndexes, single.proofChunks = chunks[:1], chunksIndexes[:1], proofChunks[:1]
+	if header.VerifyTooManyTransactionsFraudProof(*single, max) {
+		test.Error("fraud proof only revealing the chunk of the transaction over the cap should not verify")
+	}
+
+	// the chunks are checked against the data tree scheme of the header
+	transactions, stateTree := generateBlockInput(10000)
+	prefixed, _ := NewBlock(transactions, stateTree, WithDataTree(DataTreeScheme{New: newPrefixedTree,
+		VerifyProof: verifyPrefixedProof}))
+	prefixedFp, err := prefixed.CheckMaxTransactions(max)
+	if err != nil || prefixedFp == nil {
+		test.Fatal("block over the cap should generate a fraud proof")
+	}
+	if !prefixed.Header().VerifyTooManyTransactionsFraudProof(*prefixedFp, max) {
+		test.Error("fraud proof should verify against the header of a block with another data tree scheme")
+	}
+	deserializedHeader, err := DeserializeBlockHeader(prefixed.Header().Serialize())
+	if err != nil || deserializedHeader.VerifyTooManyTransactionsFraudProof(*prefixedFp, max) {
+		test.Error("fraud proof should not verify against the default data tree scheme")
+	}
+
+	// the generic verifier takes the cap from the verification policy
+	if block.VerifyFraudProof(*fp) {
+		test.Error("fraud proof should not verify without a cap")
+	}
+	ok, err := block.VerifyFraudProofWithPolicy(*fp, VerifyPolicy{MaxTransactions: max})
+	if err != nil || !ok {
+		test.Error("fraud proof should verify under a policy with the cap")
+	}
+	transactions, stateTree = generateBlockInput(10000)
+	capped, _ := NewBlock(transactions, stateTree, WithVerifyPolicy(VerifyPolicy{MaxTransactions: max}))
+	fp, err = capped.CheckMaxTransactions(max)
+	if err != nil || fp == nil || !capped.VerifyFraudProof(*fp) {
+		test.Error("fraud proof should verify under the policy of the block")
+	}
+}
+
+func TestGoldenSerialization(test *testing.T) {
+	// the fixtures are hardcoded, so that they do not depend on the state tree or on the time
+	tx := goldenTransaction()
+	block := &Block{dataRoot: bytes.Repeat([]byte{0xd1}, 32), stateRoot: bytes.Repeat([]byte{0x51}, 32),
+		transactions: []Transaction{tx, tx}, numTransactions: 2, height: 7, parentHash: bytes.Repeat([]byte{0xa7}, 32),
+		timestamp: 1500000000,
+		preStateRoot: bytes.Repeat([]byte{0x50}, 32), writeKeysRoot: bytes.Repeat([]byte{0x3c}, 32),
+		interStateRoots: [][]byte{bytes.Repeat([]byte{0x52}, 32)}, chunkSize: chunksSize, checkReads: true,
+		newHash: sha512.New512_256}
+	fp := &FraudProof{kind: KindInvalidInterStateRoot, writeKeys: [][]byte{[]byte("key")}, oldData: [][]byte{{0x01}},
+		readKeys: [][]byte{}, readData: [][]byte{},
+		proofState: []smt.SparseCompactMerkleProof{{bytes.Repeat([]byte{0x9f}, 32), {0x01}}},
+		chunks: [][]byte{{0x00, 0x01, 0x02}},
+		proofChunks: [][][]byte{{{0x00, 0x01, 0x02}, bytes.Repeat([]byte{0xc4}, 32)}},
+		txIndex: 1, stateRoot: bytes.Repeat([]byte{0x50}, 32), postStateRoot: bytes.Repeat([]byte{0x52}, 32),
+		chunkSize: 3, chunksIndexes: []uint64{0}, numOfLeaves: 2, offset: 1}
+
+	for _, golden := range []struct {
+		name        string
+		serialized  []byte
+		deserialize func([]byte) ([]byte, error)
+	}{
+		{"transaction", tx.Serialize(), func(buff []byte) ([]byte, error) {
+			t, err := Deserialize(buff)
+			if err != nil {
+				return nil, err
+			}
+			return t.Serialize(), nil
+		}},
+		{"block", block.Serialize(), func(buff []byte) ([]byte, error) {
+			b, err := DeserializeBlock(buff)
+			if err != nil {
+				return nil, err
+			}
+			return b.Serialize(), nil
+		}},
+		{"fraudproof", fp.Serialize(), func(buff []byte) ([]byte, error) {
+			fp, err := DeserializeFraudProof(buff)
+			if err != nil {
+				return nil, err
+			}
+			return fp.Serialize(), nil
+		}},
+	} {
+		path := filepath.Join("testdata", golden.name+".golden")
+		if *updateGolden {
+			err := os.WriteFile(path, []byte(hex.EncodeToString(golden.serialized)+"\n"), 0644)
+			if err != nil {
+				test.Fatal(err)
+			}
+		}
+		fixture, err := os.ReadFile(path)
+		if err != nil {
+			test.Fatal(err)
+		}
+		expected, err := hex.DecodeString(strings.TrimSpace(string(fixture)))
+		if err != nil {
+			test.Fatal(err)
+		}
+		if !bytes.Equal(golden.serialized, expected) {
+			test.Errorf("serialized %s does not match %s (run the test with -update if the change is intended)",
+				golden.name, path)
+		}
+		reserialized, err := golden.deserialize(expected)
+		if err != nil || !bytes.Equal(reserialized, expected) {
+			test.Errorf("%s should deserialize and serialize back to %s", golden.name, path)
+		}
+	}
+}
+
+func TestAppendWithProof(test *testing.T) {
+	goodTransaction, stateTree := generateBlockInput(10000)
+	goodBlock, _ := NewBlock(goodTransaction, stateTree, WithHeight(1))
+	badBlock := corruptBlockInterStates(copyBlock(goodBlock))
+	fp, err := badBlock.CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil || fp == nil {
+		test.Fatal("bad block should generate a fraud proof")
+	}
+
+	// the bad block is refused with its fraud proof, without being checked
+	bc := NewBlockchain()
+	if bc.AppendWithProof(badBlock, *fp) != ErrProvenInvalid {
+		test.Fatal("bad block should be refused with its fraud proof")
+	}
+	if bc.Len() != 0 || bc.Stats().Checks != 0 {
+		test.Error("bad block should be refused without being checked")
+	}
+	if proofs := bc.RejectedProofs(); len(proofs) != 1 || !proofs[0].Equal(fp) {
+		test.Error("fraud proof of the refused block should be recorded")
+	}
+
+	// a fraud proof that does not verify against the block does not prevent it from being checked and appended
+	err = bc.AppendWithProof(goodBlock, *fp)
+	if err != nil || bc.Len() != 1 || len(bc.RejectedProofs()) != 1 {
+		test.Error("good block should be appended despite an invalid fraud proof")
+	}
+}
+
+func TestStateTreeStats(test *testing.T) {
+	tree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	keys := [][]byte{[]byte("alice"), []byte("bob"), []byte("carol")}
+	tree.Update(keys[0], []byte{1})
+	numKeys, maxDepth := StateTreeStats(tree, keys)
+	if numKeys != 1 || maxDepth != 0 {
+		test.Errorf("a single key should be at depth 0, got %d keys at depth %d", numKeys, maxDepth)
+	}
+
+	// the leaves of two keys are one level below the common prefix of their paths
+	tree.Update(keys[1], []byte{2})
+	alice, bob := sha512.Sum512_256(keys[0]), sha512.Sum512_256(keys[1])
+	prefix := 0
+	for prefix < 256 && alice[prefix/8]&(1<<uint(7-prefix%8)) == bob[prefix/8]&(1<<uint(7-prefix%8)) {
+		prefix++
+	}
+	numKeys, maxDepth = StateTreeStats(tree, keys)
+	if numKeys != 2 || maxDepth != prefix+1 {
+		test.Errorf("expected 2 keys at depth %d, got %d keys at depth %d", prefix+1, numKeys, maxDepth)
+	}
+
+	tree.Update(keys[2], []byte{3})
+	numKeys, _ = StateTreeStats(tree, append(keys, []byte("dave")))
+	if numKeys != 3 {
+		test.Errorf("expected 3 keys, got %d", numKeys)
+	}
+}
+
+func TestConcurrentVerification(test *testing.T) {
+	block, _ := NewBlock(generateBlockInput(10000))
+	badBlock := corruptBlockInterStates(block)
+	fp, err := copyBlock(badBlock).CheckBlock(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil || fp == nil {
+		test.Fatal("bad block should generate a fraud proof")
+	}
+
+	// the data tree of the stored block is built by the first verification, in whichever goroutine (run with -race)
+	stored := copyBlock(badBlock)
+	results, start := make(chan bool), make(chan struct{})
+	for i := 0; i < 8; i++ {
+		go func() {
+			<-start
+			results <- stored.VerifyFraudProof(*fp)
+		}()
+	}
+	close(start)
+	for i := 0; i < 8; i++ {
+		if !<-results {
+			test.Error("fraud proof should verify from every goroutine")
+		}
+	}
+	if stored.dataTree == nil {
+		test.Error("data tree should be built by the verifications")
+	}
+}
+
+func TestCheckBlockVerbose(test *testing.T) {
+	goodBlock, _ := NewBlock(generateBlockInput(10000))
+	fp, tx, err := goodBlock.CheckBlockVerbose(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil || fp != nil || tx != nil {
+		test.Error("good block should return neither a fraud proof nor a transaction")
+	}
+
+	// the third transaction reuses the nonce of the second one
+	badBlock, err := forgeBlock(generateNonceBlockInput([]byte("alice"), []uint64{0, 1, 1, 2}))
+	if err != nil {
+		test.Fatal(err)
+	}
+	fp, tx, err = badBlock.CheckBlockVerbose(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil || fp == nil || tx == nil {
+		test.Fatal("bad block should return a fraud proof and the disputed transaction")
+	}
+	if !bytes.Equal(tx.Hash(), badBlock.transactions[2].Hash()) || tx.nonce != 1 {
+		test.Error("disputed transaction should be the third one")
+	}
+}
+
+func TestVerifyPrunedFraudProof(test *testing.T) {
+	// append three blocks, then corrupt the second one and prove it invalid before pruning the blockchain
+	sender := []byte("alice")
+	blockchain := NewBlockchain()
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	var blocks []*Block
+	for i := uint64(0); i < 3; i++ {
+		b, err := NewBlock(generateNonceBlockInput(sender, []uint64{2 * i, 2*i + 1}), stateTree, extending(blockchain))
+		if err != nil {
+			test.Fatal(err)
+		}
+		_, fp, err := blockchain.Append(b)
+		if err != nil || fp != nil {
+			test.Fatal("block should be appended")
+		}
+		blocks = append(blocks, b)
+	}
+	blocks[1].dataRoot = corruptBlockInterState(blocks[1], 0).dataRoot
+	var proof *FraudProof
+	err := blockchain.VerifyStream(func(height uint64, fp *FraudProof) bool {
+		proof = fp
+		return false
+	})
+	if err != nil || proof == nil {
+		test.Fatal("corrupted block should generate a fraud proof")
+	}
+
+	if blockchain.Prune(4) == nil {
+		test.Error("blocks above the last one should not be pruned")
+	}
+	err = blockchain.Prune(3)
+	if err != nil {
+		test.Fatal(err)
+	}
+	for _, b := range blocks {
+		if b.transactions != nil || len(b.transactionHashes) != 2 {
+			test.Error("pruned blocks should only keep the hashes of their transactions")
+		}
+	}
+	blockchain.stateTree = smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()) // nor their state
+	if !blockchain.VerifyFraudProof(2, *proof) {
+		test.Error("fraud proof should verify against the pruned block")
+	}
+	if blockchain.VerifyFraudProof(1, *proof) || blockchain.VerifyFraudProof(3, *proof) {
+		test.Error("fraud proof should not verify against other blocks")
+	}
+	err = blockchain.VerifyStream(func(uint64, *FraudProof) bool {
+		test.Error("pruned blocks should not be checked again")
+		return false
+	})
+	if err != nil {
+		test.Error(err)
+	}
+}
+
+func TestTransactionExpiry(test *testing.T) {
+	writeKeys, newData, oldData, readKeys, readData, _ := generateTransactionInput()
+	tx, err := NewTransaction(writeKeys, newData, oldData, readKeys, readData, []byte{}, WithValidUntil(100))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// the expiry is serialized, along with the other flags
+	for _, t := range []*Transaction{tx, tx.With(WithReadFromPreBlock(true), func(t *Transaction) {
+		t.arbitrary = []byte("memo")
+	})} {
+		deserialized, err := Deserialize(t.Serialize())
+		if err != nil {
+			test.Fatal(err)
+		}
+		if deserialized.validUntil != 100 || !bytes.Equal(deserialized.Hash(), t.Hash()) {
+			test.Error("deserialized transaction should carry its expiry")
+		}
+	}
+	if bytes.Equal(tx.Hash(), tx.With(WithValidUntil(0)).Hash()) {
+		test.Error("expiry should be part of the hash of the transaction")
+	}
+
+	for _, timestamp := range []int64{99, 100} {
+		_, err := NewBlock([]Transaction{*tx}, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()),
+			WithTimestamp(timestamp))
+		if err != nil {
+			test.Errorf("transaction should be accepted in a block at time %d, returned %v", timestamp, err)
+		}
+	}
+	_, err = NewBlock([]Transaction{*tx}, smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()),
+		WithTimestamp(101))
+	if err != ErrExpiredTransaction {
+		test.Errorf("expired transaction should be rejected, returned %v", err)
+	}
+	_, err = NewBlock([]Transaction{*tx.With(WithValidUntil(0))},
+		smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()), WithTimestamp(101))
+	if err != nil {
+		test.Errorf("transaction without expiry should be accepted, returned %v", err)
+	}
+}
+
+func TestInvalidLayoutFraudProof(test *testing.T) {
+	block, _ := NewBlock(generateBlockInput(10000))
+	chunks, _, _ := makeChunks(block.chunkSize, block.transactions, block.interStateRoots)
+	fp, err := block.CheckDataLayout(chunks)
+	if err != nil || fp != nil {
+		test.Fatal("honest layout should not generate a fraud proof")
+	}
+
+	// commit an extra intermediate state root after the third transaction, or drop the first one
+	spurious, missing := *block, *block
+	var withSpurious, withMissing [][]byte
+	for i := 0; i < len(block.transactions); i++ {
+		tx := append([]byte{byte(LeafTransaction)}, block.transactions[i].Serialize()...)
+		withSpurious, withMissing = append(withSpurious, tx), append(withMissing, tx)
+		if (i+1)%Step == 0 {
+			root := append([]byte{byte(LeafStateRoot)}, block.interStateRoots[(i+1)/Step-1]...)
+			withSpurious = append(withSpurious, root)
+			if i+1 != Step {
+				withMissing = append(withMissing, root)
+			}
+		}
+		if i == 2 {
+			withSpurious = append(withSpurious, append([]byte{byte(LeafStateRoot)}, block.stateRoot...))
+		}
+	}
+	for _, c := range []struct {
+		block   *Block
+		entries [][]byte
+	}{{&spurious, withSpurious}, {&missing, withMissing}} {
+		chunks := chunksOfEntries(block.chunkSize, c.entries)
+		c.block.dataRoot = parallelDataRoot(chunks, block.newHash)
+		fp, err := c.block.CheckDataLayout(chunks)
+		if err != nil || fp == nil {
+			test.Fatal("mismatched layout should generate a fraud proof")
+		}
+		if len(fp.chunks) >= len(chunks) {
+			test.Error("fraud proof should only reveal the chunks up to the misplaced entry")
+		}
+		if !c.block.VerifyFraudProof(*fp) {
+			test.Error("fraud proof should verify against the block")
+		}
+		deserialized, err := DeserializeFraudProof(fp.Serialize())
+		if err != nil || !c.block.VerifyFraudProof(*deserialized) {
+			test.Error("deserialized fraud proof should verify against the block")
+		}
+		if block.VerifyFraudProof(*fp) {
+			test.Error("fraud proof should not verify against the honest block")
+		}
+	}
+	if _, err := spurious.CheckDataLayout(chunks); err != ErrInvalidDataRoot {
+		test.Error("chunks not committed by the data root should be rejected")
+	}
+
+	// the proofs of the leading chunks, built in one pass, match the proofs of the data tree
+	for n := 1; n <= 9; n++ {
+		chunks := make([][]byte, n)
+		for i := 0; i < n; i++ {
+			chunks[i] = []byte{byte(i)}
+		}
+		proofs, err := block.proveLeadingChunks(chunks, n)
+		if err != nil {
+			test.Fatal(err)
+		}
+		for j := 0; j < n; j++ {
+			tree := merkletree.New(sha512.New512_256())
+			tree.SetIndex(uint64(j))
+			for i := 0; i < n; i++ {
+				tree.Push(chunks[i])
+			}
+			_, proof, _, _ := tree.Prove()
+			if len(proofs[j]) != len(proof) {
+				test.Fatalf("proof of the chunk %d of %d should have %d elements", j, n, len(proof))
+			}
+			for i := 0; i < len(proof); i++ {
+				if !bytes.Equal(proofs[j][i], proof[i]) {
+					test.Errorf("proof of the chunk %d of %d differs from the proof of the data tree", j, n)
+				}
+			}
+		}
+	}
+
+	// a first group of transactions writing nothing still commits to a full intermediate state root
+	idle, err := NewBlock(generateIdleBlockInput(10000))
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(idle.interStateRoots[0]) != idle.newHash().Size() {
+		test.Error("intermediate state root of a group writing nothing should be a full state root")
+	}
+	chunks, _, _ = makeChunks(idle.chunkSize, idle.transactions, idle.interStateRoots)
+	fp, err = idle.CheckDataLayout(chunks)
+	if err != nil || fp != nil {
+		test.Error("honest layout of a block whose first group writes nothing should not generate a fraud proof")
+	}
+}
+
+func TestCheckBlockAll(test *testing.T) {
+	t, stateTree := generateBlockInput(10000)
+	preStateRoot := append([]byte{}, stateTree.Root()...)
+	goodBlock, err := NewBlock(t, stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	stateTree.SetRoot(preStateRoot)
+	fps, err := goodBlock.CheckBlockAll(stateTree)
+	if err != nil || len(fps) != 0 {
+		test.Fatal("good block should not generate fraud proofs")
+	}
+
+	// corrupt the transitions following the transactions 7 and 3, in this order
+	badBlock := corruptBlockInterState(corruptBlockInterState(goodBlock, 7/Step), 3/Step)
+	stateTree.SetRoot(preStateRoot)
+	fps, err = badBlock.CheckBlockAll(stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(fps) != 2 || fps[0].TxIndex() != 3 || fps[1].TxIndex() != 7 {
+		test.Fatal("fraud proofs should dispute the transactions 3 and 7, in this order")
+	}
+	for _, fp := range fps {
+		if !badBlock.VerifyFraudProof(*fp) {
+			test.Errorf("fraud proof of transaction %d should verify", fp.TxIndex())
+		}
+	}
+	if !bytes.Equal(stateTree.Root(), goodBlock.stateRoot) {
+		test.Error("state tree should hold the state following the block")
+	}
+
+	// an honest block whose first group of transactions writes nothing generates no fraud proof
+	idleTransaction, idleTree := generateIdleBlockInput(10000)
+	idleBlock, err := NewBlock(idleTransaction, idleTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fps, err = idleBlock.CheckBlockAll(smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil || len(fps) != 0 {
+		test.Error("honest block whose first group writes nothing should not generate fraud proofs")
+	}
+}
+
+func TestTransactionCount(test *testing.T) {
+	t, stateTree := generateBlockInput(10000)
+	preStateRoot := append([]byte{}, stateTree.Root()...)
+	block, err := NewBlock(t, stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	header := block.Header()
+	if header.NumTransactions() != uint64(len(t)) {
+		test.Errorf("header should claim %d transactions, claims %d", len(t), header.NumTransactions())
+	}
+	deserialized, err := DeserializeBlockHeader(header.Serialize())
+	if err != nil || deserialized.NumTransactions() != uint64(len(t)) {
+		test.Error("deserialized header should claim the number of transactions")
+	}
+
+	// the count is committed by the hash of the block, and checked against its transactions
+	forged := *block
+	forged.numTransactions++
+	if bytes.Equal(forged.Hash(), block.Hash()) {
+		test.Error("number of transactions should be committed by the hash of the block")
+	}
+	stateTree.SetRoot(preStateRoot)
+	if _, err := forged.CheckBlock(stateTree); err != ErrTransactionCount {
+		test.Errorf("forged count should be detected, returned %v", err)
+	}
+	if err := forged.Validate(); err != ErrTransactionCount {
+		test.Errorf("forged count should be detected without the state, returned %v", err)
+	}
+	if err := forged.WithoutTransactions().Validate(); err != ErrTransactionCount {
+		test.Error("forged count should be detected against the hashes of the transactions")
+	}
+	stateTree.SetRoot(preStateRoot)
+	if fp, err := block.CheckBlock(stateTree); err != nil || fp != nil {
+		test.Error("block claiming its number of transactions should check")
+	}
+}
+
+func TestDataTreeScheme(test *testing.T) {
+	scheme := DataTreeScheme{New: newPrefixedTree, VerifyProof: verifyPrefixedProof}
+	t, stateTree := generateBlockInput(10000)
+	preStateRoot := append([]byte{}, stateTree.Root()...)
+	block, err := NewBlock(t, stateTree, WithDataTree(scheme))
+	if err != nil {
+		test.Fatal(err)
+	}
+	stateTree.SetRoot(preStateRoot)
+	defaultBlock, err := NewBlock(t, stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if bytes.Equal(block.dataRoot, defaultBlock.dataRoot) {
+		test.Fatal("data root should depend on the data tree scheme")
 	}
 
-	// serialize and deserialize
-	buff := goodT.Serialize()
-	t, err := Deserialize(buff)
-	if err != nil {
-		test.Error(err)
-	} else if bytes.Compare(t.Serialize(), buff) != 0 {
-		test.Error("transaction not serialized and deserialize correctly")
+	// corrupt an intermediate state root, committing it with the same scheme
+	bad := *block
+	bad.interStateRoots = copySlices(block.interStateRoots)
+	bad.interStateRoots[0] = bytes.Repeat([]byte{0xba}, len(block.stateRoot))
+	bad.dataTree = nil
+	chunks, _, _ := makeChunks(bad.chunkSize, bad.transactions, bad.interStateRoots)
+	bad.dataRoot = bad.computeDataRoot(chunks)
+	stateTree.SetRoot(preStateRoot)
+	fp, err := bad.CheckBlock(stateTree)
+	if err != nil || fp == nil {
+		test.Fatal("bad block should generate a fraud proof")
+	}
+	if !bad.VerifyFraudProof(*fp) {
+		test.Error("fraud proof should verify with the same scheme")
+	}
+	mismatched := bad
+	mismatched.dataTreeScheme = NebulousDataTree
+	if mismatched.VerifyFraudProof(*fp) {
+		test.Error("fraud proof should not verify with another scheme")
 	}
 }
 
-
-func TestBlock(test *testing.T) {
-	// create bad block (corrupted transactions)
-	_, err :=  NewBlock(generateCorruptedBlockInput())
-	if err == nil {
-		test.Error("should return an error")
+func TestDuplicateTransactionFraudProof(test *testing.T) {
+	t, stateTree := generateBlockInput(10000)
+	block, err := NewBlock(t[:6], stateTree)
+	if err != nil {
+		test.Fatal(err)
+	}
+	fp, err := block.CheckDuplicateTransactions()
+	if err != nil || fp != nil {
+		test.Fatal("block of unique transactions should not generate a fraud proof")
 	}
 
-	// create good block
-	goodTransaction, stateTree := generateBlockInput(1000000)
-	goodBlock, err :=  NewBlock(goodTransaction, stateTree)
+	// replay the second transaction at the end of the block
+	block, err = NewBlock(append(append([]Transaction{}, t[:6]...), t[1]), stateTree)
 	if err != nil {
-		test.Error(err)
+		test.Fatal(err)
+	}
+	fp, err = block.CheckDuplicateTransactions()
+	if err != nil || fp == nil {
+		test.Fatal("block holding a duplicate transaction should generate a fraud proof")
+	}
+	if fp.duplicateIndex != 1 || fp.TxIndex() != 6 {
+		test.Errorf("fraud proof should dispute the transactions 1 and 6, disputes %d and %d", fp.duplicateIndex,
+			fp.TxIndex())
 	}
 
-	// check good block
-	_, err = goodBlock.CheckBlock(stateTree)
+	// the copies have their own positions in the chunks, and are revealed by their own chunks
+	tree, err := block.ensureDataTree()
 	if err != nil {
-		test.Error(err)
+		test.Fatal(err)
+	}
+	positions, size := block.transactionPositions(), block.chunkSize-1
+	for i := len(positions) - 1; i >= 0; i-- {
+		if tree.positions[i] != positions[i] {
+			test.Errorf("transaction %d should be at position %d, is at %d", i, positions[i], tree.positions[i])
+		}
+		if i == 0 || positions[i-1]/size != positions[i]/size {
+			if tree.chunks[positions[i]/size][0] != byte(positions[i]%size) {
+				test.Errorf("chunk %d should point at the transaction %d", positions[i]/size, i)
+			}
+		}
+	}
+	for _, index := range []int{1, 6} {
+		_, chunksIndexes, _, err := block.ChunksForTransaction(index)
+		if err != nil {
+			test.Fatal(err)
+		}
+		if chunksIndexes[0] != uint64(positions[index]/size) {
+			test.Errorf("chunks of the transaction %d should start with the chunk %d", index, positions[index]/size)
+		}
+	}
+	if !block.VerifyFraudProof(*fp) {
+		test.Error("fraud proof should verify")
+	}
+	deserialized, err := DeserializeFraudProof(fp.Serialize())
+	if err != nil || !deserialized.Equal(fp) || !block.VerifyFraudProof(*deserialized) {
+		test.Error("deserialized fraud proof should verify")
+	}
+	for _, indexes := range [][2]uint64{{0, 6}, {2, 6}, {1, 5}, {6, 1}} {
+		forged := fp.Copy()
+		forged.duplicateIndex, forged.txIndex = indexes[0], indexes[1]
+		if block.VerifyFraudProof(*forged) {
+			test.Errorf("fraud proof should not verify for the transactions %d and %d", indexes[0], indexes[1])
+		}
 	}
 
-	// check a bad block (corrupted transactions)
-	badBlock := generateBlockWithCorruptedTransactions()
-	_, err = badBlock.CheckBlock(stateTree)
-	if err == nil {
-		test.Error("should return an error")
+	// the fraud proof of the other pair of transactions has another witness and differs in the duplicate index
+	other := fp.Copy()
+	other.duplicateIndex = 0
+	witness, _ := fp.Witness()
+	otherWitness, _ := other.Witness()
+	if bytes.Equal(witness, otherWitness) {
+		test.Error("witness should commit to the duplicate index")
+	}
+	if diff := FraudProofDiff(fp, other); len(diff) != 1 || diff[0] != "duplicateIndex: 1 != 0" {
+		test.Errorf("diff should only report the duplicate index, got %q", diff)
 	}
 
-	// check bad block (corrupted intermediate state)
-	badBlock = corruptBlockInterStates(goodBlock)
-	goodFp, err := badBlock.CheckBlock(stateTree)
+	// replay a transaction of a later group, so that the chunks start at the preceding intermediate state root
+	block, err = NewBlock(append(append([]Transaction{}, t[:6]...), t[3]), stateTree)
 	if err != nil {
-		test.Error(err)
-	} else if goodFp == nil {
-		test.Error("should return a fraud proof")
+		test.Fatal(err)
+	}
+	fp, err = block.CheckDuplicateTransactions()
+	if err != nil || fp == nil {
+		test.Fatal("block holding a duplicate transaction should generate a fraud proof")
+	}
+	if fp.duplicateIndex != 3 || fp.TxIndex() != 6 || fp.chunksIndexes[0] == 0 && fp.offset == 0 {
+		test.Error("fraud proof should start at the group of the transaction 3")
+	}
+	if !block.VerifyFraudProof(*fp) {
+		test.Error("fraud proof of a later group should verify")
+	}
+	for _, indexes := range [][2]uint64{{2, 6}, {4, 6}, {5, 6}} {
+		forged := fp.Copy()
+		forged.duplicateIndex = indexes[0]
+		if block.VerifyFraudProof(*forged) {
+			test.Errorf("fraud proof should not verify for the transactions %d and %d", indexes[0], indexes[1])
+		}
 	}
+	forged := fp.Copy()
+	forged.offset--
+	if block.VerifyFraudProof(*forged) {
+		test.Error("fraud proof should not verify without the preceding intermediate state root")
+	}
+}
 
-	// verify fraud proof of bad block
-	ret := badBlock.VerifyFraudProof(*goodFp)
-	if ret != true {
-		test.Error("fraud proof does not check")
+func TestHashAlgorithm(test *testing.T) {
+	proofs := make(map[HashAlgorithm]*FraudProof)
+	blocks := make(map[HashAlgorithm]*Block)
+	for algorithm, newHash := range map[HashAlgorithm]func() hash.Hash{
+		HashSHA512_256: sha512.New512_256,
+		HashSHA256:     sha256.New,
+	} {
+		transactions, _ := generateBlockInput(10000)
+		stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), newHash())
+		goodBlock, err := NewBlock(transactions, stateTree, WithHashAlgorithm(algorithm))
+		if err != nil {
+			test.Fatal(err)
+		}
+		badBlock := corruptBlockInterStates(goodBlock)
+		fp, err := badBlock.CheckBlock(stateTree)
+		if err != nil {
+			test.Fatal(err)
+		} else if fp == nil {
+			test.Fatal("should return a fraud proof")
+		}
+
+		// the verifier selects the hash function from the identifier of the fraud proof and of the header
+		received, err := DeserializeFraudProof(fp.Serialize())
+		if err != nil {
+			test.Fatal(err)
+		}
+		if received.HashAlgorithm() != algorithm {
+			test.Error("fraud proof should embed the identifier of its hash function")
+		}
+		receivedBlock, err := DeserializeBlock(badBlock.Serialize())
+		if err != nil {
+			test.Fatal(err)
+		}
+		if receivedBlock.Header().HashAlgorithm() != algorithm {
+			test.Error("header should commit to the identifier of the hash function")
+		}
+		if !receivedBlock.VerifyFraudProof(*received) {
+			test.Error("deserialized fraud proof should verify against the deserialized block")
+		}
+		if !VerifyFraudProofChain([]FraudProof{*received}, []BlockHeader{badBlock.Header()}) {
+			test.Error("deserialized fraud proof should verify against the header")
+		}
+		proofs[algorithm], blocks[algorithm] = received, receivedBlock
 	}
 
-	// verify corrupted fraud proof (corrupted chunks proof)
-	corruptedFp := corruptFraudproofChunks(goodFp)
-	ret = badBlock.VerifyFraudProof(*corruptedFp)
-	if ret != false {
-		test.Error("invalid fraud proof should not check")
+	// a fraud proof tagged with another hash function does not verify
+	mismatched := proofs[HashSHA256].Copy()
+	mismatched.hashAlgorithm = HashSHA512_256
+	if blocks[HashSHA256].VerifyFraudProof(*mismatched) {
+		test.Error("fraud proof tagged with another hash function should not verify")
+	}
+	witness, _ := proofs[HashSHA256].Witness()
+	mismatchedWitness, _ := mismatched.Witness()
+	if len(witness) != len(mismatchedWitness) || bytes.Equal(witness, mismatchedWitness) {
+		test.Error("witness should commit to the identifier of the hash function")
+	}
+	diff := FraudProofDiff(proofs[HashSHA256], mismatched)
+	if len(diff) != 1 || diff[0] != fmt.Sprintf("hashAlgorithm: %d != %d", HashSHA256, HashSHA512_256) {
+		test.Errorf("diff should only report the identifier of the hash function, got %q", diff)
 	}
 
-	// verify corrupted fraud proof (corrupted state proof)
-	corruptedFp = corruptFraudproofState(goodFp)
-	ret = badBlock.VerifyFraudProof(*corruptedFp)
-	if ret != false {
-		test.Error("invalid fraud proof should not check")
+	// unsupported hash functions are rejected with a clear error
+	unsupported := proofs[HashSHA256].Copy()
+	unsupported.hashAlgorithm = 9
+	if err := unsupported.Validate(); err != ErrUnsupportedHashAlgorithm {
+		test.Error("fraud proof with an unsupported hash function should not validate")
+	}
+	if blocks[HashSHA256].VerifyFraudProof(*unsupported) {
+		test.Error("fraud proof with an unsupported hash function should not verify")
+	}
+	if _, err := DeserializeFraudProof(unsupported.Serialize()); err != ErrUnsupportedHashAlgorithm {
+		test.Error("should not deserialize a fraud proof with an unsupported hash function")
+	}
+	transactions, stateTree := generateBlockInput(1000)
+	if _, err := NewBlock(transactions, stateTree, WithHashAlgorithm(9)); err != ErrUnsupportedHashAlgorithm {
+		test.Error("should not create a block with an unsupported hash function")
 	}
 }
 
-func TestBlockchain(test *testing.T) {
-	// add good blocks to blockchain
-	blockchain := NewBlockchain()
-	goodBlock, _ := NewBlock(generateBlockInput(1000000))
-	blockchain.Append(goodBlock) // add a first block
-	fp, err := blockchain.Append(goodBlock) // add a second block
-	if err != nil {
-		test.Error(err)
-	} else if fp != nil {
-		test.Error("should not return a fraud proof")
+func TestShards(test *testing.T) {
+	// conflict-free transactions, some of them sent by distinct senders, over a state holding some of their keys
+	var previous, t []Transaction
+	for i := 0; i < 40; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		if i%3 == 0 {
+			tmp, _ := NewTransaction([][]byte{key}, [][]byte{[]byte("old")}, [][]byte{{}}, [][]byte{key}, [][]byte{{}},
+				[]byte{})
+			previous = append(previous, *tmp)
+		}
+		tmp, _ := NewTransaction([][]byte{key}, [][]byte{[]byte(fmt.Sprintf("new-%d", i))}, [][]byte{{}}, [][]byte{key},
+			[][]byte{{}}, []byte{})
+		if i%4 == 0 {
+			tmp = tmp.With(WithSender([]byte(fmt.Sprintf("sender-%d", i))))
+		}
+		t = append(t, *tmp)
+	}
+	if i, j := Conflicts(t); i >= 0 {
+		test.Fatalf("transactions %d and %d should not conflict", i, j)
 	}
 
-	// add bad block to blockchain (corrupted intermediate state)
-	fp, err = blockchain.Append(corruptBlockInterStates(goodBlock))
+	preStateTree := func() *smt.SparseMerkleTree {
+		stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+		NewBlock(previous, stateTree)
+		return stateTree
+	}
+	sequential, err := NewBlock(t, preStateTree(), WithTimestamp(1))
 	if err != nil {
-		test.Error(err)
-	} else if fp == nil {
-		test.Error("should return a fraud proof")
+		test.Fatal(err)
+	}
+	for _, shards := range []int{2, 3, 16, 256, 1000} {
+		stateTree := preStateTree()
+		sharded, err := NewBlock(t, stateTree, WithTimestamp(1), WithShards(shards))
+		if err != nil {
+			test.Fatal(err)
+		}
+		if !bytes.Equal(sharded.stateRoot, sequential.stateRoot) ||
+			!bytes.Equal(stateTree.Root(), sequential.stateRoot) {
+			test.Errorf("state root of the block built with %d shards does not match the sequential one", shards)
+		}
+		if !bytes.Equal(sharded.Hash(), sequential.Hash()) {
+			test.Errorf("block built with %d shards does not match the sequential one", shards)
+		}
 	}
 
-	// add bad block to blockchain (corrupted transactions)
-	_, err = blockchain.Append(generateBlockWithCorruptedTransactions())
-	if err == nil {
-		test.Error("should return an error")
+	// conflicting transactions are rejected: writing the same key, reading a written key, or sharing a sender
+	write := t[1].With(WithNewData([]byte("other")))
+	read, _ := NewTransaction([][]byte{[]byte("other")}, [][]byte{[]byte("x")}, [][]byte{{}},
+		[][]byte{t[2].writeKeys[0]}, [][]byte{{}}, []byte{})
+	sender := t[3].With(WithSender(t[0].sender))
+	for _, conflicting := range []*Transaction{write, read, sender} {
+		input := append(append([]Transaction{}, t...), *conflicting)
+		if i, j := Conflicts(input); i < 0 || j != len(t) {
+			test.Error("transactions should conflict")
+		}
+		_, err := NewBlock(input, preStateTree(), WithShards(4))
+		if err != ErrConflictingTransactions {
+			test.Errorf("should return ErrConflictingTransactions, returned %v", err)
+		}
 	}
 }
 
@@ -129,8 +4444,9 @@ func TestTiming(test *testing.T) {
 	goodTransaction, stateTree := generateBlockInput(blockSize)
 	goodBlock, err :=  NewBlock(goodTransaction, stateTree)
 	if err != nil {
-		test.Error(err)
+		test.Fatal(err)
 	}
+	fmt.Println("Transactions size: ", goodBlock.Size(), "Bytes")
 
 	// check bad block (corrupted intermediate state)
 	goodBlock = corruptBlockInterStates(goodBlock)
@@ -161,9 +4477,54 @@ func TestTiming(test *testing.T) {
 	fmt.Println("verify proof (average): ", int64(elapsed / time.Microsecond) / int64(runs), "us")
 }
 
+func TestProofSizeVsChunkSize(test *testing.T) {
+	var sizes []int
+	for _, chunkSize := range proofSizeChunkSizes {
+		fp, err := generateFraudProofWithChunkSize(1000000, chunkSize)
+		if err != nil {
+			test.Fatal(err)
+		}
+		sizes = append(sizes, fp.SizeBytes())
+	}
+
+	for i := 1; i < len(sizes); i++ {
+		if sizes[i] >= sizes[i-1] {
+			test.Error("larger chunks should yield smaller fraud proofs")
+		}
+	}
+}
+
+func BenchmarkProofSizeVsChunkSize(b *testing.B) {
+	for _, chunkSize := range proofSizeChunkSizes {
+		b.Run(fmt.Sprintf("chunkSize=%d", chunkSize), func(b *testing.B) {
+			var fp *FraudProof
+			var err error
+			for i := 0; i < b.N; i++ {
+				fp, err = generateFraudProofWithChunkSize(1000000, chunkSize)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(fp.SizeBytes()), "proof-bytes")
+		})
+	}
+}
+
 
 // ------------------ helpers ------------------ //
 
+// goldenTransaction returns a transaction with every field set, serialized in testdata/transaction.golden.
+func goldenTransaction() Transaction {
+	t, err := NewTransaction([][]byte{[]byte("key"), []byte("other key")}, [][]byte{{0x02}, {}},
+		[][]byte{{0x01}, {0x03, 0x04}}, [][]byte{[]byte("key"), []byte("read key")}, [][]byte{{0x01}, {0x05}},
+		[]byte("arbitrary"))
+	if err != nil {
+		panic(err)
+	}
+	return *t.With(WithSender([]byte("alice")), WithNonce(3), WithExpectedRoot(bytes.Repeat([]byte{0xe7}, 32)),
+		WithDependencies(bytes.Repeat([]byte{0xde}, 32)), WithReadFromPreBlock(true))
+}
+
 
 func generateTransactionInput() ([][]byte, [][]byte, [][]byte, [][]byte, [][]byte, []byte) {
 	var writeKeys, newData, oldData, readKeys, readData [][]byte
@@ -218,34 +4579,238 @@ func generateTransactionInput() ([][]byte, [][]byte, [][]byte, [][]byte, [][]byt
 	return writeKeys, newData, oldData, readKeys, readData, []byte{}
 }
 
+func generateTransactionStateProofs(t *Transaction, stateTree *smt.SparseMerkleTree,
+	e StateEncoding) ([]smt.SparseCompactMerkleProof, error) {
+	for i := 0; i < len(t.writeKeys); i++ {
+		_, err := stateTree.Update(t.writeKeys[i], e.encode(t.oldData[i]))
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := 0; i < len(t.readKeys); i++ {
+		_, err := stateTree.Update(t.readKeys[i], e.encode(t.readData[i]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var proofs []smt.SparseCompactMerkleProof
+	for _, key := range append(append([][]byte{}, t.writeKeys...), t.readKeys...) {
+		proof, err := stateTree.ProveCompact(key)
+		if err != nil {
+			return nil, err
+		}
+		proofs = append(proofs, proof)
+	}
+	return proofs, nil
+}
+
 func generateCorruptedTransactionInput() ([][]byte, [][]byte, [][]byte, [][]byte, [][]byte, []byte) {
 	writeKeys, newData, oldData, readKeys, readData, arbitrary := generateTransactionInput()
 	writeKeys = writeKeys[1:]
 	return writeKeys, newData, oldData, readKeys, readData, arbitrary
 }
 
-func corruptTransaction(t *Transaction) (*Transaction) {
-	t.writeKeys = t.writeKeys[1:]
-	return t
+func generateCorruptedReadTransactionInput() ([][]byte, [][]byte, [][]byte, [][]byte, [][]byte, []byte) {
+	writeKeys, newData, oldData, readKeys, readData, arbitrary := generateTransactionInput()
+	readData = readData[1:]
+	return writeKeys, newData, oldData, readKeys, readData, arbitrary
+}
+
+
+// chunksOfEntries splits tagged entries into chunks of the given size, with a zero position byte.
+func chunksOfEntries(chunkSize int, entries [][]byte) [][]byte {
+	var buff []byte
+	for _, entry := range entries {
+		buff = append(buff, entry...)
+	}
+	var chunks [][]byte
+	for len(buff) > 0 {
+		n := chunkSize - 1
+		if n > len(buff) {
+			n = len(buff)
+		}
+		chunks = append(chunks, append([]byte{0x0}, buff[:n]...))
+		buff = buff[n:]
+	}
+	return chunks
+}
+
+// prefixedTree is a DataTree hashing its leaves and nodes with other prefixes than merkletree, so that its roots
+// differ; it splits its leaves the same way.
+type prefixedTree struct {
+	h      hash.Hash
+	leaves [][]byte
+	index  uint64
+}
+
+func newPrefixedTree(h hash.Hash) DataTree {
+	return &prefixedTree{h: h}
+}
+
+func (t *prefixedTree) SetIndex(index uint64) error {
+	t.index = index
+	return nil
+}
+
+func (t *prefixedTree) Push(leaf []byte) {
+	t.leaves = append(t.leaves, leaf)
+}
+
+func (t *prefixedTree) Root() []byte {
+	return prefixedRoot(t.h, t.leaves)
+}
+
+func (t *prefixedTree) Prove() ([]byte, [][]byte, uint64, uint64) {
+	numLeaves := uint64(len(t.leaves))
+	if t.index >= numLeaves {
+		return t.Root(), nil, t.index, numLeaves
+	}
+	var siblings [][]byte // from the root down to the leaf
+	leaves, index := t.leaves, t.index
+	for len(leaves) > 1 {
+		split := prefixedSplit(uint64(len(leaves)))
+		if index < split {
+			siblings, leaves = append(siblings, prefixedRoot(t.h, leaves[split:])), leaves[:split]
+		} else {
+			siblings, leaves, index = append(siblings, prefixedRoot(t.h, leaves[:split])), leaves[split:], index-split
+		}
+	}
+	proof := [][]byte{t.leaves[t.index]}
+	for i := len(siblings) - 1; i >= 0; i-- {
+		proof = append(proof, siblings[i])
+	}
+	return t.Root(), proof, t.index, numLeaves
+}
+
+func verifyPrefixedProof(h hash.Hash, root []byte, proof [][]byte, index uint64, numLeaves uint64) bool {
+	if len(proof) == 0 || index >= numLeaves {
+		return false
+	}
+	var left []bool // whether the node is on the left of its sibling, from the root down to the leaf
+	for n := numLeaves; n > 1; {
+		split := prefixedSplit(n)
+		left = append(left, index < split)
+		if index < split {
+			n = split
+		} else {
+			n, index = n-split, index-split
+		}
+	}
+	if len(proof) != len(left)+1 {
+		return false
+	}
+	sum := prefixedSum(h, 'L', proof[0])
+	for i := 1; i < len(proof); i++ {
+		if left[len(left)-i] {
+			sum = prefixedSum(h, 'N', sum, proof[i])
+		} else {
+			sum = prefixedSum(h, 'N', proof[i], sum)
+		}
+	}
+	return bytes.Equal(sum, root)
+}
+
+func prefixedRoot(h hash.Hash, leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return nil
+	}
+	if len(leaves) == 1 {
+		return prefixedSum(h, 'L', leaves[0])
+	}
+	split := prefixedSplit(uint64(len(leaves)))
+	return prefixedSum(h, 'N', prefixedRoot(h, leaves[:split]), prefixedRoot(h, leaves[split:]))
+}
+
+// prefixedSplit returns the largest power of two strictly smaller than n.
+func prefixedSplit(n uint64) uint64 {
+	split := uint64(1)
+	for split*2 < n {
+		split *= 2
+	}
+	return split
+}
+
+func prefixedSum(h hash.Hash, prefix byte, data ...[]byte) []byte {
+	h.Reset()
+	h.Write([]byte{prefix})
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// extending returns the option linking a block to the last block of the blockchain.
+func extending(bc *Blockchain) BlockOption {
+	header, err := bc.Header(bc.Len())
+	if err != nil {
+		return WithHeight(bc.Len() + 1) // no block is known below the next height
+	}
+	return WithParent(header)
+}
+
+// generateIdleBlockInput returns the input of a block whose first group of transactions writes no keys.
+func generateIdleBlockInput(blockSize int) ([]Transaction, *smt.SparseMerkleTree) {
+	t, stateTree := generateBlockInput(blockSize)
+	for i := 0; i < Step; i++ {
+		tmp, _ := NewTransaction(nil, nil, nil, nil, nil, []byte{byte(i)})
+		t[i] = *tmp
+	}
+	return t, stateTree
 }
 
 func generateBlockInput(blockSize int) ([]Transaction, *smt.SparseMerkleTree) {
-	// average Ethereum transaction size (225B)
-	numTransactions := blockSize / 225 // 4444 transactions for 1MB block
-	t := make([]Transaction, numTransactions)
-	for i := 0; i < len(t); i++ {
+	// fill the block with transactions mimicking average Ethereum transactions
+	var t []Transaction
+	for size := 0; ; {
 		tmp, _ := NewTransaction(generateTransactionInput())
-		t[i] = *tmp
+		if size+tmp.Size() > blockSize {
+			break
+		}
+		size += tmp.Size()
+		t = append(t, *tmp)
 	}
 	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
 	return t, stateTree
 }
 
+func generateNonceBlockInput(sender []byte, nonces []uint64) []Transaction {
+	t := make([]Transaction, len(nonces))
+	for i := 0; i < len(nonces); i++ {
+		tmp, _ := NewTransaction(generateTransactionInput())
+		tmp.sender = sender
+		tmp.nonce = nonces[i]
+		t[i] = *tmp
+	}
+	return t
+}
+
+// forgeBlock builds a block without checking the nonces of its transactions.
+func forgeBlock(t []Transaction) (*Block, error) {
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	b := &Block{chunkSize: chunksSize, newHash: sha512.New512_256, transactions: t, numTransactions: uint64(len(t))}
+	b.preStateRoot = append([]byte{}, stateTree.Root()...)
+	interStateRoots, stateRoot, err := fillStateTree(t, stateTree, RawEncoding)
+	if err != nil {
+		return nil, err
+	}
+	dataTree := merkletree.New(b.newHash())
+	dataRoot, err := fillDataTree(t, interStateRoots, dataTree, b.chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	b.dataRoot = dataRoot
+	b.stateRoot = stateRoot
+	b.interStateRoots = interStateRoots
+	b.writeKeysRoot = writeKeysRoot(writtenKeys(t), b.newHash)
+	return b, nil
+}
+
 func generateCorruptedBlockInput() ([]Transaction, *smt.SparseMerkleTree) {
 	t1, _ := NewTransaction(generateTransactionInput())
 	t2, _ := NewTransaction(generateTransactionInput())
 
-	t1 = corruptTransaction(t1)
+	t1 = t1.With(WithWriteKeys(t1.writeKeys[1:]...))
 
 	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
 	return []Transaction{*t1,*t2}, stateTree
@@ -254,29 +4819,135 @@ func generateCorruptedBlockInput() ([]Transaction, *smt.SparseMerkleTree) {
 func generateBlockWithCorruptedTransactions() (*Block) {
 	block, _ := NewBlock(generateBlockInput(1000000))
 	t := block.transactions[0]
-	block.transactions[0] = *corruptTransaction(&t)
+	block.transactions[0] = *t.With(WithWriteKeys(t.writeKeys[1:]...))
 	return block
 }
 
+func copyBlock(b *Block) *Block {
+	c, err := DeserializeBlock(b.Serialize())
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
 func corruptBlockInterStates(b *Block) (*Block) {
+	return corruptBlockInterState(b, 0)
+}
+
+func corruptBlockInterState(b *Block, i int) (*Block) {
+	h := sha512.New512_256()
+	h.Write([]byte("random"))
+	b.interStateRoots[i] = h.Sum(nil)
+
+	dataTree := merkletree.New(b.newHash())
+	dataRoot, _ := fillDataTree(b.transactions, b.interStateRoots, dataTree, b.chunkSize)
+
+	corrupted := *b
+	corrupted.dataRoot = dataRoot
+	corrupted.prev = nil
+	return &corrupted
+}
+
+// corruptExpectedRoot returns a copy of the block where the i-th transaction expects a wrong state root.
+func corruptExpectedRoot(b *Block, i int) *Block {
+	corrupted := *b
+	corrupted.transactions = append([]Transaction{}, b.transactions...)
 	h := sha512.New512_256()
 	h.Write([]byte("random"))
-	b.interStateRoots[0] = h.Sum(nil)
+	corrupted.transactions[i].expectedRoot = h.Sum(nil)
+	corrupted.dataRoot, _ = fillDataTree(corrupted.transactions, b.interStateRoots, merkletree.New(b.newHash()),
+		b.chunkSize)
+	corrupted.prev = nil
+	return &corrupted
+}
+
+// retagFirstStateRoot returns a copy of the block whose data root commits to its first intermediate state root tagged
+// with the given leaf kind.
+func retagFirstStateRoot(b *Block, kind LeafKind) *Block {
+	chunks, positions, _ := makeChunks(b.chunkSize, b.transactions, b.interStateRoots)
+	position := positions[Step-1] + 1 + len(b.transactions[Step-1].Serialize())
+	size := b.chunkSize - 1
+	chunks[position/size][1+position%size] = byte(kind)
+
+	corrupted := *b
+	corrupted.dataRoot = parallelDataRoot(chunks, b.newHash)
+	corrupted.prev = nil
+	return &corrupted
+}
+
+// randomBlock holds the transactions of a random block, writing (and reading) several keys drawn from a small pool so
+// that they overlap, its chunk size, and the index of an intermediate state root to corrupt (the state root if it is
+// the number of intermediate state roots, which happens only if the last transactions do not complete a group).
+type randomBlock struct {
+	t         []Transaction
+	chunkSize int
+	corrupt   int
+}
+
+func (randomBlock) Generate(r *rand.Rand, size int) reflect.Value {
+	keys := make([][]byte, 8)
+	for i := 0; i < len(keys); i++ {
+		keys[i] = make([]byte, 1+r.Intn(40))
+		r.Read(keys[i])
+	}
+	randomData := func() []byte {
+		data := make([]byte, r.Intn(60))
+		r.Read(data)
+		return data
+	}
+
+	rb := randomBlock{chunkSize: 2 + r.Intn(255)}
+	n := 1 + r.Intn(size+1)
+	for i := 0; i < n; i++ {
+		var writeKeys, newData, oldData, readKeys, readData [][]byte
+		m := 1 + r.Intn(4)
+		for _, j := range r.Perm(len(keys))[:m] {
+			writeKeys, newData, oldData = append(writeKeys, keys[j]), append(newData, randomData()),
+				append(oldData, randomData())
+		}
+		for _, j := range r.Perm(len(keys))[:m] {
+			readKeys, readData = append(readKeys, keys[j]), append(readData, randomData())
+		}
+		t, err := NewTransaction(writeKeys, newData, oldData, readKeys, readData, []byte{})
+		if err != nil {
+			panic(err)
+		}
+		rb.t = append(rb.t, *t)
+	}
+	roots := n / Step
+	if n%Step != 0 {
+		roots++
+	}
+	rb.corrupt = r.Intn(roots)
+	return reflect.ValueOf(rb)
+}
 
-	dataTree := merkletree.New(sha512.New512_256())
-	dataRoot, _ := fillDataTree(b.transactions, b.interStateRoots, dataTree)
+// writeFrame writes a length-prefixed message.
+func writeFrame(w io.Writer, message []byte) error {
+	size := make([]byte, 4)
+	binary.LittleEndian.PutUint32(size, uint32(len(message)))
+	_, err := w.Write(append(size, message...))
+	return err
+}
 
-	return &Block{
-		dataRoot,
-		b.stateRoot,
-		b.transactions,
-		nil,
-		dataTree,
-		b.interStateRoots}
+// readFrame reads a message written by writeFrame.
+func readFrame(r io.Reader) ([]byte, error) {
+	size := make([]byte, 4)
+	_, err := io.ReadFull(r, size)
+	if err != nil {
+		return nil, err
+	}
+	message := make([]byte, binary.LittleEndian.Uint32(size))
+	_, err = io.ReadFull(r, message)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return message, err
 }
 
 func corruptFraudproofChunks(fp *FraudProof) (*FraudProof) {
-	copyFp := copyFraudproof(fp)
+	copyFp := fp.Copy()
 	h := sha512.New512_256()
 	h.Write([]byte("random"))
 	copyFp.proofChunks[0] = [][]byte{h.Sum(nil), h.Sum(nil)}
@@ -284,35 +4955,35 @@ func corruptFraudproofChunks(fp *FraudProof) (*FraudProof) {
 }
 
 func corruptFraudproofState(fp *FraudProof) (*FraudProof) {
-	copyFp := copyFraudproof(fp)
+	copyFp := fp.Copy()
 	h := sha512.New512_256()
 	h.Write([]byte("random"))
 	copyFp.writeKeys[0] = h.Sum(nil)
 	return copyFp
 }
 
-// I didn't manage to make a deep copier work (.eg github.com/jinzhu/copier)
-func copyFraudproof(fp *FraudProof) (*FraudProof) {
-	copyFp := &FraudProof{
-		make([][]byte, len(fp.writeKeys)), //writeKeys
-		make([][]byte, len(fp.oldData)), //oldData
-		make([][]byte, len(fp.readKeys)), //readKeys
-		make([][]byte, len(fp.readData)), //readData
-		make([]smt.SparseCompactMerkleProof, len(fp.proofState)), //proofState
-		make([][]byte, len(fp.chunks)), // chunks
-		make([][][]byte, len(fp.proofChunks)), //proofChunks
-		make([]uint64, len(fp.chunksIndexes)), // chunksIndexes
-		fp.numOfLeaves, // numOfLeaves
-	}
-
-	copy(copyFp.writeKeys, fp.writeKeys)
-	copy(copyFp.oldData, fp.oldData)
-	copy(copyFp.readKeys, fp.readKeys)
-	copy(copyFp.readData, fp.readData)
-	copy(copyFp.proofState, fp.proofState)
-	copy(copyFp.chunks, fp.chunks)
-	copy(copyFp.proofChunks, fp.proofChunks)
-	copy(copyFp.chunksIndexes, fp.chunksIndexes)
 
-	return copyFp
+// chunk sizes compared by the proof size benchmark
+var proofSizeChunkSizes = []int{64, 128, 256}
+
+func generateFraudProofWithChunkSize(blockSize int, chunkSize int) (*FraudProof, error) {
+	goodTransaction, stateTree := generateBlockInput(blockSize)
+	goodBlock, err := NewBlock(goodTransaction, stateTree, WithChunkSize(chunkSize))
+	if err != nil {
+		return nil, err
+	}
+	return corruptBlockInterStates(goodBlock).CheckBlock(stateTree)
+}
+
+// captureLogger records every logged line.
+type captureLogger struct {
+	lines []string
+}
+
+func (l *captureLogger) Debugf(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *captureLogger) Infof(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
 }
@@ -0,0 +1,57 @@
+package fraudproofs
+
+import (
+	"crypto/sha512"
+	"github.com/lazyledger/smt"
+	"io"
+)
+
+// GenerateRandomBlock generates a valid block holding at most blockSize bytes of transactions, along with the state
+// tree it has been applied to. The transactions mimic average Ethereum transactions and their random parts are read
+// from r, so that a deterministic reader generates a reproducible block. The options are passed to NewBlock, eg. to
+// link the block to a blockchain (see WithParent).
+func GenerateRandomBlock(blockSize int, r io.Reader, opts ...BlockOption) (*Block, *smt.SparseMerkleTree, error) {
+	const sizeKeys = 32
+	const sizeData = 49
+
+	var t []Transaction
+	for size := 0; ; {
+		readKey := make([]byte, sizeKeys)
+		_, err := io.ReadFull(r, readKey)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tx, err := NewTransaction(
+			[][]byte{filledBytes(sizeKeys, 1)},
+			[][]byte{filledBytes(sizeData, 2)},
+			[][]byte{filledBytes(sizeData, 3)},
+			[][]byte{readKey},
+			[][]byte{filledBytes(sizeData, 5)},
+			[]byte{})
+		if err != nil {
+			return nil, nil, err
+		}
+		if size+tx.Size() > blockSize {
+			break
+		}
+		size += tx.Size()
+		t = append(t, *tx)
+	}
+
+	stateTree := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	b, err := NewBlock(t, stateTree, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, stateTree, nil
+}
+
+// filledBytes returns an array of the given size filled with the given value.
+func filledBytes(size int, value byte) []byte {
+	buff := make([]byte, size)
+	for i := 0; i < size; i++ {
+		buff[i] = value
+	}
+	return buff
+}
@@ -0,0 +1,51 @@
+package fraudproofs
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"hash"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// HashAlgorithm identifies the hash function of the data tree and of the state tree of a block. It is committed by the
+// header of the block and embedded in its fraud proofs, so that verifiers of other implementations select the same hash
+// function without out-of-band configuration.
+type HashAlgorithm byte
+
+const (
+	// HashSHA512_256 identifies SHA-512/256, the default hash function.
+	HashSHA512_256 HashAlgorithm = iota
+	// HashSHA256 identifies SHA-256.
+	HashSHA256
+	// HashKeccak256 identifies the legacy Keccak-256 used by Ethereum.
+	HashKeccak256
+)
+
+// ErrUnsupportedHashAlgorithm is returned when a header or a fraud proof identifies a hash function the verifier does
+// not support.
+var ErrUnsupportedHashAlgorithm = errors.New("unsupported hash algorithm")
+
+// newHash returns the hash function identified by the algorithm.
+func (a HashAlgorithm) newHash() (func() hash.Hash, error) {
+	switch a {
+	case HashSHA512_256:
+		return sha512.New512_256, nil
+	case HashSHA256:
+		return sha256.New, nil
+	case HashKeccak256:
+		return sha3.NewLegacyKeccak256, nil
+	}
+	return nil, ErrUnsupportedHashAlgorithm
+}
+
+// WithHashAlgorithm sets the hash function of the data tree and of the state tree from its identifier, which the header
+// of the block commits to; NewBlock returns ErrUnsupportedHashAlgorithm if the identifier is unknown. Unlike WithHash,
+// it lets verifiers of the deserialized block and of its fraud proofs select the same hash function.
+func WithHashAlgorithm(a HashAlgorithm) BlockOption {
+	return func(b *Block) {
+		b.hashAlgorithm = a
+		b.newHash, _ = a.newHash() // nil if the identifier is unknown
+	}
+}
@@ -0,0 +1,81 @@
+package fraudproofs
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// keyPath returns the path of the key in the state tree, ie. the position of its leaf: the hash of the key.
+func keyPath(key []byte, newHash func() hash.Hash) []byte {
+	h := newHash()
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+// HashKeys returns a copy of the fraud proof carrying the paths of the keys it proves in the state tree instead of the
+// keys, which makes it smaller when the keys are longer than a hash. Only fraud proofs of invalid state roots, expected
+// roots and intermediate state roots support hashed keys: the verifier recovers the keys from the transactions held in
+// their chunks.
+func (fp *FraudProof) HashKeys() (*FraudProof, error) {
+	switch fp.kind {
+	case KindInvalidStateRoot, KindInvalidExpectedRoot, KindInvalidInterStateRoot:
+	default:
+		return nil, errors.New("fraud proof does not support hashed keys")
+	}
+	hashed := fp.Copy()
+	if fp.hashedKeys {
+		return hashed, nil
+	}
+	for i := 0; i < len(hashed.writeKeys); i++ {
+		hashed.writeKeys[i] = keyPath(fp.writeKeys[i], fp.hashFunction())
+	}
+	hashed.hashedKeys = true
+	return hashed, nil
+}
+
+// validateHashedKeys checks that the fraud proof supports hashed keys, and that every hashed key is a path in the state
+// tree.
+func (fp *FraudProof) validateHashedKeys() error {
+	switch fp.kind {
+	case KindInvalidStateRoot, KindInvalidExpectedRoot, KindInvalidInterStateRoot:
+	default:
+		return errors.New("fraud proof does not support hashed keys")
+	}
+	size := fp.hashFunction()().Size()
+	for i := 0; i < len(fp.writeKeys); i++ {
+		if len(fp.writeKeys[i]) != size {
+			return errors.New("hashed key is not a path in the state tree")
+		}
+	}
+	return nil
+}
+
+// provesKey returns whether the i-th writeKey of the fraud proof is the given key, or its path if the keys are hashed.
+func (fp *FraudProof) provesKey(i int, key []byte, newHash func() hash.Hash) bool {
+	if fp.hashedKeys {
+		return bytes.Equal(fp.writeKeys[i], keyPath(key, newHash))
+	}
+	return bytes.Equal(fp.writeKeys[i], key)
+}
+
+// keys returns the keys proven by the fraud proof; if they are hashed, they are recovered from the given keys of the
+// transactions held in the chunks.
+func (fp *FraudProof) keys(candidates [][]byte, newHash func() hash.Hash) ([][]byte, error) {
+	if !fp.hashedKeys {
+		return fp.writeKeys, nil
+	}
+	paths := make(map[string][]byte)
+	for _, key := range candidates {
+		paths[string(keyPath(key, newHash))] = key
+	}
+	keys := make([][]byte, len(fp.writeKeys))
+	for i := 0; i < len(fp.writeKeys); i++ {
+		key, ok := paths[string(fp.writeKeys[i])]
+		if !ok {
+			return nil, errors.New("hashed key does not match any key written by the transactions")
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
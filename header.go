@@ -0,0 +1,148 @@
+package fraudproofs
+
+import (
+	"crypto/sha512"
+	"errors"
+)
+
+// BlockHeader is the header of a block, used to sync headers before downloading block bodies.
+type BlockHeader struct {
+	dataRoot        []byte
+	stateRoot       []byte
+	writeKeysRoot   []byte
+	height          uint64
+	parentHash      []byte
+	timestamp       int64
+	numTransactions uint64        // number of transactions claimed by the block
+	hashAlgorithm   HashAlgorithm // hash function of the data tree and of the state tree
+	checkOldData    bool          // whether the oldData of every write must match the state preceding the transaction
+	checkReads      bool          // whether the readData of every transaction must match the state it reads from
+	strictWrites    bool          // whether a transaction writing to an existing key must declare a read of it
+
+	// scheme of the data tree, in memory only like WithDataTree (NebulousDataTree if not set, eg. when deserialized)
+	dataTreeScheme DataTreeScheme
+}
+
+// Header returns the header of the block.
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{
+		dataRoot:        b.dataRoot,
+		stateRoot:       b.stateRoot,
+		writeKeysRoot:   b.writeKeysRoot,
+		height:          b.height,
+		parentHash:      b.parentHash,
+		timestamp:       b.timestamp,
+		numTransactions: b.numTransactions,
+		hashAlgorithm:   b.hashAlgorithm,
+		checkOldData:    b.checkOldData,
+		checkReads:      b.checkReads,
+		strictWrites:    b.strictWrites,
+		dataTreeScheme:  b.dataTreeScheme,
+	}
+}
+
+// Hash returns the hash of the block, ie. the hash of its header.
+func (b *Block) Hash() []byte {
+	return b.Header().Hash()
+}
+
+// WithParent links the block to the block of the given header: the block is at the next height, and its header
+// commits to the hash of its parent. A blockchain only appends blocks extending its last block.
+func WithParent(parent BlockHeader) BlockOption {
+	return func(b *Block) {
+		b.height = parent.height + 1
+		b.parentHash = parent.Hash()
+	}
+}
+
+// WithHeight sets the height of a block without a known parent, ie. the first block of a blockchain (at height 1) or
+// the first block following a checkpoint.
+func WithHeight(height uint64) BlockOption {
+	return func(b *Block) {
+		b.height = height
+	}
+}
+
+// NumTransactions returns the number of transactions claimed by the block of the header.
+func (h BlockHeader) NumTransactions() uint64 {
+	return h.numTransactions
+}
+
+// HashAlgorithm returns the identifier of the hash function of the data tree and of the state tree of the block.
+func (h BlockHeader) HashAlgorithm() HashAlgorithm {
+	return h.hashAlgorithm
+}
+
+// Hash returns the hash of the header.
+func (h BlockHeader) Hash() []byte {
+	hasher := sha512.New512_256()
+	hasher.Write(h.Serialize())
+	return hasher.Sum(nil)
+}
+
+// Serialize converts a header into an array of bytes.
+func (h BlockHeader) Serialize() []byte {
+	var buff []byte
+	buff = appendBytes(buff, h.dataRoot)
+	buff = appendBytes(buff, h.stateRoot)
+	buff = appendBytes(buff, h.writeKeysRoot)
+	buff = appendBytes(buff, h.parentHash)
+	buff = appendUint64(buff, h.height)
+	buff = appendUint64(buff, uint64(h.timestamp))
+	buff = appendUint64(buff, h.numTransactions)
+	buff = append(buff, byte(h.hashAlgorithm))
+	// the rules deciding whether fraud proofs verify are committed, so that relayers cannot flip them
+	for _, flag := range []bool{h.checkOldData, h.checkReads, h.strictWrites} {
+		if flag {
+			buff = append(buff, 1)
+		} else {
+			buff = append(buff, 0)
+		}
+	}
+	return buff
+}
+
+// DeserializeBlockHeader converts a serialized header (ie. array of bytes) into a header structure.
+func DeserializeBlockHeader(buff []byte) (*BlockHeader, error) {
+	d := &decoder{buff}
+	h := &BlockHeader{}
+	var err error
+	for _, field := range []*[]byte{&h.dataRoot, &h.stateRoot, &h.writeKeysRoot, &h.parentHash} {
+		*field, err = d.bytes()
+		if err != nil {
+			return nil, err
+		}
+	}
+	h.height, err = d.uint64()
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := d.uint64()
+	if err != nil {
+		return nil, err
+	}
+	h.timestamp = int64(timestamp)
+	h.numTransactions, err = d.uint64()
+	if err != nil {
+		return nil, err
+	}
+	algorithm, err := d.uint8()
+	if err != nil {
+		return nil, err
+	}
+	h.hashAlgorithm = HashAlgorithm(algorithm)
+	if _, err := h.hashAlgorithm.newHash(); err != nil {
+		return nil, err
+	}
+	for _, flag := range []*bool{&h.checkOldData, &h.checkReads, &h.strictWrites} {
+		set, err := d.uint8()
+		if err != nil {
+			return nil, err
+		}
+		*flag = set != 0
+	}
+	if len(d.buff) != 0 {
+		return nil, errors.New("trailing bytes after header")
+	}
+	return h, nil
+}
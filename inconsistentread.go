@@ -0,0 +1,78 @@
+package fraudproofs
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrInconsistentRead is returned when a transaction reads a key it also writes, and declares readData that do not
+// match the oldData of its write; like the other reads, it is only checked by blocks checking reads (see
+// WithCheckReads).
+var ErrInconsistentRead = errors.New("transaction declares readData that do not match the oldData of its own write")
+
+// inconsistentRead returns the index of the first read of the transaction declaring readData that do not match the
+// oldData of its write of the same key, or -1 if its reads are consistent with its writes. Reads from the pre-block
+// state are not checked, as the key may have been written since.
+func (t *Transaction) inconsistentRead() int {
+	if t.readFromPreBlock {
+		return -1
+	}
+	for j := 0; j < len(t.readKeys); j++ {
+		for k := 0; k < len(t.writeKeys); k++ {
+			if bytes.Equal(t.readKeys[j], t.writeKeys[k]) && !bytes.Equal(t.readData[j], t.oldData[k]) {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// firstInconsistentRead returns the indexes of the first transaction (and of its read) whose readData do not match the
+// oldData of its own write of the same key, or -1 if there is none.
+func firstInconsistentRead(t []Transaction) (int, int) {
+	for i := 0; i < len(t); i++ {
+		if j := t[i].inconsistentRead(); j >= 0 {
+			return i, j
+		}
+	}
+	return -1, -1
+}
+
+// inconsistentReadFraudProof generates a fraud proof for the j-th read of the i-th transaction of the block, which does
+// not match the oldData of its own write of the same key; it only reveals the transaction, as no state is needed.
+func (b *Block) inconsistentReadFraudProof(i, j int) (*FraudProof, error) {
+	chunksIndexes, chunks, proofChunks, numOfLeaves, offset, err := b.proveTransactions(i)
+	if err != nil {
+		return nil, err
+	}
+	return &FraudProof{
+		kind:          KindInconsistentRead,
+		readKeys:      [][]byte{b.transactions[i].readKeys[j]},
+		readData:      [][]byte{b.transactions[i].readData[j]},
+		chunks:        chunks,
+		proofChunks:   proofChunks,
+		txIndex:       uint64(i),
+		chunkSize:     uint64(b.chunkSize),
+		chunksIndexes: chunksIndexes,
+		numOfLeaves:   numOfLeaves,
+		offset:        offset,
+		newHash:       b.newHash,
+		hashAlgorithm: b.hashAlgorithm,
+		stateEncoding: b.stateEncoding,
+	}, nil
+}
+
+// verifyInconsistentReadFraudProof verifies a fraud proof claiming that a transaction of the block declares readData
+// that do not match the oldData of its own write of the same key.
+func (b *Block) verifyInconsistentReadFraudProof(fp FraudProof) bool {
+	if !b.checkReads || len(fp.readKeys) != 1 {
+		return false
+	}
+	t, _, ok := b.extractTransactions(fp)
+	if !ok {
+		return false
+	}
+	invalid := t[len(t)-1]
+	j := invalid.inconsistentRead()
+	return j >= 0 && bytes.Equal(invalid.readKeys[j], fp.readKeys[0])
+}
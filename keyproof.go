@@ -0,0 +1,91 @@
+package fraudproofs
+
+import (
+	"bytes"
+
+	"github.com/lazyledger/smt"
+)
+
+// keyFraudProof generates a fraud proof of the given kind about a key of the i-th transaction of the block, holding
+// the value of the key in the state preceding the group of the transaction (or in the state preceding the block, if
+// preBlock is set) along with the chunks holding the transactions of the group up to the i-th one; if an intermediate
+// state root preceding the group is wrong, the fraud proof disputes it instead. The state tree must hold the state
+// preceding the block.
+func (b *Block) keyFraudProof(kind FraudProofKind, i int, key []byte, stateTree *smt.SparseMerkleTree,
+	preBlock bool) (*FraudProof, error) {
+	// 1. bring the state tree to the intermediate state root preceding the transaction
+	if !preBlock {
+		fp, err := b.replayPrecedingGroups(i, stateTree)
+		if err != nil || fp != nil {
+			return fp, err
+		}
+	}
+
+	// 2. prove the value of the key in that state
+	value, err := stateTree.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := stateTree.ProveCompact(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. get the chunks holding the transactions since the intermediate state root
+	chunksIndexes, chunks, proofChunks, numOfLeaves, offset, err := b.proveTransactions(i)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FraudProof{
+		kind:          kind,
+		readKeys:      [][]byte{key},
+		readData:      [][]byte{value},
+		proofState:    []smt.SparseCompactMerkleProof{proof},
+		chunks:        chunks,
+		proofChunks:   proofChunks,
+		txIndex:       uint64(i),
+		stateRoot:     append([]byte{}, stateTree.Root()...),
+		chunkSize:     uint64(b.chunkSize),
+		chunksIndexes: chunksIndexes,
+		numOfLeaves:   numOfLeaves,
+		offset:        offset,
+		newHash:       b.newHash,
+		hashAlgorithm: b.hashAlgorithm,
+		stateEncoding: b.stateEncoding}, nil
+}
+
+// verifyKeyFraudProof checks the chunks and the state proof of a fraud proof generated by keyFraudProof, and returns
+// the transactions of the group up to the one the fraud proof is about, along with the value of the key in the state
+// preceding the group (or the block, for transactions reading from it).
+func (b *Block) verifyKeyFraudProof(fp FraudProof) ([]*Transaction, []byte, bool) {
+	if len(fp.readKeys) != 1 || len(fp.readData) != 1 || len(fp.proofState) != 1 {
+		return nil, nil, false
+	}
+	t, preStateRoot, ok := b.extractTransactions(fp)
+	if !ok {
+		return nil, nil, false
+	}
+	if fp.kind == KindInvalidRead && t[len(t)-1].readFromPreBlock {
+		preStateRoot = b.preStateRoot
+	}
+
+	if !verifyStateProof(fp.proofState[0], preStateRoot, fp.readKeys[0], fp.readData[0], b.newHash) {
+		return nil, nil, false
+	}
+	return t, fp.readData[0], true
+}
+
+// lastWrite returns the data written to the key by the last of the transactions writing it, if any.
+func lastWrite(t []*Transaction, key []byte) ([]byte, bool) {
+	var data []byte
+	written := false
+	for i := 0; i < len(t); i++ {
+		for j := 0; j < len(t[i].writeKeys); j++ {
+			if bytes.Equal(t[i].writeKeys[j], key) {
+				data, written = t[i].newData[j], true
+			}
+		}
+	}
+	return data, written
+}
@@ -0,0 +1,181 @@
+package fraudproofs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash"
+)
+
+// CheckDataLayout checks that the chunks committed by the data root of the block lay out its entries the way makeChunks
+// does, ie. an intermediate state root after every Step transactions and nowhere else, and returns a fraud proof if
+// they do not. The chunks are the leaves of the data tree as published by the proposer, which may not match the
+// transactions and intermediate state roots of the block: a proposer committing a spurious or missing intermediate
+// state root misleads the verifiers of the other fraud proofs about the position of the transactions.
+func (b *Block) CheckDataLayout(chunks [][]byte) (*FraudProof, error) {
+	if len(chunks) == 0 {
+		return nil, nil
+	}
+	if !bytes.Equal(b.computeDataRoot(chunks), b.dataRoot) {
+		return nil, ErrInvalidDataRoot
+	}
+	var buff []byte
+	for i := 0; i < len(chunks); i++ {
+		if len(chunks[i]) == 0 {
+			return nil, ErrInvalidChunkProof
+		}
+		buff = append(buff, chunks[i][1:]...)
+	}
+	end := layoutViolation(buff, b.newHash().Size(), true)
+	if end < 0 {
+		return nil, nil
+	}
+
+	// reveal the chunks from the start of the data, where the first entry is known to start, up to the violation
+	last := end / (b.chunkSize - 1)
+	if last >= len(chunks) {
+		last = len(chunks) - 1
+	}
+	chunksIndexes := make([]uint64, last+1)
+	for j := 0; j <= last; j++ {
+		chunksIndexes[j] = uint64(j)
+	}
+	proofChunks, err := b.proveLeadingChunks(chunks, last+1)
+	if err != nil {
+		return nil, err
+	}
+	return &FraudProof{
+		kind:          KindInvalidLayout,
+		chunks:        copySlices(chunks[:last+1]),
+		proofChunks:   proofChunks,
+		chunkSize:     uint64(b.chunkSize),
+		chunksIndexes: chunksIndexes,
+		numOfLeaves:   uint64(len(chunks)),
+		newHash:       b.newHash,
+		hashAlgorithm: b.hashAlgorithm,
+		stateEncoding: b.stateEncoding,
+	}, nil
+}
+
+// proveLeadingChunks returns the Merkle proofs of the first n chunks in their data tree. The default data tree is
+// joined once, collecting the siblings of the proven chunks on the way; the trees of other schemes are built again for
+// each proof.
+func (b *Block) proveLeadingChunks(chunks [][]byte, n int) ([][][]byte, error) {
+	proofs := make([][][]byte, n)
+	if b.dataTreeScheme.New == nil || b.dataTreeScheme.VerifyProof == nil {
+		leaves := make([][]byte, len(chunks))
+		h := b.newHash()
+		for i := 0; i < len(chunks); i++ {
+			h.Reset()
+			h.Write([]byte{0x0}) // leaf prefix of merkletree
+			h.Write(chunks[i])
+			leaves[i] = h.Sum(nil)
+		}
+		for j := 0; j < n; j++ {
+			proofs[j] = [][]byte{chunks[j]}
+		}
+		proveSubTrees(h, leaves, 0, proofs)
+		return proofs, nil
+	}
+
+	hasher := b.newHash() // shared by every temporary data tree
+	for j := 0; j < n; j++ {
+		hasher.Reset()
+		tmpDataTree := b.dataTreeScheme.New(hasher)
+		err := tmpDataTree.SetIndex(uint64(j))
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(chunks); i++ {
+			tmpDataTree.Push(chunks[i])
+		}
+		_, proofs[j], _, _ = tmpDataTree.Prove()
+	}
+	return proofs, nil
+}
+
+// proveSubTrees combines the nodes like joinSubTrees and returns their root, appending to the proof of each leaf below
+// them the sibling of its subtree at every level; the nodes start at the given leaf index, and only the leaves with a
+// proof are proven.
+func proveSubTrees(h hash.Hash, nodes [][]byte, offset int, proofs [][][]byte) []byte {
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+	split := 1
+	for split*2 < len(nodes) {
+		split *= 2
+	}
+	left := proveSubTrees(h, nodes[:split], offset, proofs)
+	right := proveSubTrees(h, nodes[split:], offset+split, proofs)
+	for i := offset; i < offset+len(nodes) && i < len(proofs); i++ {
+		if i < offset+split {
+			proofs[i] = append(proofs[i], right)
+		} else {
+			proofs[i] = append(proofs[i], left)
+		}
+	}
+	h.Reset()
+	h.Write([]byte{0x1}) // node prefix of merkletree
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// layoutViolation walks the entries of the data of the chunks from its start, and returns the position of the last byte
+// proving that they are not laid out as an intermediate state root after every Step transactions, or -1 if they are.
+// Unless the data is complete, entries running past its end are not a violation, as they may be revealed further.
+func layoutViolation(buff []byte, rootSize int, complete bool) int {
+	pos, count := 0, 0 // count of the transactions following the last intermediate state root
+	for pos < len(buff) {
+		var violation int
+		pos, count, violation = nextEntry(buff, pos, count, rootSize)
+		if violation >= 0 {
+			return violation
+		}
+	}
+	if complete && (pos > len(buff) || count == Step) {
+		return len(buff) - 1 // truncated entry, or missing last intermediate state root
+	}
+	return -1
+}
+
+// nextEntry returns the position of the entry following the entry at the given position of the data (past its end if
+// the length of a transaction runs past it) and the count of the transactions following the last intermediate state
+// root, given the count preceding the entry. The last return value is the position of the last byte proving that the
+// entry breaks the layout, or -1 if it does not.
+func nextEntry(buff []byte, pos, count, rootSize int) (int, int, int) {
+	kind := LeafKind(buff[pos])
+	switch {
+	case kind == LeafTransaction && count == Step:
+		return 0, 0, pos // missing intermediate state root
+	case kind == LeafStateRoot && count != Step:
+		return 0, 0, pos // spurious intermediate state root
+	case kind == LeafStateRoot:
+		return pos + 1 + rootSize, 0, -1
+	case kind != LeafTransaction:
+		return 0, 0, pos
+	case pos+1+MaxSize > len(buff):
+		return len(buff) + 1, count, -1 // the length of the transaction runs past the end
+	}
+	length := int(binary.LittleEndian.Uint16(buff[pos+1 : pos+1+MaxSize]))
+	if length < MaxSize {
+		return 0, 0, pos + MaxSize
+	}
+	return pos + 1 + length, count + 1, -1
+}
+
+// verifyLayoutFraudProof verifies a fraud proof claiming that the chunks of the data tree of the block do not lay out
+// an intermediate state root after every Step transactions; it only needs the data root of the block.
+func (b *Block) verifyLayoutFraudProof(fp FraudProof) bool {
+	if fp.chunkSize != uint64(b.chunkSize) || !b.verifyChunks(fp) {
+		return false
+	}
+	var buff []byte
+	for i := 0; i < len(fp.chunks); i++ {
+		if fp.chunksIndexes[i] != uint64(i) {
+			return false // the entries are only known to start at the start of the data
+		}
+		buff = append(buff, fp.chunks[i][1:]...)
+	}
+	complete := fp.chunksIndexes[len(fp.chunks)-1] == fp.numOfLeaves-1
+	return layoutViolation(buff, b.newHash().Size(), complete) >= 0
+}
@@ -0,0 +1,40 @@
+// Package leveldbstore implements a state store backed by LevelDB.
+package leveldbstore
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Store is a state store backed by a LevelDB database.
+type Store struct {
+	db *leveldb.DB
+}
+
+// Open opens (or creates) the LevelDB database at the given path.
+func Open(path string) (*Store, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db}, nil
+}
+
+// Get returns the value stored for the key, or an error if there is none.
+func (s *Store) Get(key []byte) ([]byte, error) {
+	return s.db.Get(key, nil)
+}
+
+// Set stores the value for the key.
+func (s *Store) Set(key []byte, value []byte) error {
+	return s.db.Put(key, value, nil)
+}
+
+// Delete deletes the value stored for the key.
+func (s *Store) Delete(key []byte) error {
+	return s.db.Delete(key, nil)
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
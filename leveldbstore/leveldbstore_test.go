@@ -0,0 +1,62 @@
+package leveldbstore
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/asonnino/fraudproofs-prototype"
+	"github.com/lazyledger/smt"
+)
+
+func TestStore(test *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldbstore")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// build and append a block on a state stored in LevelDB
+	store, err := Open(dir)
+	if err != nil {
+		test.Fatal(err)
+	}
+	key, data := []byte("key"), []byte("data")
+	t, err := fraudproofs.NewTransaction([][]byte{key}, [][]byte{data}, [][]byte{[]byte("old")}, [][]byte{key},
+		[][]byte{[]byte("old")}, []byte{})
+	if err != nil {
+		test.Fatal(err)
+	}
+	b, err := fraudproofs.NewBlock([]fraudproofs.Transaction{*t, *t},
+		smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()), fraudproofs.WithHeight(1))
+	if err != nil {
+		test.Fatal(err)
+	}
+	blockchain := fraudproofs.NewBlockchainWithStore(store)
+	_, fp, err := blockchain.Append(b)
+	if err != nil {
+		test.Fatal(err)
+	} else if fp != nil {
+		test.Error("should not return a fraud proof")
+	}
+	root := fraudproofs.OpenStateTree(store, nil).Root()
+	store.Close()
+
+	// reopen the store and read the state back
+	store, err = Open(dir)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer store.Close()
+	if !bytes.Equal(fraudproofs.OpenStateTree(store, nil).Root(), root) {
+		test.Error("state root not persisted")
+	}
+	value, err := fraudproofs.NewBlockchainWithStore(store).Get(key)
+	if err != nil {
+		test.Error(err)
+	} else if !bytes.Equal(value, data) {
+		test.Error("state not persisted")
+	}
+}
@@ -0,0 +1,13 @@
+package fraudproofs
+
+// Logger receives diagnostics from the blockchain.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+}
+
+// nopLogger is a logger discarding every message.
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+func (nopLogger) Infof(format string, args ...interface{})  {}
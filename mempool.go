@@ -0,0 +1,51 @@
+package fraudproofs
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Mempool holds the transactions waiting to be included in a block.
+type Mempool struct {
+	transactions map[[256]byte]Transaction // transactions indexed by their hash key
+}
+
+// NewMempool creates an empty mempool.
+func NewMempool() *Mempool {
+	return &Mempool{make(map[[256]byte]Transaction)}
+}
+
+// Add adds a transaction to the mempool; adding a transaction already in the mempool has no effect.
+func (m *Mempool) Add(t Transaction) {
+	m.transactions[t.HashKey()] = t
+}
+
+// Len returns the number of transactions in the mempool.
+func (m *Mempool) Len() int {
+	return len(m.transactions)
+}
+
+// Pending returns the transactions of the mempool in a deterministic order (by nonce, then by hash), so that nodes
+// holding the same mempool build the same block.
+func (m *Mempool) Pending() []Transaction {
+	type entry struct {
+		t    Transaction
+		hash []byte
+	}
+	entries := make([]entry, 0, len(m.transactions))
+	for _, t := range m.transactions {
+		entries = append(entries, entry{t, t.Hash()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].t.nonce != entries[j].t.nonce {
+			return entries[i].t.nonce < entries[j].t.nonce
+		}
+		return bytes.Compare(entries[i].hash, entries[j].hash) < 0
+	})
+
+	t := make([]Transaction, len(entries))
+	for i := 0; i < len(entries); i++ {
+		t[i] = entries[i].t
+	}
+	return t
+}
@@ -0,0 +1,49 @@
+// Package metrics exports the counters of a blockchain as Prometheus metrics.
+package metrics
+
+import (
+	"github.com/asonnino/fraudproofs-prototype"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a Prometheus collector exporting the counters of a blockchain; register it to a registry served by
+// promhttp to let operators scrape a running node.
+type Collector struct {
+	blockchain  *fraudproofs.Blockchain
+	blocks      *prometheus.Desc
+	fraudProofs *prometheus.Desc
+	latency     *prometheus.Desc
+}
+
+// NewCollector creates a collector exporting the counters of the blockchain.
+func NewCollector(blockchain *fraudproofs.Blockchain) *Collector {
+	return &Collector{
+		blockchain: blockchain,
+		blocks: prometheus.NewDesc("fraudproof_blocks_total",
+			"Number of blocks accepted by the blockchain.", nil, nil),
+		fraudProofs: prometheus.NewDesc("fraudproof_proofs_generated_total",
+			"Number of fraud proofs generated for blocks rejected by the blockchain.", nil, nil),
+		latency: prometheus.NewDesc("fraudproof_verification_latency_seconds",
+			"Time spent checking the blocks appended to the blockchain.", nil, nil),
+	}
+}
+
+// Describe sends the descriptions of the metrics of the collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.blocks
+	ch <- c.fraudProofs
+	ch <- c.latency
+}
+
+// Collect sends the current values of the metrics of the collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.blockchain.Stats()
+	ch <- prometheus.MustNewConstMetric(c.blocks, prometheus.CounterValue, float64(stats.Blocks))
+	ch <- prometheus.MustNewConstMetric(c.fraudProofs, prometheus.CounterValue, float64(stats.FraudProofs))
+
+	buckets := make(map[float64]uint64, len(fraudproofs.CheckLatencyBuckets))
+	for i, bound := range fraudproofs.CheckLatencyBuckets {
+		buckets[bound] = stats.CheckBuckets[i]
+	}
+	ch <- prometheus.MustNewConstHistogram(c.latency, stats.Checks, stats.CheckSeconds, buckets)
+}
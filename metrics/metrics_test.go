@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"crypto/sha512"
+	"math/rand"
+	"testing"
+
+	"github.com/asonnino/fraudproofs-prototype"
+	"github.com/lazyledger/smt"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollector(test *testing.T) {
+	blockchain := fraudproofs.NewBlockchain()
+	registry := prometheus.NewRegistry()
+	err := registry.Register(NewCollector(blockchain))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// append a good block, then a block built on top of another state, which the blockchain rejects
+	goodBlock, _, err := fraudproofs.GenerateRandomBlock(10000, rand.New(rand.NewSource(1)), fraudproofs.WithHeight(1))
+	if err != nil {
+		test.Fatal(err)
+	}
+	if _, fp, err := blockchain.Append(goodBlock); err != nil || fp != nil {
+		test.Fatal("block should be appended")
+	}
+	var t []fraudproofs.Transaction
+	for i := 0; i < 4; i++ {
+		tx, err := fraudproofs.NewTransaction([][]byte{{byte(i)}}, [][]byte{[]byte("new")}, [][]byte{{}},
+			[][]byte{{byte(i)}}, [][]byte{{}}, []byte{})
+		if err != nil {
+			test.Fatal(err)
+		}
+		t = append(t, *tx)
+	}
+	otherState := smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256())
+	otherState.Update([]byte("other"), []byte("state"))
+	badBlock, err := fraudproofs.NewBlock(t, otherState, fraudproofs.WithParent(goodBlock.Header()))
+	if err != nil {
+		test.Fatal(err)
+	}
+	if _, fp, err := blockchain.Append(badBlock); err != nil || fp == nil {
+		test.Fatal("block should be rejected with a fraud proof")
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		test.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			if metric.GetCounter() != nil {
+				values[family.GetName()] = metric.GetCounter().GetValue()
+			}
+			if metric.GetHistogram() != nil {
+				values[family.GetName()] = float64(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	expected := map[string]float64{
+		"fraudproof_blocks_total":                 1,
+		"fraudproof_proofs_generated_total":       1,
+		"fraudproof_verification_latency_seconds": 2,
+	}
+	for name, value := range expected {
+		got, ok := values[name]
+		if !ok {
+			test.Errorf("metric %s should be exported", name)
+		} else if got != value {
+			test.Errorf("metric %s should be %v, got %v", name, value, got)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package fraudproofs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/lazyledger/smt"
+)
+
+// ErrInvalidNonce is returned when a transaction does not use the next nonce of its sender.
+var ErrInvalidNonce = errors.New("transaction does not use the next nonce of its sender")
+
+// nonceKey returns the key under which the nonce of an account is committed in the state tree.
+func nonceKey(sender []byte) []byte {
+	return append([]byte("nonce/"), sender...)
+}
+
+// nonceValue encodes the next nonce of an account as stored in the state tree.
+func nonceValue(nonce uint64) []byte {
+	return stateValue(appendUint64(nil, nonce))
+}
+
+// nonceFromValue decodes a value stored in the state tree under a nonce key; accounts without a value have nonce 0.
+func nonceFromValue(value []byte) uint64 {
+	data := stateData(value)
+	if len(data) != 8 {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(data)
+}
+
+// firstInvalidNonce returns the index of the first transaction that does not use the next nonce of its sender, or -1
+// if every nonce is valid. The state tree must hold the state preceding the transactions.
+func firstInvalidNonce(t []Transaction, stateTree *smt.SparseMerkleTree) (int, error) {
+	next := make(map[string]uint64)
+	for i := 0; i < len(t); i++ {
+		if len(t[i].sender) == 0 {
+			continue
+		}
+		nonce, ok := next[string(t[i].sender)]
+		if !ok {
+			value, err := stateTree.Get(nonceKey(t[i].sender))
+			if err != nil {
+				return 0, err
+			}
+			nonce = nonceFromValue(value)
+		}
+		if t[i].nonce != nonce {
+			return i, nil
+		}
+		next[string(t[i].sender)] = nonce + 1
+	}
+	return -1, nil
+}
+
+// nonceFraudProof generates a fraud proof for the i-th transaction of the block, which uses an invalid nonce, or for
+// the first wrong intermediate state root preceding its group. The state tree must hold the state preceding the block.
+func (b *Block) nonceFraudProof(i int, stateTree *smt.SparseMerkleTree) (*FraudProof, error) {
+	// 1. bring the state tree to the intermediate state root preceding the transaction
+	fp, err := b.replayPrecedingGroups(i, stateTree)
+	if err != nil || fp != nil {
+		return fp, err
+	}
+
+	// 2. prove the nonce committed for the sender
+	sender := b.transactions[i].sender
+	value, err := stateTree.Get(nonceKey(sender))
+	if err != nil {
+		return nil, err
+	}
+	proof, err := stateTree.ProveCompact(nonceKey(sender))
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. get the chunks holding the transactions since the intermediate state root
+	chunksIndexes, chunks, proofChunks, numOfLeaves, offset, err := b.proveTransactions(i)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FraudProof{
+		kind:          KindInvalidNonce,
+		readKeys:      [][]byte{nonceKey(sender)},
+		readData:      [][]byte{value},
+		proofState:    []smt.SparseCompactMerkleProof{proof},
+		chunks:        chunks,
+		proofChunks:   proofChunks,
+		txIndex:       uint64(i),
+		stateRoot:     append([]byte{}, stateTree.Root()...),
+		chunkSize:     uint64(b.chunkSize),
+		chunksIndexes: chunksIndexes,
+		numOfLeaves:   numOfLeaves,
+		offset:        offset,
+		newHash:       b.newHash,
+		hashAlgorithm: b.hashAlgorithm}, nil
+}
+
+// verifyNonceFraudProof verifies a fraud proof claiming that a transaction of the block uses an invalid nonce.
+func (b *Block) verifyNonceFraudProof(fp FraudProof) bool {
+	if len(fp.readKeys) != 1 || len(fp.readData) != 1 || len(fp.proofState) != 1 {
+		return false
+	}
+	t, preStateRoot, ok := b.extractTransactions(fp)
+	if !ok {
+		return false
+	}
+	invalid := t[len(t)-1]
+	if len(invalid.sender) == 0 || !bytes.Equal(fp.readKeys[0], nonceKey(invalid.sender)) {
+		return false
+	}
+
+	// check the nonce committed for the sender in the intermediate state
+	if !verifyStateProof(fp.proofState[0], preStateRoot, fp.readKeys[0], fp.readData[0], b.newHash) {
+		return false
+	}
+
+	// replay the previous transactions of the sender
+	nonce := nonceFromValue(fp.readData[0])
+	for i := 0; i < len(t)-1; i++ {
+		if bytes.Equal(t[i].sender, invalid.sender) {
+			nonce = t[i].nonce + 1
+		}
+	}
+	return invalid.nonce != nonce
+}
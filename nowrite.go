@@ -0,0 +1,138 @@
+package fraudproofs
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"sort"
+
+	"github.com/NebulousLabs/merkletree"
+)
+
+// ErrKeyWritten is returned when proving that a key is not written by a block that writes it.
+var ErrKeyWritten = errors.New("key is written by a transaction of the block")
+
+// NoWriteProof proves that no transaction of a block writes a key, using the Merkle tree of the sorted keys written by
+// the block: it reveals the written keys surrounding the key, which must be adjacent in the tree. The root of the tree
+// is committed in the header of the block, and checked against the transactions by Validate and CheckBlock.
+type NoWriteProof struct {
+	neighbours  [][]byte   // written keys surrounding the key (none if the block writes no key)
+	proofs      [][][]byte // Merkle proofs of the neighbours
+	indexes     []uint64   // indexes of the neighbours in the tree of written keys
+	numOfLeaves uint64     // number of keys written by the block
+}
+
+// writtenKeys returns the sorted and deduplicated keys of the state tree written by the transactions.
+func writtenKeys(t []Transaction) [][]byte {
+	seen := make(map[string]bool)
+	var keys [][]byte
+	for i := 0; i < len(t); i++ {
+		for _, key := range t[i].stateKeys() {
+			if !seen[string(key)] {
+				seen[string(key)] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	return keys
+}
+
+// writeKeysRoot returns the root of the Merkle tree of the sorted written keys (nil if there are no keys).
+func writeKeysRoot(keys [][]byte, newHash func() hash.Hash) []byte {
+	tree := merkletree.New(newHash())
+	for i := 0; i < len(keys); i++ {
+		tree.Push(keys[i])
+	}
+	return tree.Root()
+}
+
+// ProveNoWrite generates a proof that no transaction of the block writes the key, to be checked against the root of
+// the written keys committed in the header of the block.
+func (b *Block) ProveNoWrite(key []byte) (NoWriteProof, error) {
+	keys := writtenKeys(b.transactions)
+	p := NoWriteProof{numOfLeaves: uint64(len(keys))}
+	i := sort.Search(len(keys), func(i int) bool { return bytes.Compare(keys[i], key) >= 0 })
+	if i < len(keys) && bytes.Equal(keys[i], key) {
+		return NoWriteProof{}, ErrKeyWritten
+	}
+
+	// reveal the largest written key below the key and the smallest one above it
+	for _, j := range []int{i - 1, i} {
+		if j < 0 || j >= len(keys) {
+			continue
+		}
+		tree := merkletree.New(b.newHash())
+		err := tree.SetIndex(uint64(j))
+		if err != nil {
+			return NoWriteProof{}, err
+		}
+		for k := 0; k < len(keys); k++ {
+			tree.Push(keys[k])
+		}
+		_, proof, _, _ := tree.Prove()
+		p.neighbours = append(p.neighbours, keys[j])
+		p.proofs = append(p.proofs, proof)
+		p.indexes = append(p.indexes, uint64(j))
+	}
+	return p, nil
+}
+
+// VerifyNoWriteProof verifies whether or not a proof that no transaction of the block writes the key is valid.
+func (b *Block) VerifyNoWriteProof(key []byte, p NoWriteProof) bool {
+	if len(p.neighbours) != len(p.proofs) || len(p.neighbours) != len(p.indexes) {
+		return false
+	}
+	if p.numOfLeaves == 0 {
+		return len(p.neighbours) == 0 && len(b.writeKeysRoot) == 0
+	}
+
+	hasher := b.newHash() // reset and reused for every proof
+	for i := 0; i < len(p.neighbours); i++ {
+		if len(p.proofs[i]) == 0 || !bytes.Equal(p.proofs[i][0], p.neighbours[i]) {
+			return false
+		}
+		hasher.Reset()
+		if !merkletree.VerifyProof(hasher, b.writeKeysRoot, p.proofs[i], p.indexes[i], p.numOfLeaves) {
+			return false
+		}
+	}
+
+	// the key must fall between adjacent written keys, or outside of the written keys
+	switch len(p.neighbours) {
+	case 1:
+		below := bytes.Compare(key, p.neighbours[0]) < 0
+		above := bytes.Compare(key, p.neighbours[0]) > 0
+		if (below && p.indexes[0] == 0) || (above && p.indexes[0] == p.numOfLeaves-1) {
+			return true
+		}
+	case 2:
+		if p.indexes[1] == p.indexes[0]+1 && bytes.Compare(p.neighbours[0], key) < 0 &&
+			bytes.Compare(key, p.neighbours[1]) < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadOnlyKeys returns the sorted and deduplicated keys read by some transaction of the block but written by none, eg.
+// as a hint of the keys to keep cached.
+func (b *Block) ReadOnlyKeys() [][]byte {
+	written := make(map[string]bool)
+	for i := 0; i < len(b.transactions); i++ {
+		for _, key := range b.transactions[i].writeKeys {
+			written[string(key)] = true
+		}
+	}
+	var keys [][]byte
+	for i := 0; i < len(b.transactions); i++ {
+		for _, key := range b.transactions[i].readKeys {
+			if !written[string(key)] {
+				written[string(key)] = true // only keep the first read of the key
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	return keys
+}
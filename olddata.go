@@ -0,0 +1,70 @@
+package fraudproofs
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/lazyledger/smt"
+)
+
+// ErrStaleOldData is returned when a write declares oldData that do not match the value of its key in the state
+// preceding the transaction.
+var ErrStaleOldData = errors.New("write declares oldData that do not match the state")
+
+// firstStaleOldData returns the indexes of the first transaction (and of its write) declaring oldData that do not match
+// the state preceding the transaction, or -1 if every oldData is consistent. The state tree must hold the state
+// preceding the transactions, with the values encoded with the given encoding.
+func firstStaleOldData(t []Transaction, stateTree *smt.SparseMerkleTree, e StateEncoding) (int, int, error) {
+	written := make(map[string][]byte)
+	for i := 0; i < len(t); i++ {
+		for j := 0; j < len(t[i].writeKeys); j++ {
+			data, ok := written[string(t[i].writeKeys[j])]
+			if !ok {
+				value, err := stateTree.Get(t[i].writeKeys[j])
+				if err != nil {
+					return 0, 0, err
+				}
+				data = e.decode(value)
+			}
+			if !bytes.Equal(t[i].oldData[j], data) {
+				return i, j, nil
+			}
+		}
+		for j := 0; j < len(t[i].writeKeys); j++ {
+			written[string(t[i].writeKeys[j])] = t[i].newData[j]
+		}
+	}
+	return -1, -1, nil
+}
+
+// staleOldDataFraudProof generates a fraud proof for the j-th write of the i-th transaction of the block, which
+// declares stale oldData. The state tree must hold the state preceding the block.
+func (b *Block) staleOldDataFraudProof(i, j int, stateTree *smt.SparseMerkleTree) (*FraudProof, error) {
+	return b.keyFraudProof(KindStaleOldData, i, b.transactions[i].writeKeys[j], stateTree, false)
+}
+
+// verifyStaleOldDataFraudProof verifies a fraud proof claiming that a write of the block declares stale oldData.
+func (b *Block) verifyStaleOldDataFraudProof(fp FraudProof) bool {
+	if !b.checkOldData {
+		return false
+	}
+	t, value, ok := b.verifyKeyFraudProof(fp)
+	if !ok {
+		return false
+	}
+
+	// replay the previous writes of the key
+	key := fp.readKeys[0]
+	data, written := lastWrite(t[:len(t)-1], key)
+	if !written {
+		data = b.stateEncoding.decode(value)
+	}
+
+	invalid := t[len(t)-1]
+	for j := 0; j < len(invalid.writeKeys); j++ {
+		if bytes.Equal(invalid.writeKeys[j], key) && !bytes.Equal(invalid.oldData[j], data) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+// Package p2p gossips fraud proofs over libp2p pubsub.
+package p2p
+
+import (
+	"context"
+	"errors"
+
+	"github.com/asonnino/fraudproofs-prototype"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-pubsub"
+)
+
+// Topic is the default pubsub topic on which fraud proofs are gossiped.
+const Topic = "/fraudproofs/1.0.0"
+
+// hashSize is the size of the hash of the block a gossiped fraud proof is about.
+const hashSize = 32
+
+// BlockResolver returns the block of the given hash, against which received fraud proofs are verified.
+type BlockResolver func(hash []byte) (*fraudproofs.Block, error)
+
+// Handler receives the fraud proofs that have been verified, along with the block they are about.
+type Handler func(b *fraudproofs.Block, fp *fraudproofs.FraudProof)
+
+// Gossip publishes fraud proofs on a pubsub topic, and delivers the valid fraud proofs received on it. Invalid fraud
+// proofs are dropped without being forwarded to other peers.
+type Gossip struct {
+	ps      *pubsub.PubSub
+	topic   string
+	resolve BlockResolver
+	sub     *pubsub.Subscription
+}
+
+// NewGossip joins the topic and delivers the valid fraud proofs received on it to the handler, until the context is
+// done or the gossip is closed.
+func NewGossip(ctx context.Context, ps *pubsub.PubSub, topic string, resolve BlockResolver, handle Handler) (*Gossip, error) {
+	g := &Gossip{ps: ps, topic: topic, resolve: resolve}
+	err := ps.RegisterTopicValidator(topic, func(_ context.Context, _ peer.ID, msg *pubsub.Message) bool {
+		_, _, err := g.open(msg.GetData())
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	g.sub, err = ps.Subscribe(topic)
+	if err != nil {
+		ps.UnregisterTopicValidator(topic)
+		return nil, err
+	}
+
+	go func() {
+		for {
+			msg, err := g.sub.Next(ctx)
+			if err != nil {
+				return
+			}
+			b, fp, err := g.open(msg.GetData())
+			if err == nil {
+				handle(b, fp)
+			}
+		}
+	}()
+	return g, nil
+}
+
+// Publish gossips a fraud proof about the given block.
+func (g *Gossip) Publish(b *fraudproofs.Block, fp *fraudproofs.FraudProof) error {
+	return g.ps.Publish(g.topic, append(b.Hash(), fp.Serialize()...))
+}
+
+// Close leaves the topic.
+func (g *Gossip) Close() error {
+	g.sub.Cancel()
+	return g.ps.UnregisterTopicValidator(g.topic)
+}
+
+// open decodes a gossiped fraud proof and verifies it against the block it is about.
+func (g *Gossip) open(data []byte) (*fraudproofs.Block, *fraudproofs.FraudProof, error) {
+	if len(data) < hashSize {
+		return nil, nil, errors.New("gossiped fraud proof is too short")
+	}
+	fp, err := fraudproofs.DeserializeFraudProof(data[hashSize:])
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := g.resolve(data[:hashSize])
+	if err != nil {
+		return nil, nil, err
+	}
+	if !b.VerifyFraudProof(*fp) {
+		return nil, nil, errors.New("invalid fraud proof")
+	}
+	return b, fp, nil
+}
@@ -0,0 +1,104 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/asonnino/fraudproofs-prototype"
+	"github.com/lazyledger/smt"
+	bhost "github.com/libp2p/go-libp2p-blankhost"
+	"github.com/libp2p/go-libp2p-pubsub"
+	swarmt "github.com/libp2p/go-libp2p-swarm/testing"
+)
+
+func TestGossip(test *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a block with a negative balance, and an honest block
+	badBlock := newBalanceBlock(test, []byte{0xff})
+	goodBlock := newBalanceBlock(test, []byte{0x01})
+	fp, err := badBlock.CheckBalances(fraudproofs.BalanceRule{})
+	if err != nil || fp == nil {
+		test.Fatal("should generate a fraud proof")
+	}
+	blocks := map[string]*fraudproofs.Block{string(badBlock.Hash()): badBlock, string(goodBlock.Hash()): goodBlock}
+	resolve := func(hash []byte) (*fraudproofs.Block, error) {
+		b, ok := blocks[string(hash)]
+		if !ok {
+			return nil, errors.New("unknown block")
+		}
+		return b, nil
+	}
+
+	// two connected nodes join the topic
+	var gossips []*Gossip
+	var hosts []*bhost.BlankHost
+	received := make(chan *fraudproofs.Block, 2)
+	for i := 0; i < 2; i++ {
+		node := i
+		h := bhost.NewBlankHost(swarmt.GenSwarm(test, ctx))
+		ps, err := pubsub.NewFloodSub(ctx, h)
+		if err != nil {
+			test.Fatal(err)
+		}
+		g, err := NewGossip(ctx, ps, Topic, resolve, func(b *fraudproofs.Block, _ *fraudproofs.FraudProof) {
+			if node == 1 {
+				received <- b
+			}
+		})
+		if err != nil {
+			test.Fatal(err)
+		}
+		defer g.Close()
+		gossips = append(gossips, g)
+		hosts = append(hosts, h)
+	}
+	err = hosts[1].Connect(ctx, hosts[0].Peerstore().PeerInfo(hosts[0].ID()))
+	if err != nil {
+		test.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // let the nodes exchange their subscriptions
+
+	// an invalid fraud proof is dropped, and a valid one is delivered
+	err = gossips[0].Publish(goodBlock, fp)
+	if err != nil {
+		test.Fatal(err)
+	}
+	err = gossips[0].Publish(badBlock, fp)
+	if err != nil {
+		test.Fatal(err)
+	}
+	select {
+	case b := <-received:
+		if !bytes.Equal(b.Hash(), badBlock.Hash()) {
+			test.Error("fraud proof delivered with the wrong block")
+		}
+	case <-time.After(5 * time.Second):
+		test.Error("fraud proof not delivered")
+	}
+	select {
+	case <-received:
+		test.Error("invalid fraud proof should not be delivered")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func newBalanceBlock(test *testing.T, balance []byte) *fraudproofs.Block {
+	key := []byte("key")
+	t, err := fraudproofs.NewTransaction([][]byte{key}, [][]byte{balance}, [][]byte{{0x01}}, [][]byte{key},
+		[][]byte{{0x01}}, []byte{})
+	if err != nil {
+		test.Fatal(err)
+	}
+	b, err := fraudproofs.NewBlock([]fraudproofs.Transaction{*t},
+		smt.NewSparseMerkleTree(smt.NewSimpleMap(), sha512.New512_256()))
+	if err != nil {
+		test.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,75 @@
+package fraudproofs
+
+import (
+	"errors"
+	"hash"
+
+	"github.com/lazyledger/smt"
+)
+
+// ErrMissingStateKey is returned when a block is checked against a partial state tree that does not hold a key the
+// block needs.
+var ErrMissingStateKey = errors.New("key is missing from the partial state tree")
+
+// partialStore is the store of a partial state tree: the nodes off the Merkle paths of its keys are missing from it.
+type partialStore struct {
+	smt.MapStore
+}
+
+// Get returns the value stored for the node, or ErrMissingStateKey if the node is off the Merkle paths of the keys.
+func (s partialStore) Get(key []byte) ([]byte, error) {
+	value, err := s.MapStore.Get(key)
+	if err != nil {
+		return nil, ErrMissingStateKey
+	}
+	return value, nil
+}
+
+// NewPartialStateTree creates a state tree of the given root and hash function holding only the given keys, from their
+// values and Merkle proofs; a block touching only these keys can then be checked against it with CheckBlock, which
+// returns ErrMissingStateKey if the block needs another key. This lets a verifier of a shard of the state check a block
+// without holding the whole state.
+func NewPartialStateTree(root []byte, keys, values [][]byte, proofs []smt.SparseMerkleProof,
+	newHash func() hash.Hash) (*smt.SparseMerkleTree, error) {
+	if len(keys) != len(values) || len(keys) != len(proofs) {
+		return nil, errors.New("keys, values and proofs must have the same length")
+	}
+	partial := smt.NewDeepSparseMerkleSubTree(partialStore{smt.NewSimpleMap()}, newHash(),
+		append([]byte{}, root...))
+	for i := 0; i < len(keys); i++ {
+		err := partial.AddBranch(proofs[i], keys[i], values[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return partial.SparseMerkleTree, nil
+}
+
+// PartialStateTree returns the partial state tree holding only the keys touched by the block (see
+// NewPartialStateTree), taken from the state tree holding the state preceding the block.
+func (b *Block) PartialStateTree(stateTree *smt.SparseMerkleTree) (*smt.SparseMerkleTree, error) {
+	if stateTree == nil {
+		return nil, ErrNilStateTree
+	}
+	var keys, values [][]byte
+	var proofs []smt.SparseMerkleProof
+	touched := make(map[string]bool)
+	for i := 0; i < len(b.transactions); i++ {
+		for _, key := range append(b.transactions[i].stateKeys(), b.transactions[i].readKeys...) {
+			if touched[string(key)] {
+				continue
+			}
+			touched[string(key)] = true
+			value, err := stateTree.Get(key)
+			if err != nil {
+				return nil, err
+			}
+			proof, err := stateTree.Prove(key)
+			if err != nil {
+				return nil, err
+			}
+			keys, values, proofs = append(keys, key), append(values, value), append(proofs, proof)
+		}
+	}
+	return NewPartialStateTree(stateTree.Root(), keys, values, proofs, b.newHash)
+}
@@ -0,0 +1,57 @@
+package fraudproofs
+
+import (
+	"errors"
+)
+
+// ErrTooManyChunks is returned when a fraud proof holds more chunks than allowed by the verification policy.
+var ErrTooManyChunks = errors.New("fraud proof holds too many chunks")
+
+// ErrTooManyStateProofs is returned when a fraud proof holds more state proofs than allowed by the verification policy.
+var ErrTooManyStateProofs = errors.New("fraud proof holds too many state proofs")
+
+// ErrTooManyLeaves is returned when a fraud proof claims a data tree with more leaves than allowed by the verification
+// policy.
+var ErrTooManyLeaves = errors.New("fraud proof claims too many leaves")
+
+// VerifyPolicy bounds the work a single untrusted fraud proof can impose on its verifier; a zero limit means no limit.
+// It also carries the consensus cap on the number of transactions of a block, against which fraud proofs of kind
+// KindTooManyTransactions are verified; without a cap, they never verify.
+type VerifyPolicy struct {
+	MaxChunks       int
+	MaxStateProofs  int
+	MaxLeaves       uint64
+	MaxTransactions uint64
+}
+
+// WithVerifyPolicy sets the verification policy applied by VerifyFraudProof.
+func WithVerifyPolicy(p VerifyPolicy) BlockOption {
+	return func(b *Block) {
+		b.verifyPolicy = p
+	}
+}
+
+// Check returns an error if the fraud proof exceeds the limits of the policy.
+func (p VerifyPolicy) Check(fp *FraudProof) error {
+	if p.MaxChunks > 0 && len(fp.chunks) > p.MaxChunks {
+		return ErrTooManyChunks
+	}
+	if p.MaxStateProofs > 0 && len(fp.proofState) > p.MaxStateProofs {
+		return ErrTooManyStateProofs
+	}
+	if p.MaxLeaves > 0 && fp.numOfLeaves > p.MaxLeaves {
+		return ErrTooManyLeaves
+	}
+	return nil
+}
+
+// VerifyFraudProofWithPolicy verifies whether or not a fraud proof is valid, rejecting it without verification if it
+// exceeds the limits of the policy; the error then tells which limit is exceeded. The policy replaces the one of the
+// block.
+func (b *Block) VerifyFraudProofWithPolicy(fp FraudProof, policy VerifyPolicy) (bool, error) {
+	err := policy.Check(&fp)
+	if err != nil {
+		return false, err
+	}
+	return b.verifyFraudProof(fp, policy.MaxTransactions), nil
+}
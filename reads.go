@@ -0,0 +1,72 @@
+package fraudproofs
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/lazyledger/smt"
+)
+
+// ErrInvalidRead is returned when a transaction declares readData that do not match the value of its key in the state
+// it reads from.
+var ErrInvalidRead = errors.New("transaction declares readData that do not match the state")
+
+// firstInvalidRead returns the indexes of the first transaction (and of its read) declaring readData that do not match
+// the state it reads from, or -1 if every readData is consistent. The state tree must hold the state preceding the
+// transactions, with the values encoded with the given encoding.
+func firstInvalidRead(t []Transaction, stateTree *smt.SparseMerkleTree, e StateEncoding) (int, int, error) {
+	written := make(map[string][]byte)
+	for i := 0; i < len(t); i++ {
+		for j := 0; j < len(t[i].readKeys); j++ {
+			data, ok := written[string(t[i].readKeys[j])]
+			if !ok || t[i].readFromPreBlock {
+				value, err := stateTree.Get(t[i].readKeys[j])
+				if err != nil {
+					return 0, 0, err
+				}
+				data = e.decode(value)
+			}
+			if !bytes.Equal(t[i].readData[j], data) {
+				return i, j, nil
+			}
+		}
+		for j := 0; j < len(t[i].writeKeys); j++ {
+			written[string(t[i].writeKeys[j])] = t[i].newData[j]
+		}
+	}
+	return -1, -1, nil
+}
+
+// invalidReadFraudProof generates a fraud proof for the j-th read of the i-th transaction of the block, which declares
+// invalid readData. The state tree must hold the state preceding the block.
+func (b *Block) invalidReadFraudProof(i, j int, stateTree *smt.SparseMerkleTree) (*FraudProof, error) {
+	return b.keyFraudProof(KindInvalidRead, i, b.transactions[i].readKeys[j], stateTree,
+		b.transactions[i].readFromPreBlock)
+}
+
+// verifyInvalidReadFraudProof verifies a fraud proof claiming that a transaction of the block declares invalid
+// readData.
+func (b *Block) verifyInvalidReadFraudProof(fp FraudProof) bool {
+	if !b.checkReads {
+		return false
+	}
+	t, value, ok := b.verifyKeyFraudProof(fp)
+	if !ok {
+		return false
+	}
+
+	// replay the previous writes of the key since the intermediate state root, unless reading from the pre-block state
+	key := fp.readKeys[0]
+	invalid := t[len(t)-1]
+	data, written := lastWrite(t[:len(t)-1], key)
+	if !written || invalid.readFromPreBlock {
+		data = b.stateEncoding.decode(value)
+	}
+
+	for j := 0; j < len(invalid.readKeys); j++ {
+		if bytes.Equal(invalid.readKeys[j], key) && !bytes.Equal(invalid.readData[j], data) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package fraudproofs
+
+import (
+	"errors"
+	"hash"
+	"math/bits"
+	"sync"
+
+	"github.com/lazyledger/smt"
+)
+
+// ErrConflictingTransactions is returned when a block built with shards (see WithShards) holds conflicting
+// transactions.
+var ErrConflictingTransactions = errors.New("transactions of the block conflict")
+
+// WithShards sets the number of shards applying the writes of the block to the state in parallel (up to 256, rounded up
+// to a power of two; 0 or 1 applies them sequentially). The writes are partitioned by the first bits of the paths of
+// their keys, each shard applies its writes to the subtree of its range, and the roots of the subtrees are combined
+// into the state root of the block. The transactions must not conflict (see Conflicts), so that the state following
+// them does not depend on the order of their writes; NewBlock returns ErrConflictingTransactions otherwise. The state
+// tree given to NewBlock is still updated sequentially, along with the intermediate state roots.
+func WithShards(n int) BlockOption {
+	return func(b *Block) {
+		b.shards = n
+	}
+}
+
+// Conflicts returns the indexes of the first pair of conflicting transactions, or -1 if none conflict: two transactions
+// conflict if they write the same key of the state tree (including the nonce of a sender), or if one reads a key the
+// other writes.
+func Conflicts(t []Transaction) (int, int) {
+	writers := make(map[string]int) // first transaction writing each key
+	readers := make(map[string]int) // first transaction reading each key
+	for j := 0; j < len(t); j++ {
+		for _, key := range t[j].stateKeys() {
+			if i, ok := writers[string(key)]; ok && i != j {
+				return i, j
+			}
+			if i, ok := readers[string(key)]; ok && i != j {
+				return i, j
+			}
+		}
+		for _, key := range t[j].readKeys {
+			if i, ok := writers[string(key)]; ok && i != j {
+				return i, j
+			}
+		}
+		for _, key := range t[j].stateKeys() {
+			if _, ok := writers[string(key)]; !ok {
+				writers[string(key)] = j
+			}
+		}
+		for _, key := range t[j].readKeys {
+			if _, ok := readers[string(key)]; !ok {
+				readers[string(key)] = j
+			}
+		}
+	}
+	return -1, -1
+}
+
+// shardWrite is a write applied by a shard, along with the proof of the value of its key preceding the block.
+type shardWrite struct {
+	key, oldValue, newValue []byte
+	proof                   smt.SparseCompactMerkleProof
+}
+
+// shardedStateRoot returns the root of the state following the conflict-free transactions, applying the writes of
+// every shard in its own goroutine (see WithShards). The state tree must hold the state preceding the transactions; it
+// is left untouched.
+func shardedStateRoot(t []Transaction, stateTree *smt.SparseMerkleTree, shards int, newHash func() hash.Hash,
+	e StateEncoding) ([]byte, error) {
+	if shards > 256 {
+		shards = 256
+	}
+	depth := bits.Len(uint(shards - 1)) // number of bits of the paths selecting the shard
+	preStateRoot := append([]byte{}, stateTree.Root()...)
+
+	// partition the writes by the first bits of the paths of their keys, reading the state sequentially
+	writes := make([][]shardWrite, 1<<uint(depth))
+	for key, value := range writtenValues(t, e) {
+		shard := int(keyPath([]byte(key), newHash)[0]) >> uint(8-depth)
+		oldValue, err := stateTree.Get([]byte(key))
+		if err != nil {
+			return nil, err
+		}
+		proof, err := stateTree.ProveCompact([]byte(key))
+		if err != nil {
+			return nil, err
+		}
+		writes[shard] = append(writes[shard], shardWrite{[]byte(key), oldValue, value, proof})
+	}
+
+	// apply the writes of every shard to the subtree of its range
+	subtrees := make([]*provenState, len(writes))
+	errs := make([]error, len(writes))
+	var wg sync.WaitGroup
+	for s := 0; s < len(writes); s++ {
+		if len(writes[s]) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(s int) {
+			defer wg.Done()
+			state := newProvenState(preStateRoot, newHash)
+			for _, w := range writes[s] {
+				errs[s] = state.add(w.proof, w.key, w.oldValue)
+				if errs[s] != nil {
+					return
+				}
+			}
+			for _, w := range writes[s] {
+				errs[s] = state.update(w.key, w.newValue)
+				if errs[s] != nil {
+					return
+				}
+			}
+			subtrees[s] = state
+		}(s)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// graft the roots of the subtrees on the state preceding the block, whose branches leading to them are revealed by
+	// the proofs of the shards
+	state := newProvenState(preStateRoot, newHash)
+	for _, subtree := range subtrees {
+		if subtree == nil {
+			continue
+		}
+		for node, children := range subtree.children {
+			state.children[node] = children
+		}
+	}
+	for s, subtree := range subtrees {
+		if subtree == nil {
+			continue
+		}
+		prefix := []byte{byte(s << uint(8-depth))}
+		node, _, err := subtree.branch(prefix, depth)
+		if err != nil {
+			return nil, err
+		}
+		err = state.graft(prefix, depth, node)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return state.root(), nil
+}
@@ -0,0 +1,61 @@
+package fraudproofs
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+)
+
+// ErrNotSigned is returned when re-signing a block that has not been signed.
+var ErrNotSigned = errors.New("block has not been signed")
+
+// ErrProposerMismatch is returned when re-signing a block with a key other than its proposer's.
+var ErrProposerMismatch = errors.New("private key does not belong to the proposer of the block")
+
+// SignedHeader is a block header signed by the block's proposer.
+type SignedHeader struct {
+	header    BlockHeader
+	proposer  ed25519.PublicKey
+	signature []byte
+}
+
+// SignHeader signs the hash of the header with the proposer's private key.
+func SignHeader(h BlockHeader, priv ed25519.PrivateKey) SignedHeader {
+	return SignedHeader{
+		h,
+		priv.Public().(ed25519.PublicKey),
+		ed25519.Sign(priv, h.Hash())}
+}
+
+// Verify checks the signature of the header.
+func (sh SignedHeader) Verify() bool {
+	if len(sh.proposer) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.Verify(sh.proposer, sh.header.Hash(), sh.signature)
+}
+
+// Sign signs the header of the block with the proposer's private key.
+func (b *Block) Sign(priv ed25519.PrivateKey) {
+	sh := SignHeader(b.Header(), priv)
+	b.proposer = sh.proposer
+	b.signature = sh.signature
+}
+
+// ReSign signs the header of the block again after some of its fields (eg. the timestamp) changed, so that the block
+// does not carry a stale signature. The private key must be the proposer's.
+func (b *Block) ReSign(priv ed25519.PrivateKey) error {
+	if len(b.signature) == 0 {
+		return ErrNotSigned
+	}
+	if !bytes.Equal(priv.Public().(ed25519.PublicKey), b.proposer) {
+		return ErrProposerMismatch
+	}
+	b.Sign(priv)
+	return nil
+}
+
+// SignedHeader returns the header of the block along with its signature.
+func (b *Block) SignedHeader() SignedHeader {
+	return SignedHeader{b.Header(), b.proposer, b.signature}
+}
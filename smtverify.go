@@ -0,0 +1,195 @@
+package fraudproofs
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+)
+
+// The verifiers of fraud proofs only check state proofs through the functions of this file, which recompute the
+// hashes of the sparse Merkle tree from the compact state proofs carried by the fraud proofs; only the provers build
+// state trees with the smt package.
+//
+// The state tree has one level per bit of the hash function. The leaf of a key sits at its path (the hash of the key,
+// read from the most significant bit) and hashes as 0x00 || path || value, an inner node hashes as 0x01 || left ||
+// right, and empty subtrees hash to zero. A compact state proof starts with a bitmask of the non-empty side nodes,
+// followed by them from the root down.
+
+var errInvalidStateProof = errors.New("invalid state proof")
+
+// compactProof is a compact state proof, laid out as smt.SparseCompactMerkleProof. It is an alias of an unnamed type,
+// so that the proofs built by the smt package are verified as is, without the verifiers depending on the package.
+type compactProof = [][]byte
+
+// leafDigest returns the hash of the leaf of the path holding the value (zero if the value is empty).
+func leafDigest(h hash.Hash, path, value []byte) []byte {
+	if len(value) == 0 {
+		return make([]byte, h.Size())
+	}
+	h.Reset()
+	h.Write([]byte{0})
+	h.Write(path)
+	h.Write(value)
+	return h.Sum(nil)
+}
+
+// nodeDigest returns the hash of the inner node of the given children (zero if both are empty).
+func nodeDigest(h hash.Hash, left, right []byte) []byte {
+	if isEmptyNode(left) && isEmptyNode(right) {
+		return make([]byte, h.Size())
+	}
+	h.Reset()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// isEmptyNode returns whether the node is the hash of an empty subtree.
+func isEmptyNode(node []byte) bool {
+	for _, b := range node {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// pathBit returns the i-th bit of the path, from the most significant bit: 0 goes left, 1 goes right.
+func pathBit(path []byte, i int) int {
+	return int(path[i/8]>>uint(7-i%8)) & 1
+}
+
+// sideNodes expands the compact state proof into the side nodes of the path, from the root down.
+func sideNodes(proof compactProof, size int) ([][]byte, error) {
+	if len(proof) == 0 || len(proof[0]) != size {
+		return nil, errInvalidStateProof
+	}
+	bitmask, nodes := proof[0], proof[1:]
+	side := make([][]byte, 8*size)
+	for i := 0; i < len(side); i++ {
+		if pathBit(bitmask, i) == 0 {
+			side[i] = make([]byte, size)
+			continue
+		}
+		if len(nodes) == 0 || len(nodes[0]) != size {
+			return nil, errInvalidStateProof
+		}
+		side[i], nodes = nodes[0], nodes[1:]
+	}
+	if len(nodes) != 0 {
+		return nil, errInvalidStateProof
+	}
+	return side, nil
+}
+
+// branchRoot returns the root of the tree holding the value at the path with the given side nodes, and calls visit
+// with every inner node of the branch along with its children.
+func branchRoot(h hash.Hash, path, value []byte, side [][]byte, visit func(node, left, right []byte)) []byte {
+	return foldBranch(h, path, leafDigest(h, path, value), side, visit)
+}
+
+// foldBranch returns the root of the tree holding the node at the path, at the depth of the given side nodes (ie. the
+// subtree of the node holds the keys whose paths start with the first len(side) bits of the path), and calls visit with
+// every inner node of the branch along with its children.
+func foldBranch(h hash.Hash, path, node []byte, side [][]byte, visit func(node, left, right []byte)) []byte {
+	for i := len(side) - 1; i >= 0; i-- {
+		left, right := node, side[i]
+		if pathBit(path, i) == 1 {
+			left, right = side[i], node
+		}
+		node = nodeDigest(h, left, right)
+		if visit != nil {
+			visit(node, left, right)
+		}
+	}
+	return node
+}
+
+// verifyStateProof checks that the compact state proof commits the value of the key in the state of the given root.
+func verifyStateProof(proof compactProof, root, key, value []byte, newHash func() hash.Hash) bool {
+	h := newHash()
+	side, err := sideNodes(proof, h.Size())
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(branchRoot(h, keyPath(key, newHash), value, side, nil), root)
+}
+
+// provenState is the part of a state revealed by compact state proofs, ie. the branches of the proven keys; updating
+// the proven keys recomputes the root of the state.
+type provenState struct {
+	newHash  func() hash.Hash
+	hasher   hash.Hash
+	rootNode []byte
+	children map[string][2][]byte // children of the revealed inner nodes, by hash
+}
+
+// newProvenState returns the part of the state of the given root revealed by no proof yet.
+func newProvenState(root []byte, newHash func() hash.Hash) *provenState {
+	return &provenState{newHash, newHash(), append([]byte{}, root...), make(map[string][2][]byte)}
+}
+
+// add reveals the branch of the key, after checking that the compact state proof commits its value in the state.
+func (ps *provenState) add(proof compactProof, key, value []byte) error {
+	side, err := sideNodes(proof, ps.hasher.Size())
+	if err != nil {
+		return err
+	}
+	branch := make(map[string][2][]byte)
+	root := branchRoot(ps.hasher, keyPath(key, ps.newHash), value, side, func(node, left, right []byte) {
+		branch[string(node)] = [2][]byte{left, right}
+	})
+	if !bytes.Equal(root, ps.rootNode) {
+		return errInvalidStateProof
+	}
+	for node, children := range branch {
+		ps.children[node] = children
+	}
+	return nil
+}
+
+// update sets the value of a proven key.
+func (ps *provenState) update(key, value []byte) error {
+	path := keyPath(key, ps.newHash)
+	return ps.graft(path, 8*ps.hasher.Size(), leafDigest(ps.hasher, path, value))
+}
+
+// branch returns the node at the given depth of the path, and its side nodes from the root down.
+func (ps *provenState) branch(path []byte, depth int) ([]byte, [][]byte, error) {
+	side := make([][]byte, depth)
+	node := ps.rootNode
+	for i := 0; i < len(side); i++ {
+		if isEmptyNode(node) {
+			side[i] = node
+			continue
+		}
+		children, ok := ps.children[string(node)]
+		if !ok {
+			return nil, nil, errors.New("key is not proven")
+		}
+		node, side[i] = children[0], children[1]
+		if pathBit(path, i) == 1 {
+			node, side[i] = children[1], children[0]
+		}
+	}
+	return node, side, nil
+}
+
+// graft replaces the node at the given depth of the path, ie. the subtree of the keys whose paths start with the first
+// depth bits of the path; the branch leading to it must be proven.
+func (ps *provenState) graft(path []byte, depth int, node []byte) error {
+	_, side, err := ps.branch(path, depth)
+	if err != nil {
+		return err
+	}
+	ps.rootNode = foldBranch(ps.hasher, path, node, side, func(node, left, right []byte) {
+		ps.children[string(node)] = [2][]byte{left, right}
+	})
+	return nil
+}
+
+// root returns the root of the state, including the updates of the proven keys.
+func (ps *provenState) root() []byte {
+	return ps.rootNode
+}
@@ -0,0 +1,311 @@
+package fraudproofs
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+
+	"github.com/lazyledger/smt"
+)
+
+// SparseBlock is a block of which only a range of transactions is revealed, along with the intermediate state roots
+// surrounding them and the chunks of the data tree holding them, which prove their inclusion in the block. It lets a
+// node check the intermediate state roots of the range, and prove them invalid, without downloading the whole block.
+type SparseBlock struct {
+	// data structure
+	dataRoot        []byte
+	preStateRoot    []byte        // pre-state root of the block (used if the range starts at the first transaction)
+	from            int           // index of the first revealed transaction (multiple of Step)
+	transactions    []Transaction // revealed transactions, starting at the index 'from'
+	interStateRoots [][]byte      // intermediate state roots preceding (if any) and following the groups of the range
+
+	// inclusion proofs
+	chunks        [][]byte
+	proofChunks   [][][]byte
+	chunksIndexes []uint64
+	numOfLeaves   uint64
+	offset        uint64 // position of the first revealed entry in the data of the chunks
+
+	// implementation specific
+	chunkSize      int
+	newHash        func() hash.Hash
+	hashAlgorithm  HashAlgorithm
+	dataTreeScheme DataTreeScheme
+	stateEncoding  StateEncoding
+}
+
+// Sparse returns the sparse block revealing the transactions [from, to) of the block; 'from' must be a multiple of
+// Step, so that the range starts on an intermediate state root.
+func (b *Block) Sparse(from, to int) (*SparseBlock, error) {
+	if from < 0 || from >= to || to > len(b.transactions) || from%Step != 0 {
+		return nil, errors.New("range is not aligned on intermediate state roots")
+	}
+	tree, err := b.ensureDataTree()
+	if err != nil {
+		return nil, err
+	}
+	positions := tree.positions
+
+	// locate the revealed entries in the data of the chunks
+	first := 0
+	start := positions[from]
+	if from > 0 {
+		first = from/Step - 1
+		start -= 1 + len(b.interStateRoots[first])
+	}
+	end := positions[to-1] + 1 + len(b.transactions[to-1].Serialize())
+	if to%Step == 0 {
+		end += 1 + len(b.interStateRoots[to/Step-1])
+	}
+	size := b.chunkSize - 1
+	var chunksIndexes []uint64
+	for j := start / size; j <= (end-1)/size; j++ {
+		chunksIndexes = append(chunksIndexes, uint64(j))
+	}
+	chunks, proofChunks, numOfLeaves, err := b.proveChunks(chunksIndexes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SparseBlock{
+		dataRoot:        b.dataRoot,
+		preStateRoot:    b.preStateRoot,
+		from:            from,
+		transactions:    append([]Transaction{}, b.transactions[from:to]...),
+		interStateRoots: copySlices(b.interStateRoots[first : to/Step]),
+		chunks:          chunks,
+		proofChunks:     proofChunks,
+		chunksIndexes:   chunksIndexes,
+		numOfLeaves:     numOfLeaves,
+		offset:          uint64(start % size),
+		chunkSize:       b.chunkSize,
+		newHash:         b.newHash,
+		hashAlgorithm:   b.hashAlgorithm,
+		dataTreeScheme:  b.dataTreeScheme,
+		stateEncoding:   b.stateEncoding}, nil
+}
+
+// entries returns the revealed transactions and intermediate state roots tagged with their leaf kind, as laid out in
+// the data of the chunks, along with the position of each transaction.
+func (sb *SparseBlock) entries() ([]byte, []int) {
+	var buff []byte
+	interStateRoots := sb.interStateRoots
+	if sb.from > 0 {
+		buff = append(append(buff, byte(LeafStateRoot)), interStateRoots[0]...)
+		interStateRoots = interStateRoots[1:]
+	}
+	positions := make([]int, len(sb.transactions))
+	for j := 0; j < len(sb.transactions); j++ {
+		positions[j] = len(buff)
+		buff = append(append(buff, byte(LeafTransaction)), sb.transactions[j].Serialize()...)
+		if (sb.from+j+1)%Step == 0 {
+			buff = append(append(buff, byte(LeafStateRoot)), interStateRoots[0]...)
+			interStateRoots = interStateRoots[1:]
+		}
+	}
+	return buff, positions
+}
+
+// verifyEntries checks that the chunks of the sparse block are in its data tree, and hold the revealed entries.
+func (sb *SparseBlock) verifyEntries(entries []byte) bool {
+	fp := &FraudProof{chunks: sb.chunks, proofChunks: sb.proofChunks, chunksIndexes: sb.chunksIndexes,
+		numOfLeaves: sb.numOfLeaves}
+	if !fp.verifyChunks(sb.dataRoot, sb.newHash, sb.dataTreeScheme) {
+		return false
+	}
+	var buff []byte
+	for i := 0; i < len(sb.chunks); i++ {
+		if sb.chunksIndexes[i] != sb.chunksIndexes[0]+uint64(i) {
+			return false
+		}
+		buff = append(buff, sb.chunks[i][1:]...)
+	}
+	return sb.offset+uint64(len(entries)) <= uint64(len(buff)) &&
+		bytes.Equal(buff[sb.offset:sb.offset+uint64(len(entries))], entries)
+}
+
+// CheckBlock checks that the intermediate state roots following the revealed groups of transactions are constructed
+// correctly, and returns a fraud proof if they are not. The state tree must hold the state preceding the first revealed
+// transaction. The chunks prove that the revealed data is in the block, but not the index of the revealed transactions;
+// the fraud proof does not verify against the block if the index is wrong.
+func (sb *SparseBlock) CheckBlock(stateTree *smt.SparseMerkleTree) (*FraudProof, error) {
+	if stateTree == nil {
+		return nil, ErrNilStateTree
+	}
+	next := 0 // index of the intermediate state root following the current group
+	if sb.from > 0 {
+		next = 1
+	}
+	if len(sb.interStateRoots) != next+(sb.from+len(sb.transactions))/Step-sb.from/Step {
+		return nil, errors.New("wrong number of intermediate state roots")
+	}
+	entries, positions := sb.entries()
+	if !sb.verifyEntries(entries) {
+		return nil, ErrInvalidDataRoot
+	}
+	preStateRoot := sb.preStateRoot
+	if sb.from > 0 {
+		preStateRoot = sb.interStateRoots[0]
+	}
+	if !bytes.Equal(stateTree.Root(), preStateRoot) {
+		return nil, ErrPreStateRootMismatch
+	}
+
+	var group *FraudProof
+	for j := 0; j < len(sb.transactions); j++ {
+		if j%Step == 0 {
+			end := j + Step
+			if end > len(sb.transactions) {
+				end = len(sb.transactions)
+			}
+			var err error
+			group, err = groupStateProof(sb.transactions[j:end], stateTree, sb.chunkSize, sb.newHash,
+				sb.hashAlgorithm, sb.stateEncoding)
+			if err != nil {
+				return nil, err
+			}
+		}
+		_, _, err := fillStateTree(sb.transactions[j:j+1], stateTree, sb.stateEncoding)
+		if err != nil {
+			return nil, err
+		}
+		if (j+1)%Step != 0 {
+			continue
+		}
+		if !bytes.Equal(stateTree.Root(), sb.interStateRoots[next]) {
+			return sb.interStateRootFraudProof(j+1-Step, group, positions)
+		}
+		next++
+	}
+	return nil, nil
+}
+
+// interStateRootFraudProof completes the partial fraud proof returned by groupStateProof for the group of revealed
+// transactions starting at the j-th one, whose following intermediate state root is invalid.
+func (sb *SparseBlock) interStateRootFraudProof(j int, group *FraudProof, positions []int) (*FraudProof, error) {
+	last := j + Step - 1
+	start := int(sb.offset) + positions[j]
+	if sb.from+j > 0 {
+		k := j / Step // index of the intermediate state root preceding the group
+		if sb.from == 0 {
+			k--
+		}
+		start -= 1 + len(sb.interStateRoots[k])
+	}
+	end := int(sb.offset) + positions[last] + 1 + len(sb.transactions[last].Serialize())
+	size := sb.chunkSize - 1
+	firstChunk, lastChunk := start/size, (end-1)/size
+
+	next := j / Step // index of the intermediate state root following the group
+	if sb.from > 0 {
+		next++
+	}
+
+	fp := group.Copy()
+	fp.kind = KindInvalidInterStateRoot
+	fp.postStateRoot = append([]byte{}, sb.interStateRoots[next]...)
+	fp.chunks = copySlices(sb.chunks[firstChunk : lastChunk+1])
+	for i := firstChunk; i <= lastChunk; i++ {
+		fp.proofChunks = append(fp.proofChunks, copySlices(sb.proofChunks[i]))
+	}
+	fp.chunksIndexes = append([]uint64{}, sb.chunksIndexes[firstChunk:lastChunk+1]...)
+	fp.numOfLeaves = sb.numOfLeaves
+	fp.txIndex = uint64(sb.from + last)
+	fp.offset = uint64(int(sb.offset) + positions[j] - firstChunk*size)
+	return fp, nil
+}
+
+// verifyInterStateRootFraudProof verifies a fraud proof claiming that an intermediate state root of the block does not
+// result from the group of transactions preceding it.
+func (b *Block) verifyInterStateRootFraudProof(fp FraudProof) bool {
+	t, preStateRoot, ok := b.extractTransactions(fp)
+	if !ok {
+		return false
+	}
+	claimed, ok := b.claimedStateRoot(fp, t)
+	if !ok || !fp.matchesTransition(preStateRoot, claimed) {
+		return false
+	}
+
+	// check the values of the keys updated by the group in the previous intermediate state, and replay the group
+	root, err := fp.recomputeRoot(preStateRoot, b.newHash, b.stateEncoding)
+	if err != nil {
+		return false
+	}
+	return !bytes.Equal(root, claimed)
+}
+
+// replayPrecedingGroups brings the state tree to the intermediate state root preceding the group of the i-th
+// transaction, checking the intermediate state roots on the way: fraud proofs about the transaction are verified
+// against the root claimed by the block, so a wrong one is disputed first, and its fraud proof is returned instead. The
+// state tree must hold the state preceding the block; it is left unchanged if a fraud proof is returned.
+func (b *Block) replayPrecedingGroups(i int, stateTree *smt.SparseMerkleTree) (*FraudProof, error) {
+	preStateRoot := append([]byte{}, stateTree.Root()...)
+	for k := 0; k < i/Step; k++ {
+		err := replayTransactions(b.transactions[k*Step:(k+1)*Step], stateTree, b.stateEncoding)
+		if err != nil {
+			return nil, err
+		}
+		if len(b.interStateRoots) <= k || !bytes.Equal(stateTree.Root(), b.interStateRoots[k]) {
+			stateTree.SetRoot(preStateRoot)
+			return b.replayFraudProof((k+1)*Step-1, stateTree)
+		}
+	}
+	return nil, nil
+}
+
+// replayFraudProof generates a fraud proof of an invalid intermediate state root for the group of transactions ending
+// with the last-th transaction, whose state root is the intermediate state root following it or, for the last
+// transactions of the block not completing a group, the state root of the block. The state tree must hold the state
+// preceding the block.
+func (b *Block) replayFraudProof(last int, stateTree *smt.SparseMerkleTree) (*FraudProof, error) {
+	first := last - last%Step
+	err := replayTransactions(b.transactions[:first], stateTree, b.stateEncoding)
+	if err != nil {
+		return nil, err
+	}
+	group, err := b.groupStateProof(first, stateTree)
+	if err != nil {
+		return nil, err
+	}
+	fp, err := b.expectedRootFraudProof(last, group)
+	if err != nil {
+		return nil, err
+	}
+	fp.kind = KindInvalidInterStateRoot
+	fp.postStateRoot = append([]byte{}, b.stateRoot...)
+	if last%Step == Step-1 {
+		fp.postStateRoot = append([]byte{}, b.interStateRoots[last/Step]...)
+	}
+	return fp, nil
+}
+
+// claimedStateRoot returns the state root claimed by the block after the transactions revealed by a fraud proof of an
+// invalid intermediate state root: the intermediate state root following their group or, if they are the last
+// transactions of the block and do not complete a group, the state root of the block.
+func (b *Block) claimedStateRoot(fp FraudProof, t []*Transaction) ([]byte, bool) {
+	k := fp.txIndex / uint64(Step)
+	if fp.txIndex%uint64(Step) == uint64(Step-1) {
+		if k >= uint64(len(b.interStateRoots)) {
+			return nil, false
+		}
+		return b.interStateRoots[k], true
+	}
+
+	// the revealed transactions must end the data of the last chunk of the data tree
+	if k != uint64(len(b.interStateRoots)) || fp.chunksIndexes[len(fp.chunksIndexes)-1] != fp.numOfLeaves-1 {
+		return nil, false
+	}
+	size := 0
+	for i := 0; i < len(fp.chunks); i++ {
+		size += len(fp.chunks[i]) - 1
+	}
+	end := int(fp.offset)
+	for i := 0; i < len(t); i++ {
+		end += 1 + t[i].Size()
+	}
+	if end != size {
+		return nil, false
+	}
+	return b.stateRoot, true
+}
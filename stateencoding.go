@@ -0,0 +1,83 @@
+package fraudproofs
+
+import (
+	"encoding/binary"
+)
+
+// StateEncoding is the encoding of the data written by the transactions into the values of the state tree. The nonces
+// of the senders are always stored in the raw encoding.
+type StateEncoding byte
+
+const (
+	// RawEncoding prefixes the data with its length (see stateValue); it is the default encoding.
+	RawEncoding StateEncoding = iota
+	// RLPEncoding encodes the data as an RLP string, so that the state roots match the ones of Ethereum tooling.
+	RLPEncoding
+)
+
+// encode encodes data as stored in the state tree.
+func (e StateEncoding) encode(data []byte) []byte {
+	if e == RLPEncoding {
+		return rlpString(data)
+	}
+	return stateValue(data)
+}
+
+// decode decodes a value stored in the state tree, and returns nil if it is not encoded correctly.
+func (e StateEncoding) decode(value []byte) []byte {
+	if e == RLPEncoding {
+		return rlpData(value)
+	}
+	return stateData(value)
+}
+
+// rlpString encodes data as an RLP string: a single byte below 0x80 is its own encoding, shorter strings are prefixed
+// with 0x80 plus their length, and longer strings with 0xb7 plus the size of their big-endian length, then the length.
+func rlpString(data []byte) []byte {
+	if len(data) == 1 && data[0] < 0x80 {
+		return []byte{data[0]}
+	}
+	if len(data) <= 55 {
+		return append([]byte{0x80 + byte(len(data))}, data...)
+	}
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(len(data)))
+	for len(size) > 1 && size[0] == 0 {
+		size = size[1:]
+	}
+	value := append([]byte{0xb7 + byte(len(size))}, size...)
+	return append(value, data...)
+}
+
+// rlpData decodes an RLP string, and returns nil if the value is not a canonical RLP string.
+func rlpData(value []byte) []byte {
+	if len(value) == 0 || value[0] >= 0xc0 {
+		return nil // RLP lists are not state data
+	}
+	switch {
+	case value[0] < 0x80:
+		if len(value) != 1 {
+			return nil
+		}
+		return value
+	case value[0] <= 0xb7:
+		size := int(value[0] - 0x80)
+		if len(value) != 1+size || (size == 1 && value[1] < 0x80) {
+			return nil
+		}
+		return value[1:]
+	default:
+		n := int(value[0] - 0xb7)
+		if len(value) < 1+n || value[1] == 0 {
+			return nil
+		}
+		var size uint64
+		for _, b := range value[1 : 1+n] {
+			size = size<<8 | uint64(b)
+		}
+		if size <= 55 || uint64(len(value)-1-n) != size {
+			return nil
+		}
+		return value[1+n:]
+	}
+}
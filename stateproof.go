@@ -0,0 +1,154 @@
+package fraudproofs
+
+import (
+	"bytes"
+	"errors"
+	"github.com/lazyledger/smt"
+)
+
+// BlockStateProof proves the net change of the state caused by a whole block, with a single membership proof for
+// each key written by the block. Keys written by the block but left with their pre-state value cannot move the state
+// root; they are grouped apart, with a membership proof of their value against the pre-state root only.
+type BlockStateProof struct {
+	preStateRoot  []byte
+	postStateRoot []byte
+	keys          [][]byte
+	oldValues     [][]byte                       // values of the keys in the pre-state, as stored in the state tree
+	newValues     [][]byte                       // values of the keys in the post-state, as stored in the state tree
+	proofs        []smt.SparseCompactMerkleProof // membership proofs of the old values against the pre-state root
+	unchanged     [][]byte                       // keys written by the block whose value is unchanged
+	// membership proofs of the values of the unchanged keys against the pre-state root
+	unchangedProofs []smt.SparseCompactMerkleProof
+}
+
+// UnchangedKeys returns the keys written by the block whose value is the same in the pre-state and the post-state.
+func (p BlockStateProof) UnchangedKeys() [][]byte {
+	return p.unchanged
+}
+
+// StateTransitionProof applies the block to the input state tree (which must hold the state preceding the block)
+// and returns a proof of the resulting state transition.
+func (b *Block) StateTransitionProof(stateTree *smt.SparseMerkleTree) (BlockStateProof, error) {
+	var p BlockStateProof
+	p.preStateRoot = append([]byte{}, stateTree.Root()...)
+
+	// collect the keys written by the block along with their pre-state values and proofs
+	written := make(map[string]bool)
+	for i := 0; i < len(b.transactions); i++ {
+		for _, key := range b.transactions[i].stateKeys() {
+			if written[string(key)] {
+				continue
+			}
+			written[string(key)] = true
+
+			value, err := stateTree.Get(key)
+			if err != nil {
+				return BlockStateProof{}, err
+			}
+			proof, err := stateTree.ProveCompact(key)
+			if err != nil {
+				return BlockStateProof{}, err
+			}
+			p.keys = append(p.keys, key)
+			p.oldValues = append(p.oldValues, value)
+			p.proofs = append(p.proofs, proof)
+		}
+	}
+
+	_, stateRoot, err := fillStateTree(b.transactions, stateTree, b.stateEncoding)
+	if err != nil {
+		return BlockStateProof{}, err
+	}
+	if !bytes.Equal(stateRoot, b.stateRoot) {
+		return BlockStateProof{}, errors.New("block state root does not match the state transition")
+	}
+	p.postStateRoot = stateRoot
+
+	// group the keys left unchanged by the block
+	keys, oldValues, proofs := p.keys, p.oldValues, p.proofs
+	p.keys, p.oldValues, p.proofs = nil, nil, nil
+	for i, key := range keys {
+		value, err := stateTree.Get(key)
+		if err != nil {
+			return BlockStateProof{}, err
+		}
+		if bytes.Equal(value, oldValues[i]) {
+			p.unchanged = append(p.unchanged, key)
+			p.unchangedProofs = append(p.unchangedProofs, proofs[i])
+			continue
+		}
+		p.keys = append(p.keys, key)
+		p.oldValues = append(p.oldValues, oldValues[i])
+		p.newValues = append(p.newValues, value)
+		p.proofs = append(p.proofs, proofs[i])
+	}
+
+	return p, nil
+}
+
+// VerifyStateTransitionProof verifies, without replaying the transactions, that the proof moves the state from the
+// block's pre-state root to its state root. The new values of the keys are derived from the transactions of the block,
+// which must be known.
+func (b *Block) VerifyStateTransitionProof(p BlockStateProof) bool {
+	if !bytes.Equal(p.preStateRoot, b.preStateRoot) || !bytes.Equal(p.postStateRoot, b.stateRoot) {
+		return false
+	}
+	if len(p.oldValues) != len(p.keys) || len(p.newValues) != len(p.keys) || len(p.proofs) != len(p.keys) ||
+		len(p.unchangedProofs) != len(p.unchanged) {
+		return false
+	}
+
+	// every key written by the block is either changed, to the value of its last write, or unchanged (holding that
+	// value in the pre-state already), but not both
+	written := writtenValues(b.transactions, b.stateEncoding)
+	if len(p.keys)+len(p.unchanged) != len(written) {
+		return false
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < len(p.keys); i++ {
+		value, ok := written[string(p.keys[i])]
+		if !ok || seen[string(p.keys[i])] || !bytes.Equal(p.newValues[i], value) {
+			return false
+		}
+		seen[string(p.keys[i])] = true
+	}
+	for i := 0; i < len(p.unchanged); i++ {
+		value, ok := written[string(p.unchanged[i])]
+		if !ok || seen[string(p.unchanged[i])] ||
+			!verifyStateProof(p.unchangedProofs[i], p.preStateRoot, p.unchanged[i], value, b.newHash) {
+			return false
+		}
+		seen[string(p.unchanged[i])] = true
+	}
+
+	state := newProvenState(p.preStateRoot, b.newHash)
+	for i := 0; i < len(p.keys); i++ {
+		err := state.add(p.proofs[i], p.keys[i], p.oldValues[i])
+		if err != nil {
+			return false
+		}
+	}
+	for i := 0; i < len(p.keys); i++ {
+		err := state.update(p.keys[i], p.newValues[i])
+		if err != nil {
+			return false
+		}
+	}
+
+	return bytes.Equal(state.root(), p.postStateRoot)
+}
+
+// writtenValues returns the value of every key written by the transactions (including the nonces of their senders)
+// after them, as stored in the state tree with the given encoding.
+func writtenValues(t []Transaction, e StateEncoding) map[string][]byte {
+	values := make(map[string][]byte)
+	for i := 0; i < len(t); i++ {
+		for j := 0; j < len(t[i].writeKeys); j++ {
+			values[string(t[i].writeKeys[j])] = e.encode(t[i].newData[j])
+		}
+		if len(t[i].sender) > 0 {
+			values[string(nonceKey(t[i].sender))] = nonceValue(t[i].nonce + 1)
+		}
+	}
+	return values
+}
@@ -0,0 +1,104 @@
+package fraudproofs
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"hash"
+
+	"github.com/lazyledger/smt"
+)
+
+// StateStore is a key-value store backing the state tree, so that the state does not have to live in memory. The
+// leveldbstore package provides an implementation backed by LevelDB.
+type StateStore interface {
+	smt.MapStore
+}
+
+// namespacedStore prefixes the keys of a state store shared by several blockchains with the namespace of each of them;
+// the length of the namespace comes first, so that a namespace is never the prefix of another one.
+type namespacedStore struct {
+	store  StateStore
+	prefix []byte
+}
+
+// newNamespacedStore returns a view of the store whose keys are in the given namespace.
+func newNamespacedStore(store StateStore, namespace []byte) *namespacedStore {
+	prefix := make([]byte, MaxSize, MaxSize+len(namespace))
+	binary.LittleEndian.PutUint16(prefix, uint16(len(namespace)))
+	return &namespacedStore{store, append(prefix, namespace...)}
+}
+
+// key returns the key in the underlying store.
+func (ns *namespacedStore) key(key []byte) []byte {
+	return append(append([]byte{}, ns.prefix...), key...)
+}
+
+// Get gets the value of a key of the namespace.
+func (ns *namespacedStore) Get(key []byte) ([]byte, error) {
+	return ns.store.Get(ns.key(key))
+}
+
+// Set sets the value of a key of the namespace.
+func (ns *namespacedStore) Set(key []byte, value []byte) error {
+	return ns.store.Set(ns.key(key), value)
+}
+
+// Delete deletes a key of the namespace.
+func (ns *namespacedStore) Delete(key []byte) error {
+	return ns.store.Delete(ns.key(key))
+}
+
+// stateRootKey is the key under which the root of the state tree is saved in a state store.
+var stateRootKey = []byte("fraudproofs/stateRoot")
+
+// OpenStateTree opens the state tree saved in the store, or an empty state tree if none has been saved. A nil hash
+// function defaults to SHA-512/256.
+func OpenStateTree(store StateStore, newHash func() hash.Hash) *smt.SparseMerkleTree {
+	if newHash == nil {
+		newHash = sha512.New512_256
+	}
+	stateTree := smt.NewSparseMerkleTree(store, newHash())
+	root, err := store.Get(stateRootKey)
+	if err == nil && len(root) > 0 {
+		stateTree.SetRoot(root)
+	}
+	return stateTree
+}
+
+// SaveStateTree saves the root of the state tree in the store, so that the state tree can be reopened later.
+func SaveStateTree(store StateStore, stateTree *smt.SparseMerkleTree) error {
+	return store.Set(stateRootKey, stateTree.Root())
+}
+
+// StateSnapshotHash returns a commitment to the full state held by the state tree, ie. its root; nodes holding the same
+// state get the same hash, whatever the order in which it has been built.
+func StateSnapshotHash(stateTree *smt.SparseMerkleTree) []byte {
+	return append([]byte{}, stateTree.Root()...)
+}
+
+// StateTreeStats returns how many of the given keys are set in the state tree, and the depth of the deepest of their
+// leaves, ie. the number of non-default nodes on its branch; the size of the state proofs grows with the depth. The
+// keys cannot be listed from the state tree itself, so the caller provides them (eg. the keys written by the blocks).
+func StateTreeStats(tree *smt.SparseMerkleTree, keys [][]byte) (numKeys int, maxDepth int) {
+	for _, key := range keys {
+		value, err := tree.Get(key)
+		if err != nil || len(value) == 0 {
+			continue
+		}
+		numKeys++
+		proof, err := tree.ProveCompact(key)
+		if err != nil || len(proof) == 0 {
+			continue
+		}
+
+		// the bitmask of the compact proof flags the non-default side nodes of the branch, from the root
+		bitmask := proof[0]
+		for i := 8*len(bitmask) - 1; i >= maxDepth; i-- {
+			if bitmask[i/8]&(1<<uint(7-i%8)) != 0 {
+				maxDepth = i + 1
+				break
+			}
+		}
+	}
+	return numKeys, maxDepth
+}
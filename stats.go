@@ -0,0 +1,58 @@
+package fraudproofs
+
+import (
+	"sync"
+	"time"
+)
+
+// CheckLatencyBuckets are the upper bounds, in seconds, of the buckets of the histogram of the time spent checking
+// blocks, as counted by Stats.
+var CheckLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// Stats are counters of the activity of a blockchain.
+type Stats struct {
+	Blocks       uint64   // blocks accepted
+	FraudProofs  uint64   // fraud proofs generated for rejected blocks
+	Checks       uint64   // blocks checked
+	CheckSeconds float64  // total time spent checking blocks
+	CheckBuckets []uint64 // number of checks that took at most each bound of CheckLatencyBuckets
+}
+
+// stats records the counters of a blockchain, which can be read while blocks are appended.
+type stats struct {
+	mu sync.Mutex
+	s  Stats
+}
+
+func newStats() *stats {
+	return &stats{s: Stats{CheckBuckets: make([]uint64, len(CheckLatencyBuckets))}}
+}
+
+// check counts a block checked in the given time.
+func (s *stats) check(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.s.Checks++
+	s.s.CheckSeconds += d.Seconds()
+	for i, bound := range CheckLatencyBuckets {
+		if d.Seconds() <= bound {
+			s.s.CheckBuckets[i]++
+		}
+	}
+}
+
+// add increments one of the counters.
+func (s *stats) add(counter *uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*counter++
+}
+
+// snapshot returns a copy of the counters.
+func (s *stats) snapshot() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := s.s
+	snapshot.CheckBuckets = append([]uint64{}, s.s.CheckBuckets...)
+	return snapshot
+}
@@ -0,0 +1,155 @@
+package fraudproofs
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/lazyledger/smt"
+)
+
+// NewBlockStreaming creates a new block with the transactions received from the channel until it is closed, and returns
+// the same block as NewBlock without the bodies of the transactions (see WithoutTransactions). The transactions are
+// checked and applied to the state tree as they arrive, and their chunks are pushed to the data tree as soon as they
+// are full; only the hashes of the transactions, the intermediate state roots and the written keys are kept in memory.
+// Unlike NewBlock, the state tree holds the state following the transactions received so far if the block is invalid;
+// the sender must then stop sending transactions, as the channel is no longer read.
+func NewBlockStreaming(txs <-chan Transaction, stateTree *smt.SparseMerkleTree, opts ...BlockOption) (*Block, error) {
+	if stateTree == nil {
+		return nil, ErrNilStateTree
+	}
+
+	b := newBlock(opts...)
+	if b.chunkSize < 2 || b.chunkSize > 256 {
+		return nil, ErrInvalidChunkSize
+	}
+	if b.newHash == nil {
+		return nil, ErrUnsupportedHashAlgorithm
+	}
+	b.preStateRoot = append([]byte{}, stateTree.Root()...)
+	b.stateRoot = b.preStateRoot
+	b.transactionHashes = [][]byte{}
+
+	dataTree := b.dataTreeScheme.orDefault().New(b.newHash())
+	c := newChunker(b.chunkSize, dataTree.Push)
+	seen := make(map[string]bool)       // hashes of the transactions received so far
+	awaited := make(map[string]bool)    // hashes of the dependencies not received yet
+	preBlock := make(map[string][]byte) // pre-block values of the keys written so far, to check the reads from it
+	written := make(map[string]bool)
+	var keys [][]byte
+	i, size := 0, 0 // number and size of the transactions received so far
+	for tx := range txs {
+		hash := tx.Hash()
+		err := b.checkStreamedTransaction(tx, stateTree, preBlock)
+		if err != nil {
+			return nil, err
+		}
+
+		// a dependency on a transaction of the block must be received before the transaction depending on it
+		for _, dependency := range tx.dependsOn {
+			if !seen[string(dependency)] {
+				awaited[string(dependency)] = true
+			}
+		}
+		if awaited[string(hash)] {
+			return nil, ErrDependencyOrder
+		}
+		seen[string(hash)] = true
+
+		if b.checkReads {
+			for _, key := range tx.writeKeys {
+				if _, ok := preBlock[string(key)]; !ok {
+					value, err := stateTree.Get(key)
+					if err != nil {
+						return nil, err
+					}
+					preBlock[string(key)] = value
+				}
+			}
+		}
+		_, root, err := fillStateTree([]Transaction{tx}, stateTree, b.stateEncoding)
+		if err != nil {
+			return nil, err
+		}
+		b.stateRoot = root
+		for _, key := range tx.stateKeys() {
+			if !written[string(key)] {
+				written[string(key)] = true
+				keys = append(keys, key)
+			}
+		}
+
+		size += tx.Size()
+		if b.maxBlockBytes > 0 && size > b.maxBlockBytes {
+			return nil, ErrBlockTooLarge
+		}
+		c.write(LeafTransaction, tx.Serialize())
+		if (i+1)%Step == 0 {
+			b.interStateRoots = append(b.interStateRoots, b.stateRoot)
+			c.write(LeafStateRoot, b.stateRoot)
+		}
+		b.transactionHashes = append(b.transactionHashes, hash)
+		i++
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	b.numTransactions = uint64(i)
+	c.flush()
+	b.dataRoot = dataTree.Root()
+	b.writeKeysRoot = writeKeysRoot(keys, b.newHash)
+	if b.maxBlockBytes > 0 && len(b.Serialize())+size > b.maxBlockBytes {
+		return nil, ErrBlockTooLarge
+	}
+	return b, nil
+}
+
+// checkStreamedTransaction checks a transaction the way NewBlock does, against the state tree holding the state
+// following the transactions received before it, and the pre-block values of the keys they write.
+func (b *Block) checkStreamedTransaction(tx Transaction, stateTree *smt.SparseMerkleTree,
+	preBlock map[string][]byte) error {
+	err := b.checkRules(&tx)
+	if err != nil {
+		return err
+	}
+
+	t := []Transaction{tx}
+	i, err := firstInvalidNonce(t, stateTree)
+	if err != nil {
+		return err
+	}
+	if i >= 0 {
+		return ErrInvalidNonce
+	}
+	if b.checkOldData {
+		i, _, err := firstStaleOldData(t, stateTree, b.stateEncoding)
+		if err != nil {
+			return err
+		}
+		if i >= 0 {
+			return ErrStaleOldData
+		}
+	}
+	if b.checkReads {
+		for j := 0; j < len(tx.readKeys); j++ {
+			value, ok := preBlock[string(tx.readKeys[j])]
+			if !ok || !tx.readFromPreBlock {
+				value, err = stateTree.Get(tx.readKeys[j])
+				if err != nil {
+					return err
+				}
+			}
+			if !bytes.Equal(tx.readData[j], b.stateEncoding.decode(value)) {
+				return ErrInvalidRead
+			}
+		}
+	}
+	if b.strictWrites {
+		i, _, err := firstBlindWrite(t, stateTree)
+		if err != nil {
+			return err
+		}
+		if i >= 0 {
+			return ErrBlindWrite
+		}
+	}
+	return nil
+}
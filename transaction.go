@@ -4,12 +4,23 @@ import (
 	"encoding/binary"
 	"errors"
 	"crypto/sha512"
+	"github.com/lazyledger/smt"
 )
 
+// ErrReadKeyDataMismatch is returned when the number of readKeys does not match the number of readData.
+var ErrReadKeyDataMismatch = errors.New("number of readKeys does not match the number of readData")
+
 // MaxSize is the number of bytes dedicated to store the size of the transaction's fields.
 // TODO: this field cannot be changed because of the function 'binary.LittleEndian.PutUint16'
 const MaxSize int = 2
 
+// flags of the optional fields of a serialized transaction
+const (
+	flagReadFromPreBlock byte = 1 << iota
+	flagArbitrary             // followed by the arbitrary data
+	flagValidUntil            // followed by the expiry of the transaction
+)
+
 // Transaction is a transaction of the blockchain.
 // It is designed only for testing & benchmarking as it is implemented very naively.
 type Transaction struct {
@@ -18,13 +29,92 @@ type Transaction struct {
 	oldData [][]byte
 	readKeys [][]byte
 	readData [][]byte
-	arbitrary []byte
+	arbitrary []byte // arbitrary payload, eg. a memo (optional; see WithStrictMode)
+	sender []byte // account whose nonce the transaction uses (optional)
+	nonce uint64
+	expectedRoot []byte // state root expected after applying the transaction (optional)
+	dependsOn [][]byte // hashes of the transactions that must precede the transaction (optional)
+	readFromPreBlock bool // whether the readData are read from the state preceding the block (optional)
+	validUntil int64 // Unix time after which the transaction expires (optional; 0 if it never expires)
+}
+
+// TxOption configures optional fields of a transaction.
+type TxOption func(*Transaction)
+
+// WithWriteKeys sets the keys written by the transaction.
+func WithWriteKeys(keys ...[]byte) TxOption {
+	return func(t *Transaction) {
+		t.writeKeys = keys
+	}
+}
+
+// WithNewData sets the data written by the transaction to its writeKeys.
+func WithNewData(data ...[]byte) TxOption {
+	return func(t *Transaction) {
+		t.newData = data
+	}
+}
+
+// WithSender sets the account sending the transaction; the nonces of the transactions of an account must follow each
+// other.
+func WithSender(sender []byte) TxOption {
+	return func(t *Transaction) {
+		t.sender = sender
+	}
+}
+
+// WithNonce sets the nonce of the transaction.
+func WithNonce(nonce uint64) TxOption {
+	return func(t *Transaction) {
+		t.nonce = nonce
+	}
+}
+
+// WithExpectedRoot sets the state root expected after applying the transaction; blocks where the transaction leads to
+// another state root are invalid.
+func WithExpectedRoot(root []byte) TxOption {
+	return func(t *Transaction) {
+		t.expectedRoot = root
+	}
+}
+
+// WithDependencies sets the hashes of the transactions that must precede the transaction when they are in the same
+// block.
+func WithDependencies(hashes ...[]byte) TxOption {
+	return func(t *Transaction) {
+		t.dependsOn = hashes
+	}
+}
+
+// WithReadFromPreBlock sets whether the readData of the transaction are read from the state preceding its block,
+// ignoring the writes of the previous transactions of the block, rather than from the state preceding the transaction.
+func WithReadFromPreBlock(read bool) TxOption {
+	return func(t *Transaction) {
+		t.readFromPreBlock = read
+	}
+}
+
+// WithValidUntil sets the Unix time after which the transaction expires; blocks whose timestamp is after it cannot hold
+// the transaction, which bounds its lifetime in the mempool.
+func WithValidUntil(validUntil int64) TxOption {
+	return func(t *Transaction) {
+		t.validUntil = validUntil
+	}
 }
 
 // NewTransaction creates a new transaction with the given keys and data.
-func NewTransaction(writeKeys, newData, oldData, readKeys, readData [][]byte, arbitrary []byte) (*Transaction, error) {
+func NewTransaction(writeKeys, newData, oldData, readKeys, readData [][]byte, arbitrary []byte,
+	opts ...TxOption) (*Transaction, error) {
 	t := &Transaction{
-		writeKeys,newData,oldData,readKeys,readData,arbitrary}
+		writeKeys: writeKeys,
+		newData: newData,
+		oldData: oldData,
+		readKeys: readKeys,
+		readData: readData,
+		arbitrary: arbitrary}
+	for _, opt := range opts {
+		opt(t)
+	}
 	err := t.CheckTransaction()
 	if err != nil {
 		return nil, err
@@ -32,19 +122,57 @@ func NewTransaction(writeKeys, newData, oldData, readKeys, readData [][]byte, ar
 	return t, nil
 }
 
+// With returns a deep copy of the transaction with the given options applied, eg. to build test scenarios out of a
+// valid transaction; the original transaction is left untouched, and the copy is not checked, so it may be malformed.
+func (t *Transaction) With(opts ...TxOption) *Transaction {
+	c := &Transaction{
+		writeKeys: copySlices(t.writeKeys),
+		newData: copySlices(t.newData),
+		oldData: copySlices(t.oldData),
+		readKeys: copySlices(t.readKeys),
+		readData: copySlices(t.readData),
+		arbitrary: copyBytes(t.arbitrary),
+		sender: copyBytes(t.sender),
+		nonce: t.nonce,
+		expectedRoot: copyBytes(t.expectedRoot),
+		dependsOn: copySlices(t.dependsOn),
+		readFromPreBlock: t.readFromPreBlock,
+		validUntil: t.validUntil}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// copyBytes returns a copy of an array of bytes.
+func copyBytes(data []byte) []byte {
+	if data == nil {
+		return nil
+	}
+	return append([]byte{}, data...)
+}
+
 // CheckTransaction verifies whether a transaction is well-formed.
 func (t *Transaction) CheckTransaction() (error) {
-	if len(t.writeKeys) != len(t.newData) || len(t.writeKeys) != len(t.oldData) || len(t.readKeys) != len(t.readData) {
+	if len(t.writeKeys) != len(t.newData) || len(t.writeKeys) != len(t.oldData) {
 		return errors.New("number of keys does not match the number of data")
 	}
-	if len(t.writeKeys) != len(t.readKeys) || len(t.arbitrary) != 0{
-		return errors.New("number of writeKeys should be equal to number of readKeys, and arbitrary data should" +
-			"be empty; sorry for that (lazy implementation)")
+	if len(t.readKeys) != len(t.readData) {
+		return ErrReadKeyDataMismatch
+	}
+	if len(t.writeKeys) != len(t.readKeys) {
+		return errors.New("number of writeKeys should be equal to number of readKeys; sorry for that (lazy " +
+			"implementation)")
 	}
 
 	return nil
 }
 
+// expired returns whether the transaction expired before the given timestamp.
+func (t *Transaction) expired(timestamp int64) bool {
+	return t.validUntil != 0 && t.validUntil < timestamp
+}
+
 // HashKey creates a compact representation of a transaction
 func (t *Transaction) HashKey() [256]byte {
 	var hashKey [256]byte
@@ -54,6 +182,13 @@ func (t *Transaction) HashKey() [256]byte {
 	return hashKey
 }
 
+// Hash returns the hash of the transaction.
+func (t *Transaction) Hash() []byte {
+	h := sha512.New512_256()
+	h.Write(t.Serialize())
+	return h.Sum(nil)
+}
+
 // Serialize converts a transaction into an array of bytes.
 // TODO: replace by a proper protocol buffer
 func (t *Transaction) Serialize() []byte {
@@ -89,6 +224,30 @@ func (t *Transaction) Serialize() []byte {
 		buff = append(buff, size...)
 		buff = append(buff, t.readData[i]...)
 	}
+	buff = appendBytes(buff, t.sender)
+	buff = appendUint64(buff, t.nonce)
+	buff = appendBytes(buff, t.expectedRoot)
+	buff = appendSlices(buff, t.dependsOn)
+	// only set flags are serialized, leaving the other transactions unchanged
+	var flags byte
+	if t.readFromPreBlock {
+		flags |= flagReadFromPreBlock
+	}
+	if len(t.arbitrary) > 0 {
+		flags |= flagArbitrary
+	}
+	if t.validUntil != 0 {
+		flags |= flagValidUntil
+	}
+	if flags != 0 {
+		buff = append(buff, flags)
+	}
+	if len(t.arbitrary) > 0 {
+		buff = appendBytes(buff, t.arbitrary)
+	}
+	if t.validUntil != 0 {
+		buff = appendUint64(buff, uint64(t.validUntil))
+	}
 
 	length := make([]byte, MaxSize)
 	binary.LittleEndian.PutUint16(length, uint16(len(buff)+MaxSize))
@@ -102,26 +261,106 @@ func (t *Transaction) Serialize() []byte {
 func Deserialize(buff []byte) (*Transaction, error) {
 	var writeKeys, newData, oldData, readKeys, readData [][]byte
 
-	tmp, size := make([]byte, len(buff)), uint16(0)
-	copy(tmp, buff)
-	_, tmp = binary.LittleEndian.Uint16(tmp[:MaxSize]), tmp[MaxSize:] // length
-	numKeys, tmp := binary.LittleEndian.Uint16(tmp[:MaxSize]), tmp[MaxSize:]
-	for i := 0; i < int(numKeys); i++ {
-		size, tmp = binary.LittleEndian.Uint16(tmp[:MaxSize]), tmp[MaxSize:]
-		writeKeys, tmp = append(writeKeys, tmp[:size]), tmp[size:]
-
-		size, tmp = binary.LittleEndian.Uint16(tmp[:MaxSize]), tmp[MaxSize:]
-		newData, tmp = append(newData, tmp[:size]), tmp[size:]
+	d := &decoder{buff}
+	length, err := d.size()
+	if err != nil {
+		return nil, err
+	}
+	if length != len(buff) {
+		return nil, errors.New("transaction does not match its length")
+	}
+	numKeys, err := d.size()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < numKeys; i++ {
+		for _, field := range []*[][]byte{&writeKeys, &newData, &oldData, &readKeys, &readData} {
+			data, err := d.bytes()
+			if err != nil {
+				return nil, err
+			}
+			*field = append(*field, data)
+		}
+	}
+	sender, err := d.bytes()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := d.uint64()
+	if err != nil {
+		return nil, err
+	}
+	expectedRoot, err := d.bytes()
+	if err != nil {
+		return nil, err
+	}
+	dependsOn, err := d.slices()
+	if err != nil {
+		return nil, err
+	}
+	var flags byte
+	if len(d.buff) > 0 {
+		flags, err = d.uint8()
+		if err != nil {
+			return nil, err
+		}
+		if flags == 0 || flags&^(flagReadFromPreBlock|flagArbitrary|flagValidUntil) != 0 {
+			return nil, errors.New("invalid transaction flags")
+		}
+	}
+	readFromPreBlock := flags&flagReadFromPreBlock != 0
+	arbitrary := []byte{}
+	if flags&flagArbitrary != 0 {
+		arbitrary, err = d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		if len(arbitrary) == 0 {
+			return nil, errors.New("invalid transaction flags")
+		}
+	}
+	var validUntil int64
+	if flags&flagValidUntil != 0 {
+		n, err := d.uint64()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, errors.New("invalid transaction flags")
+		}
+		validUntil = int64(n)
+	}
+	if len(d.buff) != 0 {
+		return nil, errors.New("trailing bytes after transaction")
+	}
 
-		size, tmp = binary.LittleEndian.Uint16(tmp[:MaxSize]), tmp[MaxSize:]
-		oldData, tmp = append(oldData, tmp[:size]), tmp[size:]
+	return NewTransaction(writeKeys, newData, oldData, readKeys, readData, arbitrary, WithSender(sender),
+		WithNonce(nonce), WithExpectedRoot(expectedRoot), WithDependencies(dependsOn...),
+		WithReadFromPreBlock(readFromPreBlock), WithValidUntil(validUntil))
+}
 
-		size, tmp = binary.LittleEndian.Uint16(tmp[:MaxSize]), tmp[MaxSize:]
-		readKeys, tmp = append(readKeys, tmp[:size]), tmp[size:]
+// VerifyTransactionAgainstState checks that the transaction's oldData and readData are committed in the state tree of
+// the given root. The proofs must contain one membership proof for each writeKey followed by one for each readKey. The
+// state tree is the one of a block with the given options (eg. WithHashAlgorithm and WithStateEncoding).
+func VerifyTransactionAgainstState(t Transaction, stateRoot []byte, proofs []smt.SparseCompactMerkleProof,
+	opts ...BlockOption) bool {
+	b := newBlock(opts...)
+	if b.newHash == nil || len(proofs) != len(t.writeKeys)+len(t.readKeys) {
+		return false
+	}
 
-		size, tmp = binary.LittleEndian.Uint16(tmp[:MaxSize]), tmp[MaxSize:]
-		readData, tmp = append(readData, tmp[:size]), tmp[size:]
+	keys := append(append([][]byte{}, t.writeKeys...), t.readKeys...)
+	values := append(append([][]byte{}, t.oldData...), t.readData...)
+	for i := 0; i < len(keys); i++ {
+		if !verifyStateProof(proofs[i], stateRoot, keys[i], b.stateEncoding.encode(values[i]), b.newHash) {
+			return false
+		}
 	}
 
-	return NewTransaction(writeKeys, newData, oldData, readKeys, readData, []byte{})
+	return true
+}
+
+// Size returns the size of the serialized transaction, in bytes.
+func (t *Transaction) Size() int {
+	return len(t.Serialize())
 }
@@ -0,0 +1,91 @@
+package fraudproofs
+
+// CheckMaxTransactions checks that the block holds at most maxTransactions transactions, a consensus cap on the size of
+// blocks, and returns a fraud proof if it holds more. The fraud proof reveals the chunks from the start of the data up
+// to the chunk where the first transaction over the cap starts, so that the verifier counts the transactions preceding
+// it.
+func (b *Block) CheckMaxTransactions(maxTransactions uint64) (*FraudProof, error) {
+	if b.numTransactions <= maxTransactions {
+		return nil, nil
+	}
+	if uint64(len(b.transactions)) != b.numTransactions {
+		return nil, ErrTransactionCount
+	}
+	tree, err := b.ensureDataTree()
+	if err != nil {
+		return nil, err
+	}
+
+	last := tree.positions[maxTransactions] / (b.chunkSize - 1)
+	chunksIndexes := make([]uint64, last+1)
+	for j := 0; j <= last; j++ {
+		chunksIndexes[j] = uint64(j)
+	}
+	proofChunks, err := b.proveLeadingChunks(tree.chunks, last+1)
+	if err != nil {
+		return nil, err
+	}
+	return &FraudProof{
+		kind:          KindTooManyTransactions,
+		chunks:        copySlices(tree.chunks[:last+1]),
+		proofChunks:   proofChunks,
+		txIndex:       maxTransactions,
+		chunkSize:     uint64(b.chunkSize),
+		chunksIndexes: chunksIndexes,
+		numOfLeaves:   uint64(len(tree.chunks)),
+		newHash:       b.newHash,
+		hashAlgorithm: b.hashAlgorithm,
+		stateEncoding: b.stateEncoding,
+	}, nil
+}
+
+// VerifyTooManyTransactionsFraudProof verifies a fraud proof claiming that the block of the header holds more than
+// maxTransactions transactions, from the header alone: the fraud proof carries the cap as its transaction index, and
+// the chunks from the start of the data of the block, in the data tree of the header, are walked up to the start of
+// the transaction following the first maxTransactions ones. A zero cap means no cap, so that the zero VerifyPolicy
+// rejects every such fraud proof.
+func (h BlockHeader) VerifyTooManyTransactionsFraudProof(fp FraudProof, maxTransactions uint64) bool {
+	if maxTransactions == 0 || fp.Validate() != nil || fp.kind != KindTooManyTransactions ||
+		fp.hashAlgorithm != h.hashAlgorithm {
+		return false
+	}
+	if fp.txIndex != maxTransactions || h.numTransactions <= maxTransactions {
+		return false
+	}
+	newHash, err := h.hashAlgorithm.newHash()
+	if err != nil {
+		return false
+	}
+
+	var buff []byte
+	for i := 0; i < len(fp.chunks); i++ {
+		if fp.chunksIndexes[i] != uint64(i) || len(fp.chunks[i]) == 0 {
+			return false // the entries are only known to start at the start of the data
+		}
+		buff = append(buff, fp.chunks[i][1:]...)
+	}
+	if !startsTransaction(buff, newHash().Size(), maxTransactions) {
+		return false
+	}
+	return fp.verifyChunks(h.dataRoot, newHash, h.dataTreeScheme)
+}
+
+// startsTransaction returns whether the data of the chunks, laid out from its start, holds the start of the n-th
+// transaction (from 0).
+func startsTransaction(buff []byte, rootSize int, n uint64) bool {
+	pos, count, i := 0, 0, uint64(0)
+	for pos < len(buff) {
+		if LeafKind(buff[pos]) == LeafTransaction && count != Step {
+			if i == n {
+				return true
+			}
+			i++
+		}
+		var violation int
+		pos, count, violation = nextEntry(buff, pos, count, rootSize)
+		if violation >= 0 {
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package fraudproofs
+
+import (
+	"github.com/lazyledger/smt"
+)
+
+// StateReader reads the data committed in the state.
+type StateReader interface {
+	// Get returns the data committed in the state for the given key.
+	Get(key []byte) ([]byte, error)
+}
+
+// stateTreeReader reads the data committed in a state tree with the given encoding.
+type stateTreeReader struct {
+	stateTree *smt.SparseMerkleTree
+	encoding  StateEncoding
+}
+
+// Get returns the data committed in the state tree for the given key.
+func (r stateTreeReader) Get(key []byte) ([]byte, error) {
+	value, err := r.stateTree.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return r.encoding.decode(value), nil
+}
+
+// NewBlockWithValidator creates a new block with the given transactions, after checking each of them with the
+// application-specific validity function; the function reads the state preceding the block. The first error returned
+// by the function is returned, and the state tree is left untouched. The state is decoded with the state encoding of
+// the block.
+func NewBlockWithValidator(t []Transaction, stateTree *smt.SparseMerkleTree,
+	validate func(Transaction, StateReader) error, opts ...BlockOption) (*Block, error) {
+	if stateTree == nil {
+		return nil, ErrNilStateTree
+	}
+	reader := stateTreeReader{stateTree, newBlock(opts...).stateEncoding}
+	for i := 0; i < len(t); i++ {
+		err := validate(t[i], reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewBlock(t, stateTree, opts...)
+}
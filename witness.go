@@ -0,0 +1,78 @@
+package fraudproofs
+
+import (
+	"encoding/binary"
+)
+
+// witness lays out the inputs of a fraud proof as a sequence of words of the width of the hash function.
+type witness struct {
+	buff  []byte
+	width int
+}
+
+// integer appends a fixed-width integer, big-endian in a single word.
+func (w *witness) integer(n uint64) {
+	word := make([]byte, w.width)
+	binary.BigEndian.PutUint64(word[w.width-8:], n)
+	w.buff = append(w.buff, word...)
+}
+
+// element appends the length of the data in a word, followed by the data zero-padded to a multiple of the word width.
+func (w *witness) element(data []byte) {
+	w.integer(uint64(len(data)))
+	padded := make([]byte, (len(data)+w.width-1)/w.width*w.width)
+	copy(padded, data)
+	w.buff = append(w.buff, padded...)
+}
+
+// elements appends the number of elements in a word, followed by the elements.
+func (w *witness) elements(data [][]byte) {
+	w.integer(uint64(len(data)))
+	for i := 0; i < len(data); i++ {
+		w.element(data[i])
+	}
+}
+
+// Witness returns the inputs of the fraud proof in a fixed layout suited to arithmetic circuits: every value takes
+// whole words of the width of the hash function, integers (kind, indexes, sizes and counts) take one word each, and
+// arrays of bytes are prefixed by their length and zero-padded. The layout only depends on the shape of the fraud
+// proof (number and length of its elements), and follows the order of the fields of the serialization format.
+func (fp *FraudProof) Witness() ([]byte, error) {
+	err := fp.Validate()
+	if err != nil {
+		return nil, err
+	}
+	w := &witness{width: fp.hashFunction()().Size()}
+
+	w.integer(uint64(fp.kind))
+	for _, field := range [][][]byte{fp.writeKeys, fp.oldData, fp.readKeys, fp.readData} {
+		w.elements(field)
+	}
+	w.integer(uint64(len(fp.proofState)))
+	for i := 0; i < len(fp.proofState); i++ {
+		w.elements(fp.proofState[i])
+	}
+	w.elements(fp.chunks)
+	w.integer(uint64(len(fp.proofChunks)))
+	for i := 0; i < len(fp.proofChunks); i++ {
+		w.elements(fp.proofChunks[i])
+	}
+	w.integer(uint64(len(fp.chunksIndexes)))
+	for i := 0; i < len(fp.chunksIndexes); i++ {
+		w.integer(fp.chunksIndexes[i])
+	}
+	w.integer(fp.numOfLeaves)
+	w.integer(fp.txIndex)
+	w.integer(fp.offset)
+	w.element(fp.stateRoot)
+	w.integer(fp.chunkSize)
+	if fp.hashedKeys {
+		w.integer(1)
+	} else {
+		w.integer(0)
+	}
+	w.element(fp.postStateRoot)
+	w.integer(fp.duplicateIndex)
+	w.integer(uint64(fp.hashAlgorithm))
+	return w.buff, nil
+}